@@ -5,16 +5,18 @@ go 1.18
 require (
 	github.com/BurntSushi/toml v1.2.0
 	github.com/containers/image/v5 v5.22.0
+	github.com/go-logr/logr v1.2.3
 	github.com/openshift/api v0.0.0-20220901185337-0b39f81154fa
 	github.com/openshift/build-machinery-go v0.0.0-20220720161851-9b4f0386f6b0
 	github.com/stretchr/testify v1.8.0
+	k8s.io/apimachinery v0.25.0
+	k8s.io/klog/v2 v2.70.1
 )
 
 require (
 	github.com/containers/storage v1.42.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/docker/go-units v0.4.0 // indirect
-	github.com/go-logr/logr v1.2.3 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/google/gofuzz v1.1.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
@@ -35,9 +37,8 @@ require (
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	k8s.io/api v0.25.0 // indirect
-	k8s.io/apimachinery v0.25.0 // indirect
-	k8s.io/klog/v2 v2.70.1 // indirect
 	k8s.io/utils v0.0.0-20220728103510-ee6ede2d64ed // indirect
 	sigs.k8s.io/json v0.0.0-20220713155537-f223a00ba0e2 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.2.3 // indirect
+	sigs.k8s.io/yaml v1.2.0 // indirect
 )
@@ -0,0 +1,29 @@
+package registries
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// RenderBlockedList returns the scopes (as in sysregistriesv2.Registry.Prefix) of the blocked registry entries of conf,
+// sorted and without duplicates, one per line (each terminated by "\n"), e.g. to write a deny-list file for an enforcement
+// layer that doesn't read registries.conf. Wildcard scopes are written as-is (e.g. *.example.com).
+// Blocked entries with mirrors are included: only their sources are blocked, the mirrors can still be used.
+// If no entry is blocked, the result is empty.
+func RenderBlockedList(conf *sysregistriesv2.V2RegistriesConf) []byte {
+	scopes := []string{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if reg.Blocked {
+			scopes = appendUnique(scopes, registryScope(reg))
+		}
+	}
+	sort.Strings(scopes)
+	res := strings.Builder{}
+	for _, scope := range scopes {
+		res.WriteString(scope + "\n")
+	}
+	return []byte(res.String())
+}
@@ -0,0 +1,31 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderBlockedList(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com/ns"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")},
+				Blocked:  true,
+			},
+			{Prefix: "*.example.com", Blocked: true},
+			{Prefix: "registry-c.com", Endpoint: sysregistriesv2.Endpoint{Location: "registry-c-proxy.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Blocked: true}, // Duplicate
+		},
+	}
+	assert.Equal(t, "*.example.com\nregistry-a.com/ns\nregistry-b.com\nregistry-c.com\n", string(RenderBlockedList(&conf)))
+
+	assert.Empty(t, RenderBlockedList(&sysregistriesv2.V2RegistriesConf{}))
+	assert.Empty(t, RenderBlockedList(&sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}}},
+	}))
+}
@@ -0,0 +1,192 @@
+package registries
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// RenderDelta returns a human-readable description of the differences between the results of editing base with oldOpts and
+// with newOpts (see PlanRegistriesConfig and DiffRegistriesConf), e.g. to review what a change of the mirror configuration
+// objects would change on the nodes. base is not modified, and warnings of both edits are discarded.
+func RenderDelta(base *sysregistriesv2.V2RegistriesConf, oldOpts, newOpts EditOptions) ([]string, error) {
+	oldConf, _, err := PlanRegistriesConfig(base, oldOpts)
+	if err != nil {
+		return nil, fmt.Errorf("old options: %w", err)
+	}
+	newConf, _, err := PlanRegistriesConfig(base, newOpts)
+	if err != nil {
+		return nil, fmt.Errorf("new options: %w", err)
+	}
+	return DiffRegistriesConf(oldConf, newConf), nil
+}
+
+// DiffRegistriesConf returns a human-readable description of the differences between oldConf and newConf, one change per line:
+// changes of the unqualified search registries and short name mode, followed by registry entries which were added, removed or
+// changed, sorted by scope. Entries are matched by scope (as in sysregistriesv2.Registry.Prefix); if a configuration has several entries
+// with the same scope, only the first one is compared, like in sysregistriesv2.
+// Mirrors of an entry are matched by location and pull-from-mirror value; a change in the order of the remaining mirrors is reported
+// as well, because it affects the order in which they are tried. Returns an empty list if the configurations are equivalent.
+func DiffRegistriesConf(oldConf, newConf *sysregistriesv2.V2RegistriesConf) []string {
+	res := []string{}
+	if strings.Join(oldConf.UnqualifiedSearchRegistries, ",") != strings.Join(newConf.UnqualifiedSearchRegistries, ",") {
+		res = append(res, fmt.Sprintf("unqualified-search-registries changed from %#v to %#v", oldConf.UnqualifiedSearchRegistries, newConf.UnqualifiedSearchRegistries))
+	}
+	if oldConf.ShortNameMode != newConf.ShortNameMode {
+		res = append(res, fmt.Sprintf("short-name-mode changed from %#v to %#v", oldConf.ShortNameMode, newConf.ShortNameMode))
+	}
+
+	oldRegs, newRegs := registriesByScope(oldConf), registriesByScope(newConf)
+	scopes := []string{}
+	for scope := range oldRegs {
+		scopes = append(scopes, scope)
+	}
+	for scope := range newRegs {
+		if _, ok := oldRegs[scope]; !ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		oldReg, inOld := oldRegs[scope]
+		newReg, inNew := newRegs[scope]
+		switch {
+		case !inOld:
+			res = append(res, fmt.Sprintf("registry %#v added", scope))
+			for _, mirror := range newReg.Mirrors {
+				res = append(res, fmt.Sprintf("registry %#v: mirror %s added", scope, describeMirror(mirror)))
+			}
+			continue
+		case !inNew:
+			res = append(res, fmt.Sprintf("registry %#v removed", scope))
+			continue
+		}
+		if oldReg.Location != newReg.Location {
+			res = append(res, fmt.Sprintf("registry %#v: location changed from %#v to %#v", scope, oldReg.Location, newReg.Location))
+		}
+		if oldReg.Insecure != newReg.Insecure {
+			res = append(res, fmt.Sprintf("registry %#v: insecure changed from %t to %t", scope, oldReg.Insecure, newReg.Insecure))
+		}
+		if oldReg.Blocked != newReg.Blocked {
+			res = append(res, fmt.Sprintf("registry %#v: blocked changed from %t to %t", scope, oldReg.Blocked, newReg.Blocked))
+		}
+		if oldReg.MirrorByDigestOnly != newReg.MirrorByDigestOnly {
+			res = append(res, fmt.Sprintf("registry %#v: mirror-by-digest-only changed from %t to %t", scope, oldReg.MirrorByDigestOnly, newReg.MirrorByDigestOnly))
+		}
+		res = append(res, diffMirrors(scope, oldReg.Mirrors, newReg.Mirrors)...)
+	}
+	return res
+}
+
+// ChangedScopes returns the scopes (as in sysregistriesv2.Registry.Prefix) of registry entries which differ between oldConf and
+// newConf: entries which exist in only one of them, and entries with different settings or mirrors (including a different order
+// of mirrors). The result is sorted. Entries are matched by scope like in DiffRegistriesConf; all other settings are ignored.
+func ChangedScopes(oldConf, newConf *sysregistriesv2.V2RegistriesConf) []string {
+	oldRegs, newRegs := registriesByScope(oldConf), registriesByScope(newConf)
+	res := []string{}
+	for scope, oldReg := range oldRegs {
+		if newReg, ok := newRegs[scope]; !ok || !registriesEqual(oldReg, newReg) {
+			res = append(res, scope)
+		}
+	}
+	for scope := range newRegs {
+		if _, ok := oldRegs[scope]; !ok {
+			res = append(res, scope)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// registriesEqual returns true if a and b have the same settings and mirrors, in the same order.
+func registriesEqual(a, b *sysregistriesv2.Registry) bool {
+	if a.Prefix != b.Prefix || a.Endpoint != b.Endpoint || a.Blocked != b.Blocked || a.MirrorByDigestOnly != b.MirrorByDigestOnly ||
+		len(a.Mirrors) != len(b.Mirrors) {
+		return false
+	}
+	for i := range a.Mirrors {
+		if a.Mirrors[i] != b.Mirrors[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// registriesByScope returns the registry entries of conf indexed by scope; of entries with the same scope, the first one is used.
+func registriesByScope(conf *sysregistriesv2.V2RegistriesConf) map[string]*sysregistriesv2.Registry {
+	res := map[string]*sysregistriesv2.Registry{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if _, ok := res[registryScope(reg)]; !ok {
+			res[registryScope(reg)] = reg
+		}
+	}
+	return res
+}
+
+// mirrorKey identifies a mirror within a registry entry, for diffMirrors.
+type mirrorKey struct {
+	location, pullFromMirror string
+}
+
+// diffMirrors returns a description of the differences between oldMirrors and newMirrors, the mirrors of the registry entry
+// with scope, for DiffRegistriesConf.
+func diffMirrors(scope string, oldMirrors, newMirrors []sysregistriesv2.Endpoint) []string {
+	index := func(mirrors []sysregistriesv2.Endpoint) map[mirrorKey]sysregistriesv2.Endpoint {
+		res := map[mirrorKey]sysregistriesv2.Endpoint{}
+		for _, mirror := range mirrors {
+			if _, ok := res[mirrorKey{mirror.Location, mirror.PullFromMirror}]; !ok {
+				res[mirrorKey{mirror.Location, mirror.PullFromMirror}] = mirror
+			}
+		}
+		return res
+	}
+	oldIndex, newIndex := index(oldMirrors), index(newMirrors)
+
+	res := []string{}
+	oldCommon, newCommon := []string{}, []string{} // Mirrors present in both, in their respective order
+	seen := map[mirrorKey]struct{}{}
+	for _, mirror := range oldMirrors {
+		key := mirrorKey{mirror.Location, mirror.PullFromMirror}
+		if _, ok := seen[key]; ok { // A duplicate, only the first one is compared
+			continue
+		}
+		seen[key] = struct{}{}
+		newMirror, ok := newIndex[key]
+		if !ok {
+			res = append(res, fmt.Sprintf("registry %#v: mirror %s removed", scope, describeMirror(mirror)))
+			continue
+		}
+		if mirror.Insecure != newMirror.Insecure {
+			res = append(res, fmt.Sprintf("registry %#v: mirror %s: insecure changed from %t to %t", scope, describeMirror(mirror), mirror.Insecure, newMirror.Insecure))
+		}
+		oldCommon = append(oldCommon, describeMirror(mirror))
+	}
+	seen = map[mirrorKey]struct{}{}
+	for _, mirror := range newMirrors {
+		key := mirrorKey{mirror.Location, mirror.PullFromMirror}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if _, ok := oldIndex[key]; !ok {
+			res = append(res, fmt.Sprintf("registry %#v: mirror %s added", scope, describeMirror(mirror)))
+			continue
+		}
+		newCommon = append(newCommon, describeMirror(mirror))
+	}
+	if strings.Join(oldCommon, ", ") != strings.Join(newCommon, ", ") {
+		res = append(res, fmt.Sprintf("registry %#v: mirrors reordered from [%s] to [%s]", scope, strings.Join(oldCommon, ", "), strings.Join(newCommon, ", ")))
+	}
+	return res
+}
+
+// describeMirror returns a description of mirror for DiffRegistriesConf: its location, and its pull-from-mirror value, if any.
+func describeMirror(mirror sysregistriesv2.Endpoint) string {
+	if mirror.PullFromMirror == "" {
+		return fmt.Sprintf("%#v", mirror.Location)
+	}
+	return fmt.Sprintf("%#v (pull-from-mirror %#v)", mirror.Location, mirror.PullFromMirror)
+}
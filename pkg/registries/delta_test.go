@@ -0,0 +1,144 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiffRegistriesConf(t *testing.T) {
+	oldConf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					NewDigestMirror("mirror-1.example.com/ns"),
+					NewDigestMirror("mirror-2.example.com/ns"),
+					NewTagMirror("mirror-3.example.com/ns"),
+					NewDigestMirror("mirror-4.example.com/ns"),
+				},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "removed.example.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "changed.example.com"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.net")}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "unchanged.example.com", Insecure: true}},
+		},
+	}
+	assert.Equal(t, []string{}, DiffRegistriesConf(&oldConf, CopyRegistriesConf(&oldConf)))
+
+	insecureMirror := NewDigestMirror("mirror-2.example.com/ns")
+	insecureMirror.Insecure = true
+	newConf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "unchanged.example.com", Insecure: true}}, // The order of entries doesn't matter
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					NewDigestMirror("mirror-4.example.com/ns"),
+					insecureMirror,
+					NewDigestMirror("mirror-3.example.com/ns"), // Another pull-from-mirror value
+					NewDigestMirror("mirror-5.example.com/ns"),
+				},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "changed.example.com", Insecure: true}, Blocked: true, MirrorByDigestOnly: true},
+			{Prefix: "*.example.org", Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org")}},
+		},
+	}
+	assert.Equal(t, []string{
+		`unqualified-search-registries changed from []string{"registry.access.redhat.com", "docker.io"} to []string{"registry.access.redhat.com"}`,
+		`short-name-mode changed from "" to "enforcing"`,
+		`registry "*.example.org" added`,
+		`registry "*.example.org": mirror "mirror.example.org" (pull-from-mirror "digest-only") added`,
+		`registry "changed.example.com": insecure changed from false to true`,
+		`registry "changed.example.com": blocked changed from false to true`,
+		`registry "changed.example.com": mirror-by-digest-only changed from false to true`,
+		`registry "changed.example.com": mirror "mirror.example.net" (pull-from-mirror "digest-only") removed`,
+		`registry "quay.io/ns": mirror "mirror-1.example.com/ns" (pull-from-mirror "digest-only") removed`,
+		`registry "quay.io/ns": mirror "mirror-2.example.com/ns" (pull-from-mirror "digest-only"): insecure changed from false to true`,
+		`registry "quay.io/ns": mirror "mirror-3.example.com/ns" (pull-from-mirror "tag-only") removed`,
+		`registry "quay.io/ns": mirror "mirror-3.example.com/ns" (pull-from-mirror "digest-only") added`,
+		`registry "quay.io/ns": mirror "mirror-5.example.com/ns" (pull-from-mirror "digest-only") added`,
+		`registry "quay.io/ns": mirrors reordered from ["mirror-2.example.com/ns" (pull-from-mirror "digest-only"), "mirror-4.example.com/ns" (pull-from-mirror "digest-only")] to ["mirror-4.example.com/ns" (pull-from-mirror "digest-only"), "mirror-2.example.com/ns" (pull-from-mirror "digest-only")]`,
+		`registry "removed.example.com" removed`,
+	}, DiffRegistriesConf(&oldConf, &newConf))
+
+	// Only the first of entries with the same scope is compared
+	duplicate := CopyRegistriesConf(&oldConf)
+	duplicate.Registries = append(duplicate.Registries, sysregistriesv2.Registry{Endpoint: sysregistriesv2.Endpoint{Location: "unchanged.example.com"}})
+	assert.Equal(t, []string{}, DiffRegistriesConf(&oldConf, duplicate))
+}
+
+func TestRenderDelta(t *testing.T) {
+	idms := func(mirrors ...apicfgv1.ImageMirror) []*apicfgv1.ImageDigestMirrorSet {
+		return []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{{Source: "quay.io/ns", Mirrors: mirrors}},
+				},
+			},
+		}
+	}
+	base := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry.example.com"}, Blocked: true},
+		},
+	}
+	original := CopyRegistriesConf(&base)
+
+	res, err := RenderDelta(&base, EditOptions{
+		IDMSRules: idms("mirror-1.example.com/ns"),
+	}, EditOptions{
+		BlockedScopes: []string{"blocked.example.com"},
+		IDMSRules:     idms("mirror-2.example.com/ns", "mirror-1.example.com/ns"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`registry "blocked.example.com" added`,
+		`registry "quay.io/ns": mirror "mirror-2.example.com/ns" (pull-from-mirror "digest-only") added`,
+	}, res)
+	assert.Equal(t, original, &base)
+
+	res, err = RenderDelta(&base, EditOptions{IDMSRules: idms("mirror-1.example.com/ns")}, EditOptions{IDMSRules: idms("mirror-1.example.com/ns")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, res)
+
+	_, err = RenderDelta(&base, EditOptions{BlockedScopes: []string{"*.example.com/ns"}}, EditOptions{})
+	assert.ErrorContains(t, err, "old options: blocked scopes: ")
+	_, err = RenderDelta(&base, EditOptions{}, EditOptions{BlockedScopes: []string{"*.example.com/ns"}})
+	assert.ErrorContains(t, err, "new options: blocked scopes: ")
+	assert.Equal(t, original, &base)
+}
+
+func TestChangedScopes(t *testing.T) {
+	oldConf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "unchanged.example.com"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/unchanged")}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "removed.example.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.com"}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror-1.example.com"), NewDigestMirror("mirror-2.example.com")}},
+			{Prefix: "*.example.org", Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org")}},
+		},
+	}
+	assert.Equal(t, []string{}, ChangedScopes(&oldConf, CopyRegistriesConf(&oldConf)))
+
+	newConf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"docker.io"}, // Not a registry entry
+		Registries: []sysregistriesv2.Registry{
+			{Prefix: "*.example.org", Mirrors: []sysregistriesv2.Endpoint{NewTagMirror("mirror.example.org")}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "added.example.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.com", Insecure: true}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror-2.example.com"), NewDigestMirror("mirror-1.example.com")}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "unchanged.example.com"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/unchanged")}},
+		},
+	}
+	assert.Equal(t, []string{"*.example.org", "added.example.com", "insecure.example.com", "quay.io", "removed.example.com"}, ChangedScopes(&oldConf, &newConf))
+}
@@ -0,0 +1,61 @@
+package registries
+
+import (
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// NewDigestMirror returns a mirror endpoint for location which is only used for pulls by digest,
+// which is how EditRegistriesConfig configures mirrors of ImageDigestMirrorSet and ImageContentSourcePolicy sources.
+// Note that the zero value of PullFromMirror means that the mirror is used for all pulls.
+func NewDigestMirror(location string) sysregistriesv2.Endpoint {
+	return sysregistriesv2.Endpoint{Location: location, PullFromMirror: sysregistriesv2.MirrorByDigestOnly}
+}
+
+// NewTagMirror returns a mirror endpoint for location which is only used for pulls by tag,
+// which is how EditRegistriesConfig configures mirrors of ImageTagMirrorSet sources.
+func NewTagMirror(location string) sysregistriesv2.Endpoint {
+	return sysregistriesv2.Endpoint{Location: location, PullFromMirror: sysregistriesv2.MirrorByTagOnly}
+}
+
+// CoalesceMirrorModes returns a copy of endpoints, the mirrors of a registry entry, where each digest-only and tag-only mirror
+// with the same location and insecure flag as a mirror with the other of these modes is replaced by a single mirror with
+// pull-from-mirror "all", at the position of the earliest of them; e.g. to simplify a configuration generated from an
+// ImageDigestMirrorSet and an ImageTagMirrorSet listing the same mirror. All other mirrors are kept unchanged, in order.
+// Mirrors without an explicit pull-from-mirror value are not coalesced, because their mode depends on the
+// mirror-by-digest-only setting of the entry.
+// Note that the coalesced mirror is tried at the earliest position for both digest and tag pulls, which can change
+// the order in which mirrors are tried for one of them.
+func CoalesceMirrorModes(endpoints []sysregistriesv2.Endpoint) []sysregistriesv2.Endpoint {
+	type key struct {
+		location string
+		insecure bool
+	}
+	digest, tag := map[key]struct{}{}, map[key]struct{}{}
+	for _, endpoint := range endpoints {
+		switch endpoint.PullFromMirror {
+		case sysregistriesv2.MirrorByDigestOnly:
+			digest[key{endpoint.Location, endpoint.Insecure}] = struct{}{}
+		case sysregistriesv2.MirrorByTagOnly:
+			tag[key{endpoint.Location, endpoint.Insecure}] = struct{}{}
+		}
+	}
+
+	res := []sysregistriesv2.Endpoint{}
+	coalesced := map[key]struct{}{}
+	for _, endpoint := range endpoints {
+		k := key{endpoint.Location, endpoint.Insecure}
+		_, isDigest := digest[k]
+		_, isTag := tag[k]
+		if !isDigest || !isTag || (endpoint.PullFromMirror != sysregistriesv2.MirrorByDigestOnly && endpoint.PullFromMirror != sysregistriesv2.MirrorByTagOnly) {
+			res = append(res, endpoint)
+			continue
+		}
+		if _, ok := coalesced[k]; ok {
+			continue
+		}
+		coalesced[k] = struct{}{}
+		endpoint.PullFromMirror = sysregistriesv2.MirrorAll
+		res = append(res, endpoint)
+	}
+	return res
+}
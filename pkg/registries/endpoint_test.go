@@ -0,0 +1,88 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDigestMirror(t *testing.T) {
+	assert.Equal(t, sysregistriesv2.Endpoint{Location: "mirror.example.com/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+		NewDigestMirror("mirror.example.com/ns"))
+}
+
+func TestNewTagMirror(t *testing.T) {
+	assert.Equal(t, sysregistriesv2.Endpoint{Location: "mirror.example.com/ns", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+		NewTagMirror("mirror.example.com/ns"))
+}
+
+func TestCoalesceMirrorModes(t *testing.T) {
+	insecure := func(endpoint sysregistriesv2.Endpoint) sysregistriesv2.Endpoint {
+		endpoint.Insecure = true
+		return endpoint
+	}
+	for _, tt := range []struct {
+		name      string
+		endpoints []sysregistriesv2.Endpoint
+		expected  []sysregistriesv2.Endpoint
+	}{
+		{
+			name:      "empty",
+			endpoints: nil,
+			expected:  []sysregistriesv2.Endpoint{},
+		},
+		{
+			name:      "digest and tag",
+			endpoints: []sysregistriesv2.Endpoint{NewDigestMirror("mirror-1.example.com"), NewTagMirror("mirror-2.example.com"), NewTagMirror("mirror-1.example.com")},
+			expected: []sysregistriesv2.Endpoint{
+				{Location: "mirror-1.example.com", PullFromMirror: sysregistriesv2.MirrorAll},
+				NewTagMirror("mirror-2.example.com"),
+			},
+		},
+		{
+			name:      "tag first",
+			endpoints: []sysregistriesv2.Endpoint{NewDigestMirror("mirror-2.example.com"), NewTagMirror("mirror-1.example.com"), NewDigestMirror("mirror-1.example.com")},
+			expected: []sysregistriesv2.Endpoint{
+				NewDigestMirror("mirror-2.example.com"),
+				{Location: "mirror-1.example.com", PullFromMirror: sysregistriesv2.MirrorAll},
+			},
+		},
+		{
+			name: "duplicates",
+			endpoints: []sysregistriesv2.Endpoint{
+				NewDigestMirror("mirror-1.example.com"), NewDigestMirror("mirror-1.example.com"), NewTagMirror("mirror-1.example.com"), NewTagMirror("mirror-1.example.com"),
+			},
+			expected: []sysregistriesv2.Endpoint{{Location: "mirror-1.example.com", PullFromMirror: sysregistriesv2.MirrorAll}},
+		},
+		{
+			name: "different insecure flags",
+			endpoints: []sysregistriesv2.Endpoint{
+				NewDigestMirror("mirror-1.example.com"), insecure(NewTagMirror("mirror-1.example.com")), insecure(NewDigestMirror("mirror-1.example.com")),
+			},
+			expected: []sysregistriesv2.Endpoint{
+				NewDigestMirror("mirror-1.example.com"),
+				{Location: "mirror-1.example.com", Insecure: true, PullFromMirror: sysregistriesv2.MirrorAll},
+			},
+		},
+		{
+			name: "only one mode, or no explicit mode",
+			endpoints: []sysregistriesv2.Endpoint{
+				NewDigestMirror("mirror-1.example.com"), NewDigestMirror("mirror-1.example.com"),
+				{Location: "mirror-2.example.com"}, NewTagMirror("mirror-2.example.com"),
+				{Location: "mirror-3.example.com", PullFromMirror: sysregistriesv2.MirrorAll}, NewDigestMirror("mirror-3.example.com"),
+			},
+			expected: []sysregistriesv2.Endpoint{
+				NewDigestMirror("mirror-1.example.com"), NewDigestMirror("mirror-1.example.com"),
+				{Location: "mirror-2.example.com"}, NewTagMirror("mirror-2.example.com"),
+				{Location: "mirror-3.example.com", PullFromMirror: sysregistriesv2.MirrorAll}, NewDigestMirror("mirror-3.example.com"),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]sysregistriesv2.Endpoint(nil), tt.endpoints...)
+			assert.Equal(t, tt.expected, CoalesceMirrorModes(tt.endpoints))
+			assert.Equal(t, original, tt.endpoints)
+		})
+	}
+}
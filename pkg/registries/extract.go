@@ -0,0 +1,66 @@
+package registries
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+)
+
+// ExtractMirrorSets converts the mirror configuration in conf into ImageDigestMirrorSet and ImageTagMirrorSet objects,
+// e.g. to migrate a hand-written registries.conf to mirror setting CRs; this is the inverse of the mirror handling of
+// EditRegistriesConfig.
+// Mirrors of each registry entry are grouped by their pull-from-mirror mode: digest-only mirrors become an ImageDigestMirrors
+// entry, tag-only mirrors an ImageTagMirrors entry, in their original order. A blocked registry entry with mirrors
+// becomes a mirror set with mirrorSourcePolicy NeverContactSource; blocked entries without mirrors, and the insecure flags,
+// are not mirror configuration and are not represented in the result.
+// It returns an error for mirrors which allow both digest and tag pulls (pull-from-mirror "all", or unset without
+// mirror-by-digest-only), because they can't be split into mirror sets.
+// The result contains at most one object of each kind, with empty ObjectMeta; callers should set the names.
+func ExtractMirrorSets(conf *sysregistriesv2.V2RegistriesConf) ([]*apicfgv1.ImageDigestMirrorSet, []*apicfgv1.ImageTagMirrorSet, error) {
+	digestMirrors := []apicfgv1.ImageDigestMirrors{}
+	tagMirrors := []apicfgv1.ImageTagMirrors{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if len(reg.Mirrors) == 0 {
+			continue
+		}
+		scope := registryScope(reg)
+		policy := apicfgv1.MirrorSourcePolicy("")
+		if reg.Blocked {
+			policy = apicfgv1.NeverContactSource
+		}
+		digestLocations, tagLocations := []apicfgv1.ImageMirror{}, []apicfgv1.ImageMirror{}
+		for _, mirror := range reg.Mirrors {
+			mode := mirror.PullFromMirror
+			if mode == "" && reg.MirrorByDigestOnly {
+				mode = sysregistriesv2.MirrorByDigestOnly
+			}
+			switch mode {
+			case sysregistriesv2.MirrorByDigestOnly:
+				digestLocations = append(digestLocations, apicfgv1.ImageMirror(mirror.Location))
+			case sysregistriesv2.MirrorByTagOnly:
+				tagLocations = append(tagLocations, apicfgv1.ImageMirror(mirror.Location))
+			default:
+				return nil, nil, fmt.Errorf("mirror %#v of registry %#v allows pulls both by digest and by tag (pull-from-mirror %#v), which can't be split into mirror sets",
+					mirror.Location, scope, mirror.PullFromMirror)
+			}
+		}
+		if len(digestLocations) != 0 {
+			digestMirrors = append(digestMirrors, apicfgv1.ImageDigestMirrors{Source: scope, Mirrors: digestLocations, MirrorSourcePolicy: policy})
+		}
+		if len(tagLocations) != 0 {
+			tagMirrors = append(tagMirrors, apicfgv1.ImageTagMirrors{Source: scope, Mirrors: tagLocations, MirrorSourcePolicy: policy})
+		}
+	}
+
+	idms := []*apicfgv1.ImageDigestMirrorSet{}
+	if len(digestMirrors) != 0 {
+		idms = append(idms, &apicfgv1.ImageDigestMirrorSet{Spec: apicfgv1.ImageDigestMirrorSetSpec{ImageDigestMirrors: digestMirrors}})
+	}
+	itms := []*apicfgv1.ImageTagMirrorSet{}
+	if len(tagMirrors) != 0 {
+		itms = append(itms, &apicfgv1.ImageTagMirrorSet{Spec: apicfgv1.ImageTagMirrorSetSpec{ImageTagMirrors: tagMirrors}})
+	}
+	return idms, itms, nil
+}
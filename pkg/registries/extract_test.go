@@ -0,0 +1,98 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractMirrorSets(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					{Location: "mirror-1.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+					{Location: "mirror-2.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+					{Location: "mirror-3.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				},
+			},
+			{ // Blocked, without mirrors: not a mirror configuration
+				Endpoint: sysregistriesv2.Endpoint{Location: "blocked.com"},
+				Blocked:  true,
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com/ns", Insecure: true},
+				Blocked:  true,
+				Mirrors: []sysregistriesv2.Endpoint{
+					{Location: "mirror.registry-b.com/ns", PullFromMirror: sysregistriesv2.MirrorByTagOnly, Insecure: true},
+				},
+			},
+			{ // Legacy mirror-by-digest-only
+				Prefix:             "*.registry-c.com",
+				MirrorByDigestOnly: true,
+				Mirrors: []sysregistriesv2.Endpoint{
+					{Location: "mirror.registry-c.com"},
+				},
+			},
+		},
+	}
+	idms, itms, err := ExtractMirrorSets(&conf)
+	require.NoError(t, err)
+	assert.Equal(t, []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.registry-a.com", "mirror-3.registry-a.com"}},
+					{Source: "*.registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-c.com"}},
+				},
+			},
+		},
+	}, idms)
+	assert.Equal(t, []*apicfgv1.ImageTagMirrorSet{
+		{
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-a.com"}},
+					{Source: "registry-b.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com/ns"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+				},
+			},
+		},
+	}, itms)
+
+	// No mirrors
+	idms, itms, err = ExtractMirrorSets(&sysregistriesv2.V2RegistriesConf{})
+	require.NoError(t, err)
+	assert.Empty(t, idms)
+	assert.Empty(t, itms)
+
+	// Mirrors that allow both digest and tag pulls can't be split
+	for _, mode := range []string{"", sysregistriesv2.MirrorAll} {
+		_, _, err = ExtractMirrorSets(&sysregistriesv2.V2RegistriesConf{
+			Registries: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+					Mirrors:  []sysregistriesv2.Endpoint{{Location: "mirror.registry-a.com", PullFromMirror: mode}},
+				},
+			},
+		})
+		assert.Error(t, err, mode)
+	}
+}
+
+func TestExtractMirrorSetsRoundTrip(t *testing.T) {
+	tc := findEditRegistriesConfigTestcase(t, "imageDigestMirrorSet + imageTagMirrorSet")
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, nil, nil, nil, tc.idmsRules, tc.itmsRules)
+	require.NoError(t, err)
+
+	idms, itms, err := ExtractMirrorSets(&config)
+	require.NoError(t, err)
+	roundTrip := sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&roundTrip, nil, nil, nil, idms, itms)
+	require.NoError(t, err)
+	assert.Equal(t, config, roundTrip)
+}
@@ -0,0 +1,64 @@
+package registries
+
+import (
+	"encoding/json"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// jsonEndpoint is the JSON representation of sysregistriesv2.Endpoint, using the same key names as registries.conf.
+type jsonEndpoint struct {
+	Location       string `json:"location,omitempty"`
+	Insecure       bool   `json:"insecure,omitempty"`
+	PullFromMirror string `json:"pull-from-mirror,omitempty"`
+}
+
+// jsonRegistry is the JSON representation of sysregistriesv2.Registry, using the same key names as registries.conf.
+type jsonRegistry struct {
+	Prefix             string         `json:"prefix,omitempty"`
+	Location           string         `json:"location,omitempty"`
+	Insecure           bool           `json:"insecure,omitempty"`
+	Blocked            bool           `json:"blocked,omitempty"`
+	MirrorByDigestOnly bool           `json:"mirror-by-digest-only,omitempty"`
+	Mirrors            []jsonEndpoint `json:"mirror,omitempty"`
+}
+
+// jsonRegistriesConf is the JSON representation of sysregistriesv2.V2RegistriesConf, using the same key names as registries.conf.
+type jsonRegistriesConf struct {
+	UnqualifiedSearchRegistries []string          `json:"unqualified-search-registries,omitempty"`
+	CredentialHelpers           []string          `json:"credential-helpers,omitempty"`
+	ShortNameMode               string            `json:"short-name-mode,omitempty"`
+	Aliases                     map[string]string `json:"aliases,omitempty"`
+	Registries                  []jsonRegistry    `json:"registry,omitempty"`
+}
+
+// MarshalRegistriesConfJSON returns a JSON representation of conf, for consumers that don't read TOML.
+// The keys are the same as in registries.conf (e.g. "pull-from-mirror" with values like "digest-only"),
+// and the output is deterministic: object keys are always in the same order, and registries and mirrors
+// are in the order of conf.
+func MarshalRegistriesConfJSON(conf *sysregistriesv2.V2RegistriesConf) ([]byte, error) {
+	res := jsonRegistriesConf{
+		UnqualifiedSearchRegistries: conf.UnqualifiedSearchRegistries,
+		CredentialHelpers:           conf.CredentialHelpers,
+		ShortNameMode:               conf.ShortNameMode,
+		Aliases:                     conf.Aliases, // encoding/json sorts map keys
+	}
+	for _, reg := range conf.Registries {
+		jsonReg := jsonRegistry{
+			Prefix:             reg.Prefix,
+			Location:           reg.Location,
+			Insecure:           reg.Insecure,
+			Blocked:            reg.Blocked,
+			MirrorByDigestOnly: reg.MirrorByDigestOnly,
+		}
+		for _, mirror := range reg.Mirrors {
+			jsonReg.Mirrors = append(jsonReg.Mirrors, jsonEndpoint{
+				Location:       mirror.Location,
+				Insecure:       mirror.Insecure,
+				PullFromMirror: mirror.PullFromMirror,
+			})
+		}
+		res.Registries = append(res.Registries, jsonReg)
+	}
+	return json.Marshal(res)
+}
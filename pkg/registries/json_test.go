@@ -0,0 +1,43 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalRegistriesConfJSON(t *testing.T) {
+	res, err := MarshalRegistriesConfJSON(&sysregistriesv2.V2RegistriesConf{})
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(res))
+
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Blocked:  true,
+				Mirrors: []sysregistriesv2.Endpoint{
+					{Location: "mirror-digest.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+					{Location: "mirror-tag.registry-a.com", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+				},
+			},
+			{
+				Prefix:   "*.insecure.com",
+				Endpoint: sysregistriesv2.Endpoint{Insecure: true},
+			},
+		},
+	}
+	conf.Aliases = map[string]string{"z": "quay.io/z", "a": "quay.io/a"}
+	res, err = MarshalRegistriesConfJSON(&conf)
+	require.NoError(t, err)
+	assert.Equal(t, `{"unqualified-search-registries":["registry.access.redhat.com","docker.io"],"short-name-mode":"enforcing",`+
+		`"aliases":{"a":"quay.io/a","z":"quay.io/z"},"registry":[`+
+		`{"location":"registry-a.com","blocked":true,"mirror":[`+
+		`{"location":"mirror-digest.registry-a.com","pull-from-mirror":"digest-only"},`+
+		`{"location":"mirror-tag.registry-a.com","insecure":true,"pull-from-mirror":"tag-only"}]},`+
+		`{"prefix":"*.insecure.com","insecure":true}]}`, string(res))
+}
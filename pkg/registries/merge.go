@@ -0,0 +1,57 @@
+package registries
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// MergeConfigs returns a new configuration combining the already-generated configurations base and overlay, without
+// modifying either of them:
+// - Registry entries of both are included, base entries first.
+// - An overlay entry with the same scope as a base entry is merged into it, appending the overlay mirrors after the base mirrors (skipping mirrors already present); it is an error if such entries have different locations, insecure or blocked settings.
+// - The unqualified search registries of overlay replace those of base, if non-empty.
+// - All other settings are those of base.
+func MergeConfigs(base, overlay *sysregistriesv2.V2RegistriesConf) (*sysregistriesv2.V2RegistriesConf, error) {
+	res := CopyRegistriesConf(base)
+	res.Registries = []sysregistriesv2.Registry{}
+	if len(overlay.UnqualifiedSearchRegistries) != 0 {
+		res.UnqualifiedSearchRegistries = append([]string(nil), overlay.UnqualifiedSearchRegistries...)
+	}
+
+	indices := map[string]int{} // Key == registryScope, value == index in res.Registries
+	for _, conf := range []*sysregistriesv2.V2RegistriesConf{base, overlay} {
+		for i := range conf.Registries {
+			reg := conf.Registries[i]
+			scope := registryScope(&reg)
+			index, ok := indices[scope]
+			if !ok {
+				if reg.Mirrors != nil {
+					reg.Mirrors = append([]sysregistriesv2.Endpoint{}, reg.Mirrors...)
+				}
+				indices[scope] = len(res.Registries)
+				res.Registries = append(res.Registries, reg)
+				continue
+			}
+			existing := &res.Registries[index]
+			switch {
+			case existing.Location != reg.Location:
+				return nil, fmt.Errorf("conflicting locations %#v and %#v for registry %#v", existing.Location, reg.Location, scope)
+			case existing.Insecure != reg.Insecure:
+				return nil, fmt.Errorf("conflicting insecure values %t and %t for registry %#v", existing.Insecure, reg.Insecure, scope)
+			case existing.Blocked != reg.Blocked:
+				return nil, fmt.Errorf("conflicting blocked values %t and %t for registry %#v", existing.Blocked, reg.Blocked, scope)
+			}
+		nextMirror:
+			for _, mirror := range reg.Mirrors {
+				for _, m := range existing.Mirrors {
+					if m == mirror {
+						continue nextMirror
+					}
+				}
+				existing.Mirrors = append(existing.Mirrors, mirror)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,97 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeConfigs(t *testing.T) {
+	base := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry.example.com"},
+				Blocked:  true,
+			},
+		},
+	}
+	overlay := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "*.example.org", Insecure: true},
+				Prefix:   "*.example.org",
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					NewDigestMirror("mirror.example.com/ns"), // Already present
+					NewTagMirror("mirror-2.example.com/ns"),
+				},
+			},
+		},
+	}
+
+	res, err := MergeConfigs(&base, &overlay)
+	require.NoError(t, err)
+	assert.Equal(t, &sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns"), NewTagMirror("mirror-2.example.com/ns")},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry.example.com"},
+				Blocked:  true,
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "*.example.org", Insecure: true},
+				Prefix:   "*.example.org",
+			},
+		},
+	}, res)
+	// The inputs are not modified
+	assert.Len(t, base.Registries[0].Mirrors, 1)
+
+	// Search registries of the overlay replace those of base
+	overlay.UnqualifiedSearchRegistries = []string{"quay.io"}
+	res, err = MergeConfigs(&base, &overlay)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"quay.io"}, res.UnqualifiedSearchRegistries)
+
+	for _, tt := range []struct {
+		name     string
+		reg      sysregistriesv2.Registry
+		expected string
+	}{
+		{
+			name:     "insecure",
+			reg:      sysregistriesv2.Registry{Endpoint: sysregistriesv2.Endpoint{Location: "registry.example.com", Insecure: true}, Blocked: true},
+			expected: `conflicting insecure values false and true for registry "registry.example.com"`,
+		},
+		{
+			name:     "blocked",
+			reg:      sysregistriesv2.Registry{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"}, Blocked: true},
+			expected: `conflicting blocked values false and true for registry "quay.io/ns"`,
+		},
+		{
+			name:     "location",
+			reg:      sysregistriesv2.Registry{Prefix: "quay.io/ns", Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/other"}},
+			expected: `conflicting locations "quay.io/ns" and "quay.io/other" for registry "quay.io/ns"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MergeConfigs(&base, &sysregistriesv2.V2RegistriesConf{Registries: []sysregistriesv2.Registry{tt.reg}})
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
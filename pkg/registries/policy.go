@@ -0,0 +1,63 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+)
+
+// RegistryPolicy is a single serializable description of all inputs of EditRegistriesConfigFromPolicy.
+type RegistryPolicy struct {
+	// InsecureScopes, BlockedScopes, ICSPRules, IDMSRules and ITMSRules have the same meaning as the
+	// corresponding parameters of EditRegistriesConfig.
+	InsecureScopes []string                                         `json:"insecureScopes,omitempty"`
+	BlockedScopes  []string                                         `json:"blockedScopes,omitempty"`
+	ICSPRules      []*apioperatorsv1alpha1.ImageContentSourcePolicy `json:"icspRules,omitempty"`
+	IDMSRules      []*apicfgv1.ImageDigestMirrorSet                 `json:"idmsRules,omitempty"`
+	ITMSRules      []*apicfgv1.ImageTagMirrorSet                    `json:"itmsRules,omitempty"`
+
+	// UnqualifiedSearchRegistries, if not nil, replaces unqualified-search-registries of the edited configuration.
+	// The entries must be registry host names, with an optional :port.
+	UnqualifiedSearchRegistries []string `json:"unqualifiedSearchRegistries,omitempty"`
+	// ShortNameMode, if not empty, replaces short-name-mode of the edited configuration.
+	// Valid values are "disabled", "permissive" and "enforcing".
+	ShortNameMode string `json:"shortNameMode,omitempty"`
+}
+
+// validate returns an error if the fields of policy that are not validated by EditRegistriesConfig are invalid.
+func (policy *RegistryPolicy) validate() error {
+	for _, registry := range policy.UnqualifiedSearchRegistries {
+		if registry == "" || strings.ContainsAny(registry, "/@*") {
+			return fmt.Errorf("invalid unqualified search registry %#v", registry)
+		}
+	}
+	switch policy.ShortNameMode {
+	case "", "disabled", "permissive", "enforcing":
+	default:
+		return fmt.Errorf("invalid short-name mode %#v", policy.ShortNameMode)
+	}
+	return nil
+}
+
+// EditRegistriesConfigFromPolicy edits, IN PLACE, the /etc/containers/registries.conf configuration provided in config,
+// like EditRegistriesConfig, using the inputs in policy, and also sets the unqualified search registries and short-name mode
+// if they are specified in policy.
+// policy is validated as a whole first; if it is invalid, an error is returned and config is not modified.
+func EditRegistriesConfigFromPolicy(config *sysregistriesv2.V2RegistriesConf, policy *RegistryPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if err := EditRegistriesConfig(config, policy.InsecureScopes, policy.BlockedScopes, policy.ICSPRules, policy.IDMSRules, policy.ITMSRules); err != nil {
+		return err
+	}
+	if policy.UnqualifiedSearchRegistries != nil {
+		config.UnqualifiedSearchRegistries = append([]string{}, policy.UnqualifiedSearchRegistries...)
+	}
+	if policy.ShortNameMode != "" {
+		config.ShortNameMode = policy.ShortNameMode
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package registries
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditRegistriesConfigFromPolicy(t *testing.T) {
+	tc := findEditRegistriesConfigTestcase(t, "imageContentSourcePolicy+imageDigestMirrorSet+imageTagMirrorSet")
+	policy := RegistryPolicy{
+		InsecureScopes:              tc.insecure,
+		BlockedScopes:               tc.blocked,
+		ICSPRules:                   tc.icspRules,
+		IDMSRules:                   tc.idmsRules,
+		ITMSRules:                   tc.itmsRules,
+		UnqualifiedSearchRegistries: []string{"registry.example.com", "registry.example.net:5000"},
+		ShortNameMode:               "enforcing",
+	}
+	// The policy can be serialized and restored.
+	policyJSON, err := json.Marshal(policy)
+	require.NoError(t, err)
+	restored := RegistryPolicy{}
+	err = json.Unmarshal(policyJSON, &restored)
+	require.NoError(t, err)
+
+	config := sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfigFromPolicy(&config, &restored)
+	require.NoError(t, err)
+	expected := sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&expected, tc.insecure, tc.blocked, tc.icspRules, tc.idmsRules, tc.itmsRules)
+	require.NoError(t, err)
+	expected.UnqualifiedSearchRegistries = []string{"registry.example.com", "registry.example.net:5000"}
+	expected.ShortNameMode = "enforcing"
+	assert.Equal(t, expected, config)
+
+	// Unset search registries and short-name mode are not modified.
+	config = sysregistriesv2.V2RegistriesConf{UnqualifiedSearchRegistries: []string{"docker.io"}, ShortNameMode: "permissive"}
+	err = EditRegistriesConfigFromPolicy(&config, &RegistryPolicy{BlockedScopes: []string{"blocked.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"docker.io"},
+		ShortNameMode:               "permissive",
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "blocked.com"}, Blocked: true},
+		},
+	}, config)
+
+	for _, invalid := range []RegistryPolicy{
+		{InsecureScopes: []string{"*.example.com/ns"}, ShortNameMode: "enforcing"},
+		{
+			IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"*.mirror.registry-a.com"}},
+						},
+					},
+				},
+			},
+			ShortNameMode: "enforcing",
+		},
+		{BlockedScopes: []string{"blocked.com"}, UnqualifiedSearchRegistries: []string{"registry.example.com/ns"}},
+		{BlockedScopes: []string{"blocked.com"}, UnqualifiedSearchRegistries: []string{""}},
+		{BlockedScopes: []string{"blocked.com"}, ShortNameMode: "prompt"},
+	} {
+		config := sysregistriesv2.V2RegistriesConf{UnqualifiedSearchRegistries: []string{"docker.io"}}
+		err := EditRegistriesConfigFromPolicy(&config, &invalid)
+		assert.Error(t, err)
+		assert.Equal(t, sysregistriesv2.V2RegistriesConf{UnqualifiedSearchRegistries: []string{"docker.io"}}, config)
+	}
+}
@@ -0,0 +1,35 @@
+package registries
+
+import (
+	"reflect"
+
+	apicfgv1 "github.com/openshift/api/config/v1"
+)
+
+// RedundantObjects returns the names of the objects in idms, in their original order, whose entire contribution is already
+// provided by the other objects, i.e. removing them would not change the merged mirror configuration: the same sources,
+// with the same mirrors in the same order, with the same mirrorSourcePolicy and pull-from-mirror settings.
+// An object whose mirrors are all present elsewhere, but which changes the merged order of mirrors, is not redundant.
+// The objects are checked in order and each one is checked without the previously reported ones, so all of the returned
+// objects can be removed together.
+// If idms can't be merged (e.g. because of invalid annotations), nil is returned.
+func RedundantObjects(idms []*apicfgv1.ImageDigestMirrorSet) []string {
+	expected, err := mergedDigestMirrorSets(idms, nil, nil, false)
+	if err != nil {
+		return nil
+	}
+	res := []string{}
+	kept := append([]*apicfgv1.ImageDigestMirrorSet{}, idms...)
+	for i := 0; i < len(kept); {
+		candidate := kept[i]
+		without := append(append([]*apicfgv1.ImageDigestMirrorSet{}, kept[:i]...), kept[i+1:]...)
+		merged, err := mergedDigestMirrorSets(without, nil, nil, false)
+		if err != nil || !reflect.DeepEqual(merged, expected) {
+			i++
+			continue
+		}
+		res = append(res, candidate.Name)
+		kept = without
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package registries
+
+import (
+	"testing"
+
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedundantObjects(t *testing.T) {
+	idms := func(name string, mirrors ...apicfgv1.ImageDigestMirrors) *apicfgv1.ImageDigestMirrorSet {
+		return &apicfgv1.ImageDigestMirrorSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       apicfgv1.ImageDigestMirrorSetSpec{ImageDigestMirrors: mirrors},
+		}
+	}
+	for _, tt := range []struct {
+		name     string
+		idms     []*apicfgv1.ImageDigestMirrorSet
+		expected []string
+	}{
+		{
+			name:     "empty",
+			idms:     nil,
+			expected: []string{},
+		},
+		{
+			name: "subset",
+			idms: []*apicfgv1.ImageDigestMirrorSet{
+				idms("full",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.registry-a.com", "mirror-2.registry-a.com"}},
+					apicfgv1.ImageDigestMirrors{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}},
+				),
+				idms("subset",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-a.com"}},
+				),
+				idms("new-mirror",
+					apicfgv1.ImageDigestMirrors{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-b.com"}},
+				),
+			},
+			expected: []string{"subset"},
+		},
+		{
+			name: "different order",
+			idms: []*apicfgv1.ImageDigestMirrorSet{
+				idms("unordered",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.registry-a.com"}},
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-a.com"}},
+				),
+				idms("reordering",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-a.com", "mirror-1.registry-a.com"}},
+				),
+			},
+			expected: []string{"unordered"}, // "reordering" provides the same mirrors, but changes their order
+		},
+		{
+			name: "different mirrorSourcePolicy",
+			idms: []*apicfgv1.ImageDigestMirrorSet{
+				idms("allow",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}},
+				),
+				idms("never",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+				),
+				idms("empty"),
+			},
+			expected: []string{"allow", "empty"}, // The source is blocked by "never" either way
+		},
+		{
+			name: "identical objects",
+			idms: []*apicfgv1.ImageDigestMirrorSet{
+				idms("copy-1",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+				),
+				idms("copy-2",
+					apicfgv1.ImageDigestMirrors{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+				),
+			},
+			expected: []string{"copy-1"}, // Only one of them can be removed
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := RedundantObjects(tt.idms)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isValidTag returns true if tag is a valid tag of an image reference, as in docker/distribution: up to 128 characters out of
+// letters, digits, _, . and -, not starting with . or -.
+func isValidTag(tag string) bool {
+	if tag == "" || len(tag) > 128 || tag[0] == '.' || tag[0] == '-' {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseReference splits the image reference ref into its host (including any :port, e.g. quay.io:443 or [fd00::1]:5000),
+// its namespace/repository path (without a leading slash, "" if ref consists only of a host), and its tag and digest
+// (e.g. sha256:..., without the @), each "" if not present.
+// ref must start with an explicit host name; like in docker/distribution, a colon before the first slash separates a port,
+// and a colon after the last slash separates a tag. So localhost:5000/img:5000 has host localhost:5000 and tag 5000,
+// and quay.io:443 has no tag. Unlike docker/distribution, no default host or namespace is added, host names are not
+// otherwise validated, and digests are only checked to have an algorithm:encoded form.
+func ParseReference(ref string) (host, path, tag, digest string, err error) {
+	invalid := func(format string, args ...interface{}) (string, string, string, string, error) {
+		return "", "", "", "", fmt.Errorf("invalid reference %#v: %s", ref, fmt.Sprintf(format, args...))
+	}
+	if strings.Contains(ref, "*") {
+		return invalid("wildcards are not allowed")
+	}
+	repo := ref
+	if i := strings.IndexByte(repo, '@'); i != -1 {
+		repo, digest = repo[:i], repo[i+1:]
+		if digest == "" {
+			return invalid("empty digest")
+		}
+		if algorithm, encoded, ok := strings.Cut(digest, ":"); !ok || algorithm == "" || encoded == "" {
+			return invalid("invalid digest %#v", digest)
+		}
+	}
+	host = repo
+	if i := strings.IndexByte(repo, '/'); i != -1 {
+		host, path = repo[:i], repo[i+1:]
+		lastComponent := strings.LastIndexByte(path, '/') + 1
+		if j := strings.IndexByte(path[lastComponent:], ':'); j != -1 {
+			path, tag = path[:lastComponent+j], path[lastComponent+j+1:]
+			if tag == "" {
+				return invalid("empty tag")
+			}
+			if !isValidTag(tag) {
+				return invalid("invalid tag %#v", tag)
+			}
+		}
+		for _, component := range strings.Split(path, "/") {
+			if component == "" {
+				return invalid("empty path component")
+			}
+		}
+	}
+
+	hostName, port, hasPort := host, "", false
+	if strings.HasPrefix(host, "[") {
+		i := strings.IndexByte(host, ']')
+		if i == -1 {
+			return invalid("unterminated IPv6 address")
+		}
+		hostName = host[:i+1]
+		if rest := host[i+1:]; rest != "" {
+			if rest[0] != ':' {
+				return invalid("unexpected %#v after IPv6 address", rest)
+			}
+			port, hasPort = rest[1:], true
+		}
+	} else {
+		hostName, port, hasPort = strings.Cut(host, ":")
+	}
+	if hostName == "" || hostName == "[]" {
+		return invalid("empty host name")
+	}
+	if hasPort {
+		if port == "" || strings.Trim(port, "0123456789") != "" {
+			return invalid("invalid port %#v", port)
+		}
+	}
+	return host, path, tag, digest, nil
+}
+
+// ScopeForReference returns the scope (as in sysregistriesv2.Registry.Prefix) of the repository referenced by ref,
+// i.e. ref with any :tag and/or @digest suffix removed, suitable for use with ScopeIsNestedInsideScope.
+// ref must start with an explicit host name, e.g. quay.io/ns/img:tag or quay.io:443/ns/img@sha256:...;
+// a :port in the host part is preserved. See ParseReference for details.
+func ScopeForReference(ref string) (string, error) {
+	host, path, _, _, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return host, nil
+	}
+	return host + "/" + path, nil
+}
@@ -0,0 +1,114 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeForReference(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for _, tt := range []struct {
+		ref, expected string
+	}{
+		{"quay.io", "quay.io"},
+		{"quay.io:443", "quay.io:443"}, // A port, not a tag
+		{"quay.io/ns/img", "quay.io/ns/img"},
+		{"quay.io/ns/img:tag", "quay.io/ns/img"},
+		{"quay.io/ns/img@" + digest, "quay.io/ns/img"},
+		{"quay.io/ns/img:tag@" + digest, "quay.io/ns/img"},
+		{"quay.io:443/ns/img", "quay.io:443/ns/img"},
+		{"quay.io:443/ns/img:tag", "quay.io:443/ns/img"},
+		{"quay.io:443/ns/img@" + digest, "quay.io:443/ns/img"},
+		{"quay.io:443/ns/img:tag@" + digest, "quay.io:443/ns/img"},
+		{"localhost:5000/img:5000", "localhost:5000/img"},
+	} {
+		t.Run(fmt.Sprintf("%#v", tt.ref), func(t *testing.T) {
+			res, err := ScopeForReference(tt.ref)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+			assert.True(t, ScopeIsNestedInsideScope(res, "quay.io") || ScopeIsNestedInsideScope(res, "quay.io:443") ||
+				ScopeIsNestedInsideScope(res, "localhost:5000"))
+		})
+	}
+
+	for _, ref := range []string{
+		"",
+		"quay.io/ns/img:",
+		"quay.io/ns/img@",
+		"quay.io/ns/",
+		"*.example.com",
+		"@" + digest,
+	} {
+		t.Run(fmt.Sprintf("%#v", ref), func(t *testing.T) {
+			_, err := ScopeForReference(ref)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for _, tt := range []struct {
+		ref                     string
+		host, path, tag, digest string
+	}{
+		{"quay.io", "quay.io", "", "", ""},
+		{"quay.io:443", "quay.io:443", "", "", ""}, // A port, not a tag
+		{"quay.io/img", "quay.io", "img", "", ""},
+		{"quay.io/ns/img:tag", "quay.io", "ns/img", "tag", ""},
+		{"quay.io/ns/img@" + digest, "quay.io", "ns/img", "", digest},
+		{"quay.io/ns/img:tag@" + digest, "quay.io", "ns/img", "tag", digest},
+		{"quay.io:443/ns/img:tag@" + digest, "quay.io:443", "ns/img", "tag", digest},
+		{"localhost:5000/img:5000", "localhost:5000", "img", "5000", ""},
+		{"localhost:5000/ns.v1/img", "localhost:5000", "ns.v1/img", "", ""}, // Dots in the path are not a port
+		{"quay.io/ns/img:v1.2_3-rc", "quay.io", "ns/img", "v1.2_3-rc", ""},
+		{"[fd00::1]", "[fd00::1]", "", "", ""},
+		{"[fd00::1]:5000/ns/img:tag", "[fd00::1]:5000", "ns/img", "tag", ""},
+		{"[fd00::1]/img@" + digest, "[fd00::1]", "img", "", digest},
+		{"Quay.io/NS/Img:Tag", "Quay.io", "NS/Img", "Tag", ""}, // Case is preserved
+	} {
+		t.Run(fmt.Sprintf("%#v", tt.ref), func(t *testing.T) {
+			host, path, tag, digest, err := ParseReference(tt.ref)
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.host, tt.path, tt.tag, tt.digest}, []string{host, path, tag, digest})
+		})
+	}
+
+	for _, tt := range []struct {
+		ref, expected string
+	}{
+		{"", "empty host name"},
+		{"/img", "empty host name"},
+		{":5000/img", "empty host name"},
+		{"[]:5000/img", "empty host name"},
+		{"quay.io/ns/img:", "empty tag"},
+		{"quay.io/ns/img:a:b", `invalid tag "a:b"`},
+		{"quay.io/ns/img:-tag", `invalid tag "-tag"`},
+		{"quay.io/ns/img:" + strings.Repeat("a", 129), `invalid tag "` + strings.Repeat("a", 129) + `"`},
+		{"quay.io/ns/img@", "empty digest"},
+		{"quay.io/ns/img@sha256", `invalid digest "sha256"`},
+		{"quay.io/ns/img@:abc", `invalid digest ":abc"`},
+		{"quay.io/ns/", "empty path component"},
+		{"quay.io//img", "empty path component"},
+		{"quay.io/", "empty path component"},
+		{"quay.io:/img", `invalid port ""`},
+		{"quay.io:https/img", `invalid port "https"`},
+		{"img:tag", `invalid port "tag"`}, // Without a slash, the colon separates a port
+		{"[fd00::1/img", "unterminated IPv6 address"},
+		{"[fd00::1]ns/img", `unexpected "ns" after IPv6 address`},
+		{"[fd00::1]:x/img", `invalid port "x"`},
+		{"*.example.com", "wildcards are not allowed"},
+		{"@" + digest, "empty host name"},
+	} {
+		t.Run(fmt.Sprintf("%#v", tt.ref), func(t *testing.T) {
+			_, _, _, _, err := ParseReference(tt.ref)
+			assert.EqualError(t, err, fmt.Sprintf("invalid reference %#v: %s", tt.ref, tt.expected))
+		})
+	}
+}
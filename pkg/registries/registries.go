@@ -1,18 +1,28 @@
 package registries
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/go-logr/logr"
 	apicfgv1 "github.com/openshift/api/config/v1"
 	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
 )
 
 // ScopeIsNestedInsideScope returns true if a subScope value (as in sysregistriesv2.Registry.Prefix / sysregistriesv2.Endpoint.Location)
 // is a sub-scope of superScope.
+// Host names are case-insensitive, so Quay.io/ns is nested inside quay.io; namespace and repository paths are compared
+// case-sensitively, so quay.io/NS is not nested inside quay.io/ns.
 func ScopeIsNestedInsideScope(subScope, superScope string) bool {
+	subScope, superScope = lowercaseScopeHost(subScope), lowercaseScopeHost(superScope)
 	match := false
 	if superScope == subScope {
 		return true
@@ -25,17 +35,42 @@ func ScopeIsNestedInsideScope(subScope, superScope string) bool {
 	// e.g *.foo.example.com is a sub-scope of *.example.com or bar.example.com/bar is a sub-scope of *.example.com
 	// and check that we are not matching on namespace or repo e.g *.foo should not match quay/bar.foo or quay/bar.foo/example or quay/bar.foo:400
 	if strings.HasPrefix(superScope, "*.") {
-		if strings.Contains(subScope, ":") {
-			arr := strings.Split(subScope, ":")
-			match = strings.HasSuffix(arr[0], superScope[1:]) && !strings.Contains(arr[0], "/")
-		} else {
-			arr := strings.Split(subScope, "/")
-			match = strings.HasSuffix(arr[0], superScope[1:])
-		}
+		match = strings.HasSuffix(subScope[:scopeHostLen(subScope)], superScope[1:])
 	}
 	return match
 }
 
+// ScopeEquals returns true if scopes a and b (as in sysregistriesv2.Registry.Prefix / sysregistriesv2.Endpoint.Location) are
+// the same scope, i.e. if each is nested inside the other per ScopeIsNestedInsideScope: host names are compared case-insensitively,
+// and a trailing / is ignored, so Quay.io/ns/ is equal to quay.io/ns. Unlike ScopeIsNestedInsideScope, a nested scope is never equal.
+func ScopeEquals(a, b string) bool {
+	return lowercaseScopeHost(strings.TrimSuffix(a, "/")) == lowercaseScopeHost(strings.TrimSuffix(b, "/"))
+}
+
+// lowercaseScopeHost returns scope with its host name (including a wildcard host name) converted to lower case.
+func lowercaseScopeHost(scope string) string {
+	hostLen := scopeHostLen(scope)
+	host := strings.ToLower(scope[:hostLen])
+	if host == scope[:hostLen] { // Avoid allocating in the common case
+		return scope
+	}
+	return host + scope[hostLen:]
+}
+
+// scopeHostLen returns the length of the host name part of scope, i.e. excluding any :port and the namespace/repo that follows it.
+// A bracketed IPv6 literal (e.g. [fd00::1]:5000/ns) is a single host name, the colons inside the brackets don't start a port.
+func scopeHostLen(scope string) int {
+	if strings.HasPrefix(scope, "[") {
+		if i := strings.IndexByte(scope, ']'); i != -1 {
+			return i + 1
+		}
+	}
+	if i := strings.IndexAny(scope, ":/"); i != -1 {
+		return i
+	}
+	return len(scope)
+}
+
 // mirrorsContainsARealMirror returns true if mirrors contains at least one entry that is not source.
 func mirrorsContainsARealMirror(source string, mirrors []apicfgv1.ImageMirror) bool {
 	for _, mirror := range mirrors {
@@ -46,26 +81,230 @@ func mirrorsContainsARealMirror(source string, mirrors []apicfgv1.ImageMirror) b
 	return false
 }
 
+// PullFromMirrorAnnotation is an annotation on ImageDigestMirrorSet and ImageTagMirrorSet objects that
+// overrides the pull-from-mirror value of specific mirror locations configured by that object, which is otherwise
+// digest-only for ImageDigestMirrorSet and tag-only for ImageTagMirrorSet.
+// The value is a comma-separated list of location=mode entries, where mode is one of "all", "digest-only" and "tag-only",
+// e.g. "mirror.example.com/ns=all,other.example.com=tag-only".
+const PullFromMirrorAnnotation = "runtime-utils.openshift.io/pull-from-mirror"
+
+// pullFromMirrorOverrides parses PullFromMirrorAnnotation from annotations, and returns the
+// overridden pull-from-mirror values, keyed by mirror location.
+func pullFromMirrorOverrides(annotations map[string]string) (map[string]string, error) {
+	value, ok := annotations[PullFromMirrorAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	res := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		location, mode, ok := strings.Cut(entry, "=")
+		if !ok || location == "" {
+			return nil, fmt.Errorf("invalid %s entry %#v, expected location=mode", PullFromMirrorAnnotation, entry)
+		}
+		switch mode {
+		case sysregistriesv2.MirrorAll, sysregistriesv2.MirrorByDigestOnly, sysregistriesv2.MirrorByTagOnly:
+		default:
+			return nil, fmt.Errorf("invalid %s value %#v for mirror %#v", PullFromMirrorAnnotation, mode, location)
+		}
+		if existing, ok := res[location]; ok && existing != mode {
+			return nil, fmt.Errorf("conflicting %s values %#v and %#v for mirror %#v", PullFromMirrorAnnotation, existing, mode, location)
+		}
+		res[location] = mode
+	}
+	return res, nil
+}
+
+// MirrorPriorityAnnotation is an annotation on ImageDigestMirrorSet and ImageTagMirrorSet objects that sets the priority of
+// specific mirror locations configured by that object, to express a preference independent of the order of mirror lists.
+// The value is a comma-separated list of location=priority entries, where priority is an integer (possibly negative),
+// e.g. "fast.example.com/ns=10,slow.example.com=-5"; mirrors without a priority have priority 0.
+// The mirrors of a source are tried in decreasing order of priority; mirrors with equal priorities keep the order they would
+// have without the annotation. Priorities only reorder mirrors of the same kind (digest-only mirrors are still tried before
+// tag-only ones), and the source itself, unless listed as a mirror, is still tried after all mirrors.
+const MirrorPriorityAnnotation = "runtime-utils.openshift.io/mirror-priority"
+
+// mirrorPriorities parses MirrorPriorityAnnotation from annotations, and returns the priorities, keyed by mirror location.
+func mirrorPriorities(annotations map[string]string) (map[string]int, error) {
+	value, ok := annotations[MirrorPriorityAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	res := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		location, priorityString, ok := strings.Cut(entry, "=")
+		if !ok || location == "" {
+			return nil, fmt.Errorf("invalid %s entry %#v, expected location=priority", MirrorPriorityAnnotation, entry)
+		}
+		priority, err := strconv.Atoi(priorityString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %#v for mirror %#v", MirrorPriorityAnnotation, priorityString, location)
+		}
+		if existing, ok := res[location]; ok && existing != priority {
+			return nil, fmt.Errorf("conflicting %s values %d and %d for mirror %#v", MirrorPriorityAnnotation, existing, priority, location)
+		}
+		res[location] = priority
+	}
+	return res, nil
+}
+
+// PullThroughMirrorsAnnotation is an annotation on ImageDigestMirrorSet and ImageTagMirrorSet objects that marks mirror
+// locations configured by that object as pull-through caches, as a comma-separated list of locations.
+// Unlike a plain mirror, which is expected to contain a complete copy of the mirrored content, a pull-through cache fetches
+// missing content from the source on demand, so the source must remain contactable: using a pull-through mirror for a
+// source with mirrorSourcePolicy NeverContactSource is rejected.
+// registries.conf has no representation of pull-through caches, so the generated mirror entries are otherwise unchanged.
+const PullThroughMirrorsAnnotation = "runtime-utils.openshift.io/pull-through-mirrors"
+
+// pullThroughMirrors parses PullThroughMirrorsAnnotation from annotations, and returns the set of pull-through mirror locations.
+func pullThroughMirrors(annotations map[string]string) (map[string]struct{}, error) {
+	value, ok := annotations[PullThroughMirrorsAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	res := map[string]struct{}{}
+	for _, location := range strings.Split(value, ",") {
+		location = strings.TrimSpace(location)
+		if location == "" {
+			return nil, fmt.Errorf("invalid %s value %#v: empty location", PullThroughMirrorsAnnotation, value)
+		}
+		res[location] = struct{}{}
+	}
+	return res, nil
+}
+
+// ArchitectureAnnotation is an annotation on ImageDigestMirrorSet and ImageTagMirrorSet objects that restricts the object
+// to nodes of some architectures, as a comma-separated list of architecture names (as in GOARCH), e.g. "amd64,arm64";
+// this allows using per-architecture mirrors. It only has an effect if EditOptions.Architecture is set; objects without
+// the annotation apply to all architectures.
+const ArchitectureAnnotation = "runtime-utils.openshift.io/architecture"
+
+// architectures parses ArchitectureAnnotation from annotations, and returns the set of architectures, or nil if there is no annotation.
+func architectures(annotations map[string]string) (map[string]struct{}, error) {
+	value, ok := annotations[ArchitectureAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	res := map[string]struct{}{}
+	for _, arch := range strings.Split(value, ",") {
+		arch = strings.TrimSpace(arch)
+		if arch == "" {
+			return nil, fmt.Errorf("invalid %s value %#v: empty architecture", ArchitectureAnnotation, value)
+		}
+		res[arch] = struct{}{}
+	}
+	return res, nil
+}
+
+// InsecureSourcesAnnotation is an annotation on ImageDigestMirrorSet and ImageTagMirrorSet objects that marks sources configured
+// by that object as insecure, as a comma-separated list of sources, e.g. "registry.example.com/ns,*.example.net"; this keeps
+// the insecure declaration next to the mirror rule, instead of in a separate list of insecure scopes. It only has an effect
+// if EditOptions.InsecureSourcesFromAnnotations is set; each listed source must be a source of a mirror set of the object, and
+// it is then handled as if it were listed in EditOptions.InsecureScopes.
+const InsecureSourcesAnnotation = "runtime-utils.openshift.io/insecure-sources"
+
+// insecureSources parses InsecureSourcesAnnotation from annotations, and returns the listed sources, without duplicates.
+func insecureSources(annotations map[string]string) ([]string, error) {
+	value, ok := annotations[InsecureSourcesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	res := []string{}
+	for _, source := range strings.Split(value, ",") {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			return nil, fmt.Errorf("invalid %s value %#v: empty source", InsecureSourcesAnnotation, value)
+		}
+		if !IsValidRegistriesConfScope(source) {
+			return nil, fmt.Errorf("invalid %s value %#v: invalid scope %#v", InsecureSourcesAnnotation, value, source)
+		}
+		res = appendUnique(res, source)
+	}
+	return res, nil
+}
+
+// validatePullThroughMirrors returns an error if any of mirrors is in pullThrough (see PullThroughMirrorsAnnotation)
+// and mirrorSourcePolicy is NeverContactSource.
+func validatePullThroughMirrors(pullThrough map[string]struct{}, source string, mirrorSourcePolicy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) error {
+	if mirrorSourcePolicy != apicfgv1.NeverContactSource {
+		return nil
+	}
+	for _, mirror := range mirrors {
+		if _, ok := pullThrough[string(mirror)]; ok {
+			return fmt.Errorf("pull-through mirror %#v requires contacting source %#v, which uses mirrorSourcePolicy %s", mirror, source, mirrorSourcePolicy)
+		}
+	}
+	return nil
+}
+
 // mirrorSet collects data from mirror setting CRDs (ImageDigestMirrorSet, ImageTagMirrorSet)
 type mirrorSets struct {
-	disjointSets      map[string]*[][]string // Key == Source
-	mirrorBlockSource map[string]bool        // key == Source
+	disjointSets      map[string]*[][]string       // Key == Source
+	mirrorBlockSource map[string]bool              // key == Source
+	pullFromMirror    map[string]map[string]string // key == Source, then mirror location
+	priority          map[string]map[string]int    // key == Source, then mirror location; see MirrorPriorityAnnotation
+	sourceOnly        map[string]bool              // key == Source; sources with mirrors that only repeat the source, if keepSourceOnlyMirrors
+
+	keepSourceOnlyMirrors bool // See EditOptions.KeepSourceOnlyMirrors
 }
 
 func newMirrorSets() *mirrorSets {
 	return &mirrorSets{
 		disjointSets:      map[string]*[][]string{},
 		mirrorBlockSource: map[string]bool{},
+		pullFromMirror:    map[string]map[string]string{},
+		priority:          map[string]map[string]int{},
+		sourceOnly:        map[string]bool{},
 	}
 }
 
-func (sets *mirrorSets) addMirrorSet(source string, mirrorSourcePolicy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+// addMirrorSet adds a set of mirrors for source.
+// pullFromMirrorOverrides, if not nil, contains pull-from-mirror values overriding the default for some mirror locations.
+// priorities, if not nil, contains priorities of some mirror locations (see MirrorPriorityAnnotation).
+func (sets *mirrorSets) addMirrorSet(source string, mirrorSourcePolicy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror, pullFromMirrorOverrides map[string]string,
+	priorities map[string]int) error {
+	for _, m := range mirrors {
+		if !IsValidMirrorLocation(string(m)) {
+			return fmt.Errorf("invalid mirror %#v of source %#v", m, source)
+		}
+	}
 	if !mirrorsContainsARealMirror(source, mirrors) {
-		return // No mirrors (or mirrors that only repeat the authoritative source) is not really a mirror set. Ignore mirrorSourcePolicy intentionally.
+		if sets.keepSourceOnlyMirrors && len(mirrors) != 0 {
+			sets.sourceOnly[source] = true
+		}
+		return nil // No mirrors (or mirrors that only repeat the authoritative source) is not really a mirror set. Ignore mirrorSourcePolicy intentionally.
 	}
 	strMirrors := []string{}
+	seen := map[string]struct{}{}
 	for _, m := range mirrors {
+		if _, ok := seen[string(m)]; ok {
+			continue // Keep only the first occurrence of a repeated mirror location.
+		}
+		seen[string(m)] = struct{}{}
 		strMirrors = append(strMirrors, (string(m)))
+		if mode, ok := pullFromMirrorOverrides[string(m)]; ok {
+			modes, ok := sets.pullFromMirror[source]
+			if !ok {
+				modes = map[string]string{}
+				sets.pullFromMirror[source] = modes
+			}
+			if existing, ok := modes[string(m)]; ok && existing != mode {
+				return fmt.Errorf("conflicting %s values %#v and %#v for mirror %#v of %#v", PullFromMirrorAnnotation, existing, mode, m, source)
+			}
+			modes[string(m)] = mode
+		}
+		if priority, ok := priorities[string(m)]; ok {
+			sourcePriorities, ok := sets.priority[source]
+			if !ok {
+				sourcePriorities = map[string]int{}
+				sets.priority[source] = sourcePriorities
+			}
+			if existing, ok := sourcePriorities[string(m)]; ok && existing != priority {
+				return fmt.Errorf("conflicting %s values %d and %d for mirror %#v of %#v", MirrorPriorityAnnotation, existing, priority, m, source)
+			}
+			sourcePriorities[string(m)] = priority
+		}
 	}
 	if mirrorSourcePolicy == apicfgv1.NeverContactSource {
 		sets.mirrorBlockSource[source] = true
@@ -76,6 +315,7 @@ func (sets *mirrorSets) addMirrorSet(source string, mirrorSourcePolicy apicfgv1.
 		sets.disjointSets[source] = ds
 	}
 	*ds = append(*ds, strMirrors)
+	return nil
 }
 
 // mergedMirrors generates deterministic order of mirrors for a given source
@@ -107,6 +347,11 @@ func (sets *mirrorSets) mergedMirrors(source string) ([]string, error) {
 		// We don't need to explicitly include source in the list, it will be automatically tried last per the semantics of sysregistriesv2. Mirrors.
 		sortedRepos = sortedRepos[:len(sortedRepos)-1]
 	}
+	if priorities, ok := sets.priority[source]; ok {
+		sort.SliceStable(sortedRepos, func(i, j int) bool {
+			return priorities[sortedRepos[i]] > priorities[sortedRepos[j]]
+		})
+	}
 
 	if err != nil {
 		return nil, err
@@ -118,6 +363,7 @@ type mergedMirrorSet struct {
 	source             string
 	mirrors            []string
 	mirrorSourcePolicy apicfgv1.MirrorSourcePolicy
+	pullFromMirror     map[string]string // key == mirror location; overrides of the default value, nil if none
 }
 
 // mergedMirrorSets converts the set of mirrors to slice of mergedMirrorSet
@@ -127,11 +373,21 @@ func mergedMirrorSets(sets *mirrorSets) ([]mergedMirrorSet, error) {
 	for key := range sets.disjointSets {
 		sources = append(sources, key)
 	}
+	for key := range sets.sourceOnly {
+		if _, ok := sets.disjointSets[key]; !ok {
+			sources = append(sources, key)
+		}
+	}
 	// collects the mirror sources and sorted in increasing order
 	sort.Strings(sources)
 	// Convert the sets of mirrors
 	res := []mergedMirrorSet{}
 	for _, source := range sources {
+		if _, ok := sets.disjointSets[source]; !ok {
+			// A source-only set kept because of keepSourceOnlyMirrors. Ignore mirrorSourcePolicy, as addMirrorSet does.
+			res = append(res, mergedMirrorSet{source: source, mirrors: []string{source}})
+			continue
+		}
 		mirrors, err := sets.mergedMirrors(source)
 		if err != nil {
 			return nil, err
@@ -143,32 +399,94 @@ func mergedMirrorSets(sets *mirrorSets) ([]mergedMirrorSet, error) {
 		if sets.mirrorBlockSource[source] {
 			item.mirrorSourcePolicy = apicfgv1.NeverContactSource
 		}
+		item.pullFromMirror = sets.pullFromMirror[source]
 		res = append(res, item)
 	}
 	return res, nil
 }
 
+// withSourceMirrorsLast returns a copy of sets, where the source of each set, if it is listed among its mirrors, is moved after
+// all other mirrors (see EditOptions.SourceMirrorsLast). sets is not modified.
+func withSourceMirrorsLast(sets []mergedMirrorSet) []mergedMirrorSet {
+	res := []mergedMirrorSet{}
+	for _, set := range sets {
+		mirrors, hasSource := []string{}, false
+		for _, mirror := range set.mirrors {
+			if mirror == set.source {
+				hasSource = true
+				continue
+			}
+			mirrors = append(mirrors, mirror)
+		}
+		if hasSource {
+			mirrors = append(mirrors, set.source)
+		}
+		set.mirrors = mirrors
+		res = append(res, set)
+	}
+	return res
+}
+
 // mergedTagMirrorSets processes itmsRules and returns a set of mergedMirrorSet, one for each Source value,
 // ordered consistently with the preference order of the individual entries (if possible)
 // E.g. given mirror sets (B, C) and (A, B), it will combine them into a single (A, B, C) set.
-func mergedTagMirrorSets(itmsRules []*apicfgv1.ImageTagMirrorSet) ([]mergedMirrorSet, error) {
+// If keepSourceOnlyMirrors, sources with mirrors that only repeat the source are kept, with the source as the only mirror.
+func mergedTagMirrorSets(itmsRules []*apicfgv1.ImageTagMirrorSet, keepSourceOnlyMirrors bool) ([]mergedMirrorSet, error) {
 	tagMirrorSets := newMirrorSets()
+	tagMirrorSets.keepSourceOnlyMirrors = keepSourceOnlyMirrors
 	for _, itms := range itmsRules {
+		overrides, err := pullFromMirrorOverrides(itms.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("ImageTagMirrorSet %q: %w", itms.Name, err)
+		}
+		pullThrough, err := pullThroughMirrors(itms.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("ImageTagMirrorSet %q: %w", itms.Name, err)
+		}
+		priorities, err := mirrorPriorities(itms.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("ImageTagMirrorSet %q: %w", itms.Name, err)
+		}
 		for _, set := range itms.Spec.ImageTagMirrors {
-			tagMirrorSets.addMirrorSet(set.Source, set.MirrorSourcePolicy, set.Mirrors)
+			if err := validatePullThroughMirrors(pullThrough, set.Source, set.MirrorSourcePolicy, set.Mirrors); err != nil {
+				return nil, fmt.Errorf("ImageTagMirrorSet %q: %w", itms.Name, err)
+			}
+			if err := tagMirrorSets.addMirrorSet(set.Source, set.MirrorSourcePolicy, set.Mirrors, overrides, priorities); err != nil {
+				return nil, err
+			}
 		}
 	}
 	return mergedMirrorSets(tagMirrorSets)
 }
 
-// mergedDigestMirrorSets processes idmsRules and icspRules and returns a set of mergedMirrorSet, one for each Source value,
+// mergedDigestMirrorSets processes idmsRules, icspRules and icpRules and returns a set of mergedMirrorSet, one for each Source value,
 // ordered consistently with the preference order of the individual entries (if possible)
 // E.g. given mirror sets (B, C) and (A, B), it will combine them into a single (A, B, C) set.
-func mergedDigestMirrorSets(idmsRules []*apicfgv1.ImageDigestMirrorSet, icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy) ([]mergedMirrorSet, error) {
+// If keepSourceOnlyMirrors, sources with mirrors that only repeat the source are kept, with the source as the only mirror.
+func mergedDigestMirrorSets(idmsRules []*apicfgv1.ImageDigestMirrorSet, icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy,
+	icpRules []*apicfgv1.ImageContentPolicy, keepSourceOnlyMirrors bool) ([]mergedMirrorSet, error) {
 	mirrorSets := newMirrorSets()
+	mirrorSets.keepSourceOnlyMirrors = keepSourceOnlyMirrors
 	for _, idms := range idmsRules {
+		overrides, err := pullFromMirrorOverrides(idms.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("ImageDigestMirrorSet %q: %w", idms.Name, err)
+		}
+		pullThrough, err := pullThroughMirrors(idms.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("ImageDigestMirrorSet %q: %w", idms.Name, err)
+		}
+		priorities, err := mirrorPriorities(idms.Annotations)
+		if err != nil {
+			return nil, fmt.Errorf("ImageDigestMirrorSet %q: %w", idms.Name, err)
+		}
 		for _, set := range idms.Spec.ImageDigestMirrors {
-			mirrorSets.addMirrorSet(set.Source, set.MirrorSourcePolicy, set.Mirrors)
+			if err := validatePullThroughMirrors(pullThrough, set.Source, set.MirrorSourcePolicy, set.Mirrors); err != nil {
+				return nil, fmt.Errorf("ImageDigestMirrorSet %q: %w", idms.Name, err)
+			}
+			if err := mirrorSets.addMirrorSet(set.Source, set.MirrorSourcePolicy, set.Mirrors, overrides, priorities); err != nil {
+				return nil, err
+			}
 		}
 	}
 	for _, icsp := range icspRules {
@@ -178,7 +496,25 @@ func mergedDigestMirrorSets(idmsRules []*apicfgv1.ImageDigestMirrorSet, icspRule
 				imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
 			}
 			// leave MirrorSourcePolicy blank, it will follow the default AllowContactingSource
-			mirrorSets.addMirrorSet(set.Source, "", imgMirrors)
+			if err := mirrorSets.addMirrorSet(set.Source, "", imgMirrors, nil, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, icp := range icpRules {
+		for _, set := range icp.Spec.RepositoryDigestMirrors {
+			imgMirrors := imageContentPolicyMirrors(set)
+			var overrides map[string]string
+			if set.AllowMirrorByTags {
+				overrides = map[string]string{}
+				for _, m := range imgMirrors {
+					overrides[string(m)] = sysregistriesv2.MirrorAll
+				}
+			}
+			// Like ImageContentSourcePolicy, ImageContentPolicy has no mirrorSourcePolicy.
+			if err := mirrorSets.addMirrorSet(set.Source, "", imgMirrors, overrides, nil); err != nil {
+				return nil, err
+			}
 		}
 	}
 	return mergedMirrorSets(mirrorSets)
@@ -187,22 +523,39 @@ func mergedDigestMirrorSets(idmsRules []*apicfgv1.ImageDigestMirrorSet, icspRule
 // mirrorsAdjustedForNestedScope returns mirrors from mirroredScope, updated
 // so that they can be configured in a nested subScope, without any change in the
 // semantics of the mirrors.
+//
+// If mirroredScope is a wildcard (*.example.com), sysregistriesv2 replaces the whole matched host name
+// (but not a :port or the namespace/repo that follows it) with the mirror location; so mirrors of a
+// non-wildcard subScope (foo.example.com:5000/ns) get the part of subScope following the host name (":5000/ns")
+// appended, and a wildcard subScope (*.nested.example.com), which matches a subset of the same host names,
+// uses the mirrors unchanged. If that appended part starts with a :port, it can only follow a mirror location
+// that is a plain host name; mirrors with a path or a port of their own (mirror.com/nested) fail with an error.
 func mirrorsAdjustedForNestedScope(mirroredScope, subScope string, mirrors []sysregistriesv2.Endpoint) ([]sysregistriesv2.Endpoint, error) {
 	// Sanity checks, just to be sure.
 	if !ScopeIsNestedInsideScope(subScope, mirroredScope) {
 		return nil, fmt.Errorf("internal error: mirrorsAdjustedForNestedScope for %#v and non-subscope %#v", mirroredScope, subScope)
 	}
+	var adjustment string
 	if strings.HasPrefix(mirroredScope, "*.") {
-		return nil, fmt.Errorf("internal error: mirrorsAdjustedForNestedScope for a wildcard scope %#v", mirroredScope)
-	}
-	// If mirorredScope is not a wildcard, ScopeIsNestedInsideScope ensures that subScope is not a wildcard either
-	// So, both scopes should be simple namespaces, and ScopeIsNestedInsideScope should guarantee this.
-	if !strings.HasPrefix(subScope, mirroredScope) {
-		return nil, fmt.Errorf("internal error: mirrorsAdjustedForNestedScope with unexpected scopes %#v and %#v", mirroredScope, subScope)
+		if !strings.HasPrefix(subScope, "*.") {
+			adjustment = subScope[scopeHostLen(subScope):]
+		}
+	} else {
+		// If mirorredScope is not a wildcard, ScopeIsNestedInsideScope ensures that subScope is not a wildcard either
+		// So, both scopes should be simple namespaces, and ScopeIsNestedInsideScope should guarantee this.
+		// ScopeIsNestedInsideScope compares host names case-insensitively, so compare them the same way here.
+		lowerMirroredScope, lowerSubScope := lowercaseScopeHost(mirroredScope), lowercaseScopeHost(subScope)
+		if !strings.HasPrefix(lowerSubScope, lowerMirroredScope) {
+			return nil, fmt.Errorf("internal error: mirrorsAdjustedForNestedScope with unexpected scopes %#v and %#v", mirroredScope, subScope)
+		}
+		adjustment = lowerSubScope[len(lowerMirroredScope):]
 	}
-	adjustment := subScope[len(mirroredScope):]
 	res := []sysregistriesv2.Endpoint{}
 	for _, original := range mirrors {
+		if strings.HasPrefix(adjustment, ":") && scopeHostLen(original.Location) != len(original.Location) {
+			return nil, fmt.Errorf("mirror %#v of %#v can't be used for %#v: the port in %#v would follow the path or port of the mirror",
+				original.Location, mirroredScope, subScope, adjustment)
+		}
 		updated := original
 		updated.Location = updated.Location + adjustment
 		res = append(res, updated)
@@ -222,19 +575,491 @@ func registryScope(reg *sysregistriesv2.Registry) string {
 
 // EditRegistriesConfig edits, IN PLACE, the /etc/containers/registries.conf configuration provided in config, to:
 // - Mark scope entries in insecureScopes as insecure (TLS is not required, and TLS certificate verification is not required when TLS is used)
-// - Mark scope entries in blockedScopes as blocked (any attempts to access them fail)
+// - Mark scope entries in blockedScopes as blocked (any attempts to access them fail), including all entries nested inside them, e.g. for mirrored sources
 // - Implement ImageContentSourcePolicy rules in icspRules.
 // - Implement ImageDigestMirrorSet rules in idmsRules.
 // - Implement ImageTagMirrorSet rules in itmsRules.
+// The order of mirrors of a source, which is the order in which they are tried when pulling, is guaranteed to be:
+// any mirrors already configured for the source in config, then all digest-only mirrors (from icspRules and idmsRules),
+// then all tag-only mirrors (from itmsRules; see EditOptions.TagMirrorsFirst for the reverse order). Within each of the two groups, mirrors are ordered consistently with the
+// order in the individual mirror sets, if possible, and then by MirrorPriorityAnnotation.
+// registries.conf can only block a source as a whole, so a source is blocked if any of the mirror sets for it
+// (of any kind) uses mirrorSourcePolicy NeverContactSource, even if mirror sets of the other kind allow contacting it;
+// in particular, a NeverContactSource digest mirror set for a source also prevents pulling tags from that source,
+// using only the tag mirrors, if any; EditRegistriesConfigWithOptions reports such combinations in its warnings.
+// Insecure scopes apply to the entry of a source and to each of its mirror endpoints independently, based on their own
+// locations, so a source can be insecure with secure mirrors, and vice versa. registries.conf has a single insecure flag per
+// endpoint, which both allows plain HTTP and disables TLS certificate verification; allowing only one of them can't be expressed.
 // "scopes" can be any of whole registries, which means that the configuration applies to everything on that registry, including any possible separately-configured
 // namespaces/repositories within that registry.
 // or can be wildcard entries, which means that we accept wildcards in the form of *.example.registry.com for insecure and blocked registries only. We do not
 // accept them for mirror configuration.
 // A valid scope is in the form of registry/namespace...[/repo] (can also refer to sysregistriesv2.Registry.Prefix)
-// NOTE: Validation of wildcard entries is done before EditRegistriesConfig is called in the MCO code.
+// Entries of insecureScopes and blockedScopes are validated using IsValidRegistriesConfScope, and an error naming the first invalid
+// entry is returned (without modifying config) if any of them is not valid.
+// The edited config is checked with ValidateNoDuplicateScopes, so an error is returned if it contains duplicate entries (e.g. ones
+// that were already present in config).
+// NOTE: Validation of wildcard entries in mirror configuration is done before EditRegistriesConfig is called in the MCO code.
 func EditRegistriesConfig(config *sysregistriesv2.V2RegistriesConf, insecureScopes, blockedScopes []string, icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy,
 	idmsRules []*apicfgv1.ImageDigestMirrorSet, itmsRules []*apicfgv1.ImageTagMirrorSet,
 ) error {
+	_, err := EditRegistriesConfigWithOptions(config, EditOptions{
+		InsecureScopes: insecureScopes,
+		BlockedScopes:  blockedScopes,
+		ICSPRules:      icspRules,
+		IDMSRules:      idmsRules,
+		ITMSRules:      itmsRules,
+	})
+	return err
+}
+
+// EditOptions contains the inputs of EditRegistriesConfigWithOptions.
+// The zero value makes no changes to the edited configuration.
+type EditOptions struct {
+	// InsecureScopes, BlockedScopes, ICSPRules, IDMSRules and ITMSRules have the same meaning as the
+	// corresponding parameters of EditRegistriesConfig.
+	InsecureScopes []string
+	BlockedScopes  []string
+	ICSPRules      []*apioperatorsv1alpha1.ImageContentSourcePolicy
+	IDMSRules      []*apicfgv1.ImageDigestMirrorSet
+	ITMSRules      []*apicfgv1.ImageTagMirrorSet
+
+	// ICPRules are ImageContentPolicy objects, which are handled like ImageContentSourcePolicy objects (their mirrors are added
+	// along with those of ICSPRules and IDMSRules), except that the mirrors of a source with allowMirrorByTags are also used
+	// for pulls by tag (with pull-from-mirror "all", as if set by PullFromMirrorAnnotation).
+	ICPRules []*apicfgv1.ImageContentPolicy
+
+	// TreatDefaultPortsAsEqual, if set, removes an explicit default port from the host of every scope
+	// (insecure and blocked scopes, and sources and mirrors of the rules) before they are processed,
+	// so that e.g. quay.io:443 and quay.io are merged into a single quay.io entry.
+	// For scopes that are not insecure, the default port is :443; for insecure scopes (those nested inside
+	// an entry of InsecureScopes, after that entry is normalized), both :443 and :80 are default ports, because
+	// plain HTTP may be used for them.
+	// This only affects the inputs; ScopeIsNestedInsideScope itself always treats quay.io:443 and quay.io as
+	// different scopes, so an existing entry in the edited configuration that uses an explicit default port
+	// is not merged with the normalized scopes.
+	TreatDefaultPortsAsEqual bool
+
+	// WildcardScopesIncludeApex, if set, makes every wildcard entry of InsecureScopes and BlockedScopes (e.g. *.example.com) also
+	// apply to the domain itself (example.com), by adding it to the same list; a wildcard only matches subdomains otherwise.
+	// This only affects the inputs; wildcard entries already present in the edited configuration are not changed.
+	WildcardScopesIncludeApex bool
+
+	// InheritNestedScopeMirrors, if set, makes the registry entry generated for a mirrored source that is nested inside
+	// another mirrored source (e.g. quay.io/myorg inside quay.io) also use the mirrors of the enclosing sources, so that
+	// a pull from the nested scope can fall back to the broader mirrors.
+	// The nested source's own mirrors are always tried first; then the mirrors of the enclosing sources, from the most
+	// specific to the least specific one (wildcard sources last), each adjusted for the nested scope as by
+	// mirrorsAdjustedForNestedScope. Mirrors that are already present are not added again.
+	// Without this option, only the own mirrors of a nested source are used.
+	InheritNestedScopeMirrors bool
+
+	// BlockMirrorsOfBlockedScopes, if set, also blocks the locations on mirrors that correspond to a blocked scope nested
+	// inside a mirrored source (e.g. with primary.com/top mirrored to mirror.com/primary, blocking primary.com/top/blocked also
+	// blocks mirror.com/primary/blocked), so that the blocked content can't be pulled through the mirrors either.
+	// Without this option, such a blocked scope is still mirrored, and only the source is blocked.
+	// Blocked scopes that are themselves mirrored sources, or that are not nested inside any mirrored source, are not affected.
+	BlockMirrorsOfBlockedScopes bool
+
+	// KeepSourceOnlyMirrors, if set, preserves a mirror configuration whose mirrors only repeat the source, emitting the source
+	// as an explicit (and only) mirror endpoint for it, with the pull-from-mirror mode of the rule.
+	// This is only useful to restrict how the source is contacted (e.g. only by digest, for an ImageDigestMirrorSet), and it
+	// is only done if no other rule configures real mirrors for the source; mirrorSourcePolicy of such a configuration is ignored.
+	// Without this option, such configurations are ignored, and reported in the returned warnings.
+	KeepSourceOnlyMirrors bool
+
+	// SourceMirrorsLast, if set, moves the source itself, if a mirror set lists it among its mirrors (e.g. to use some mirrors
+	// only if the source is not available), after all other mirrors of the merged mirror set, as a single last fallback.
+	// Without this option, the source keeps its position in the merged order of mirrors, like any other mirror.
+	SourceMirrorsLast bool
+
+	// AllMirrorsInsecure, if set, marks every mirror endpoint generated from the mirror sets as insecure, regardless of
+	// InsecureScopes; the registry entries of the sources themselves are still only insecure if they are nested inside
+	// an entry of InsecureScopes.
+	// This is a blunt instrument, intended for lab environments which use plain HTTP for all mirrors; prefer listing
+	// the mirrors in InsecureScopes otherwise.
+	AllMirrorsInsecure bool
+
+	// DropRedundantBlockedEntries, if set, removes blocked registry entries that are fully redundant with a blocked wildcard
+	// entry they are nested in (e.g. foo.example.com if *.example.com is blocked), to make the generated configuration smaller.
+	// An entry is only removed if the wildcard entry is the one that would govern its scope after the removal, and if neither
+	// of them carries any other configuration (mirrors, mirror-by-digest-only, or a different insecure flag).
+	DropRedundantBlockedEntries bool
+
+	// SortRegistries, if set, sorts the registry entries of the edited configuration (including those already present in it) by scope.
+	// Mirrored sources are always processed in sorted order, but other entries are added in the order of the inputs (e.g. of
+	// InsecureScopes and BlockedScopes), or after the entries they are nested in, so this makes the order of the entries independent of
+	// the order in which the inputs are listed, e.g. by an API server. The order of mirrors within each entry is not affected; since
+	// sysregistriesv2 always uses the most specific matching entry, the order of entries has no effect on its behavior.
+	SortRegistries bool
+
+	// ReportDuplicateSources, if set, reports, in the returned warnings, every source that is listed more than once within
+	// a single object; such entries are merged like entries from different objects, but are likely a mistake.
+	ReportDuplicateSources bool
+
+	// TagMirrorsFirst, if set, adds the mirrors of ImageTagMirrorSet objects before those of ImageDigestMirrorSet and
+	// ImageContentSourcePolicy objects (but still after mirrors already present in the edited configuration), instead of after them.
+	// This usually makes no difference: mirrors that don't apply to a pull (tag-only mirrors for pulls by digest, and digest-only
+	// mirrors for pulls by tag) are skipped. It only matters for mirrors that apply to both kinds of pulls, i.e. those with
+	// a PullFromMirrorAnnotation value of "all": with this option, pulls by tag try the tag-only mirrors before them.
+	TagMirrorsFirst bool
+
+	// DigestOnlyMirrors, if set, enforces that no mirror is used for pulls by tag, e.g. for compliance requirements: every mirror
+	// endpoint of the edited configuration, including those already present in it, is made digest-only. ImageTagMirrorSet objects,
+	// and PullFromMirrorAnnotation values other than "digest-only", can't be represented in this mode, so they make the edit fail
+	// instead of being dropped.
+	// registries.conf has no way to reject pulls by tag from a source while allowing pulls by digest, so this only restricts
+	// mirrors; pulls by tag still contact the source, unless it is blocked (e.g. by mirrorSourcePolicy NeverContactSource
+	// or BlockedScopes), in which case they fail.
+	DigestOnlyMirrors bool
+
+	// DisabledMirrors are mirror locations which are omitted from the mirror sets of the rules, e.g. to temporarily stop using
+	// a mirror during its maintenance, without modifying the objects. Locations are compared exactly, as written in the rules.
+	// A mirror set that is left with no mirrors other than the source is ignored, like one that only lists the source (so,
+	// in particular, its mirrorSourcePolicy is ignored as well, and the source is contacted directly), but without a warning,
+	// and regardless of KeepSourceOnlyMirrors and Strict.
+	DisabledMirrors []string
+
+	// MirrorRewrite, if not nil, is called with the location of every mirror of the mirror sets, and returns the location to use
+	// in the generated mirror endpoint instead, e.g. to route all mirrors through a proxy, or to swap domains; mirrors which
+	// are rewritten to the same location are only added once. It is called before the mirrors are matched against InsecureScopes,
+	// and before mirrors of nested scopes are derived from them. Mirrors already present in the edited configuration are not
+	// rewritten. If it returns an error, or a location that is not valid (per IsValidMirrorLocation), the edit fails.
+	// Mirror locations in annotations, and in DisabledMirrors, refer to the locations before rewriting.
+	MirrorRewrite func(location string) (string, error)
+
+	// LegacyMirrorFormat, if set, represents the pull-from-mirror modes of the mirrors in the edited configuration (including
+	// those already present in it) using the older mirror-by-digest-only setting of registry entries, instead of the per-mirror
+	// pull-from-mirror setting, which older container runtimes, built with a containers/image version without support for it, fail to parse.
+	// mirror-by-digest-only can only describe entries whose mirrors are all digest-only, or all used for every pull, so
+	// ImageTagMirrorSet objects, tag-only mirrors, and entries which mix digest-only mirrors with ones for every pull (e.g. because
+	// of PullFromMirrorAnnotation), make the edit fail.
+	LegacyMirrorFormat bool
+
+	// Strict, if set, makes the edit fail, without modifying the configuration, if any element of the inputs would be
+	// dropped or merged away instead of being represented in the output: mirror configurations that only list the source
+	// (unless KeepSourceOnlyMirrors is set), mirror locations repeated within a single mirror set, and, if ReportDuplicateSources
+	// is set, sources repeated within a single object.
+	// The error lists each such element, with the object it comes from.
+	// It also makes the edit fail if an unqualified search registry of the edited configuration is inside one of BlockedScopes,
+	// which is otherwise reported in the returned warnings.
+	Strict bool
+
+	// Architecture, if set, is the architecture (as in GOARCH) of the nodes the configuration is generated for:
+	// ImageDigestMirrorSet and ImageTagMirrorSet objects with an ArchitectureAnnotation that doesn't include it are ignored.
+	// Without this option, ArchitectureAnnotation is ignored, and all objects are used.
+	Architecture string
+
+	// InsecureSourcesFromAnnotations, if set, adds the sources listed in InsecureSourcesAnnotation of ImageDigestMirrorSet and
+	// ImageTagMirrorSet objects to InsecureScopes (objects ignored because of Architecture don't contribute to it).
+	// Without this option, InsecureSourcesAnnotation is ignored.
+	InsecureSourcesFromAnnotations bool
+
+	// VerifyMirrorModes, if set, makes the edit check, after generating the configuration, that every mirror generated from
+	// the mirror sets has the pull-from-mirror mode implied by the object it comes from (digest-only for ImageDigestMirrorSet,
+	// ImageContentSourcePolicy and ImageContentPolicy objects, tag-only for ImageTagMirrorSet objects), unless it is overridden
+	// by PullFromMirrorAnnotation, allowMirrorByTags or DigestOnlyMirrors; a mismatch is an internal error.
+	// This is a debugging aid, intended for tests and for investigating unexpected output.
+	VerifyMirrorModes bool
+
+	// Observer, if not nil, is notified about the result of a successful edit.
+	Observer MergeObserver
+}
+
+// MergeObserver can be used to collect statistics (e.g. metrics) about edits made by EditRegistriesConfigWithOptions.
+type MergeObserver interface {
+	// ObserveMerge is called once per successful edit, with the number of distinct mirrored sources,
+	// the total number of mirror endpoints, and the numbers of blocked and insecure registry entries,
+	// in the edited configuration.
+	ObserveMerge(sources, mirrors, blocked, insecure int)
+}
+
+// EditRegistriesConfigWithOptions edits, IN PLACE, the /etc/containers/registries.conf configuration provided in config,
+// like EditRegistriesConfig, using the inputs and options in opts.
+// It returns human-readable warnings about inputs that are accepted but are likely to be misconfigurations
+// (e.g. a mirror configuration that lists only the source, and which is ignored), so that callers can report them.
+func EditRegistriesConfigWithOptions(config *sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]string, error) {
+	return editRegistriesConfig(logr.Discard(), config, opts)
+}
+
+// EditRegistriesConfigCtx is EditRegistriesConfigWithOptions, which also logs statistics about the edit, at verbosity level 4,
+// to the logger in ctx (see klog.FromContext).
+func EditRegistriesConfigCtx(ctx context.Context, config *sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]string, error) {
+	return editRegistriesConfig(klog.FromContext(ctx), config, opts)
+}
+
+// EditRegistriesConfigWithChangedScopes is EditRegistriesConfigWithOptions, which also returns the scopes (as in
+// sysregistriesv2.Registry.Prefix) of the registry entries the edit added, changed or removed, compared to config before
+// the edit, sorted and without duplicates (see ChangedScopes), e.g. to report them in events.
+func EditRegistriesConfigWithChangedScopes(config *sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]string, []string, error) {
+	before := CopyRegistriesConf(config)
+	warnings, err := EditRegistriesConfigWithOptions(config, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return warnings, ChangedScopes(before, config), nil
+}
+
+// EditRegistriesConfigBatch is EditRegistriesConfigWithOptions for several templates: it returns an edited copy of each of
+// templates, in order, using the same opts, without modifying templates. The inputs in opts are validated and merged only once.
+// Each returned configuration is independent (it shares no slices with templates or with the other results), so it can be
+// modified separately. Warnings are not returned; they are the same as those returned by EditRegistriesConfigWithOptions (some of
+// them depend on the template, e.g. ones about its unqualified search registries).
+func EditRegistriesConfigBatch(templates []*sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]*sysregistriesv2.V2RegistriesConf, error) {
+	logger := logr.Discard()
+	edit, err := prepareEdit(logger, opts)
+	if err != nil {
+		return nil, err
+	}
+	res := []*sysregistriesv2.V2RegistriesConf{}
+	for _, template := range templates {
+		config := CopyRegistriesConf(template)
+		if err := edit.apply(logger, config); err != nil {
+			return nil, err
+		}
+		res = append(res, config)
+	}
+	return res, nil
+}
+
+// PlanRegistriesConfig returns the result of editing base like EditRegistriesConfigWithOptions, along with the warnings, without
+// modifying base; e.g. to preview an edit, or to check that it would succeed, before persisting it. The result shares no slices
+// with base. opts.Observer is not notified, because no edit is made.
+func PlanRegistriesConfig(base *sysregistriesv2.V2RegistriesConf, opts EditOptions) (*sysregistriesv2.V2RegistriesConf, []string, error) {
+	opts.Observer = nil
+	res := CopyRegistriesConf(base)
+	warnings, err := editRegistriesConfig(logr.Discard(), res, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, warnings, nil
+}
+
+// editRegistriesConfig implements EditRegistriesConfigWithOptions, logging to logger.
+func editRegistriesConfig(logger klog.Logger, config *sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]string, error) {
+	edit, err := prepareEdit(logger, opts)
+	if err != nil {
+		return nil, err
+	}
+	warnings := append(append([]string{}, edit.warnings...), blockedSearchRegistries(config, edit.opts.BlockedScopes)...)
+	if err := edit.apply(logger, config); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// preparedEdit is the part of an edit which only depends on EditOptions, and can be applied to any number of configurations.
+type preparedEdit struct {
+	opts             EditOptions // Normalized
+	warnings         []string
+	digestMirrorSets []mergedMirrorSet
+	tagMirrorSets    []mergedMirrorSet
+}
+
+// prepareEdit validates opts, and merges the mirror sets in them, logging to logger.
+func prepareEdit(logger klog.Logger, opts EditOptions) (*preparedEdit, error) {
+	// The processing below assumes valid scopes; e.g. a wildcard with a path would result in a malformed configuration.
+	if errs := ValidateScopeList(opts.InsecureScopes); len(errs) != 0 {
+		return nil, fmt.Errorf("insecure scopes: %w", errs[0])
+	}
+	if errs := ValidateScopeList(opts.BlockedScopes); len(errs) != 0 {
+		return nil, fmt.Errorf("blocked scopes: %w", errs[0])
+	}
+	if err := validateInputs(opts.ICSPRules, opts.IDMSRules, opts.ITMSRules, opts.ICPRules); err != nil {
+		return nil, err
+	}
+	if opts.Architecture != "" {
+		var err error
+		if opts, err = opts.forArchitecture(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.InsecureSourcesFromAnnotations {
+		var err error
+		if opts, err = opts.withAnnotatedInsecureSources(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.WildcardScopesIncludeApex {
+		opts = opts.withWildcardApexScopes()
+	}
+	if len(opts.DisabledMirrors) != 0 {
+		opts = opts.withoutDisabledMirrors()
+	}
+	if opts.DigestOnlyMirrors && len(opts.ITMSRules) != 0 {
+		errs := []error{}
+		for _, itms := range opts.ITMSRules {
+			errs = append(errs, fmt.Errorf("ImageTagMirrorSet %q is not allowed", itms.Name))
+		}
+		return nil, fmt.Errorf("digest-only mirrors: %w", utilerrors.NewAggregate(errs))
+	}
+	if opts.LegacyMirrorFormat && len(opts.ITMSRules) != 0 {
+		errs := []error{}
+		for _, itms := range opts.ITMSRules {
+			errs = append(errs, fmt.Errorf("ImageTagMirrorSet %q is not allowed", itms.Name))
+		}
+		return nil, fmt.Errorf("legacy mirror format: %w", utilerrors.NewAggregate(errs))
+	}
+	if opts.DigestOnlyMirrors {
+		for _, icp := range opts.ICPRules {
+			for _, set := range icp.Spec.RepositoryDigestMirrors {
+				if set.AllowMirrorByTags && mirrorsContainsARealMirror(set.Source, imageContentPolicyMirrors(set)) {
+					return nil, fmt.Errorf("digest-only mirrors: ImageContentPolicy %q: allowMirrorByTags is not allowed for source %#v", icp.Name, set.Source)
+				}
+			}
+		}
+	}
+	if opts.TreatDefaultPortsAsEqual {
+		opts = opts.withDefaultPortsRemoved()
+	}
+	if err := validateBlockedSourcePolicies(opts); err != nil {
+		return nil, err
+	}
+	warnings := []string{}
+	if !opts.KeepSourceOnlyMirrors {
+		warnings = sourceOnlyMirrorsWarnings(opts)
+		logger.V(4).Info("Dropped mirror configurations that contain only the source", "count", len(warnings))
+	}
+	if opts.ReportDuplicateSources {
+		warnings = append(warnings, duplicateSources(opts)...)
+	}
+	if opts.Strict {
+		dropped := []error{}
+		for _, msg := range append(append([]string{}, warnings...), duplicateMirrors(opts)...) {
+			dropped = append(dropped, errors.New(msg))
+		}
+		if len(dropped) != 0 {
+			return nil, fmt.Errorf("strict mode: some inputs would be dropped: %w", utilerrors.NewAggregate(dropped))
+		}
+	}
+	warnings = append(warnings, ineffectiveInsecureScopesWarnings(opts)...)
+	warnings = append(warnings, mixedSourcePolicyWarnings(opts)...)
+	warnings = append(warnings, sameHostMirrorsWarnings(opts)...)
+	warnings = append(warnings, blockedMirrorsWarnings(opts)...)
+
+	digestMirrorSets, err := mergedDigestMirrorSets(opts.IDMSRules, opts.ICSPRules, opts.ICPRules, opts.KeepSourceOnlyMirrors)
+	if err != nil {
+		return nil, err
+	}
+	tagMirrorSets, err := mergedTagMirrorSets(opts.ITMSRules, opts.KeepSourceOnlyMirrors)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SourceMirrorsLast {
+		digestMirrorSets = withSourceMirrorsLast(digestMirrorSets)
+		tagMirrorSets = withSourceMirrorsLast(tagMirrorSets)
+	}
+	if opts.DigestOnlyMirrors {
+		for _, mirrorSet := range digestMirrorSets {
+			for _, mirror := range mirrorSet.mirrors {
+				if mode, ok := mirrorSet.pullFromMirror[mirror]; ok && mode != sysregistriesv2.MirrorByDigestOnly {
+					return nil, fmt.Errorf("digest-only mirrors: %s value %#v is not allowed for mirror %#v of %#v", PullFromMirrorAnnotation, mode, mirror, mirrorSet.source)
+				}
+			}
+		}
+	}
+	logger.V(4).Info("Merged mirror sets", "digestSources", len(digestMirrorSets), "tagSources", len(tagMirrorSets))
+	res := &preparedEdit{
+		opts:             opts,
+		warnings:         warnings,
+		digestMirrorSets: digestMirrorSets,
+		tagMirrorSets:    tagMirrorSets,
+	}
+	// Report mirrors which can't be used for the nested scopes in the inputs now; apply checks this again for
+	// the entries of the edited configuration.
+	if err := res.validateNestedScopeMirrors(&sysregistriesv2.V2RegistriesConf{}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// validateNestedScopeMirrors returns an error if apply would fail to derive the mirrors of a scope nested inside a mirrored source
+// from the mirrors of the source (see mirrorsAdjustedForNestedScope) when editing config, so that apply can fail before modifying
+// config. config is not modified.
+func (edit *preparedEdit) validateNestedScopeMirrors(config *sysregistriesv2.V2RegistriesConf) error {
+	allMirrorSets := append(append([]mergedMirrorSet{}, edit.digestMirrorSets...), edit.tagMirrorSets...)
+	// The mirrors apply generates for each source, including mirrors already present in the entry it reuses.
+	sourceMirrors := map[string][]sysregistriesv2.Endpoint{} // Key == lowercaseScopeHost(source)
+	for _, mirrorSet := range allMirrorSets {
+		key := lowercaseScopeHost(mirrorSet.source)
+		if _, ok := sourceMirrors[key]; !ok {
+			sourceMirrors[key] = []sysregistriesv2.Endpoint{}
+			for i := range config.Registries {
+				if lowercaseScopeHost(registryScope(&config.Registries[i])) == key {
+					sourceMirrors[key] = append(sourceMirrors[key], config.Registries[i].Mirrors...)
+					break
+				}
+			}
+		}
+		for _, mirror := range mirrorSet.mirrors {
+			location, err := rewriteMirror(edit.opts.MirrorRewrite, mirrorSet.source, mirror)
+			if err != nil {
+				return err
+			}
+			sourceMirrors[key] = append(sourceMirrors[key], sysregistriesv2.Endpoint{Location: location})
+		}
+	}
+
+	// Like apply, derive the mirrors of every entry without mirrors of its own from the first mirrored source it is nested in.
+	hasMirrors := map[string]struct{}{} // Key == lowercaseScopeHost(scope)
+	for key := range sourceMirrors {
+		hasMirrors[key] = struct{}{}
+	}
+	scopes := []string{}
+	for i := range config.Registries {
+		reg := &config.Registries[i]
+		if len(reg.Mirrors) != 0 {
+			hasMirrors[lowercaseScopeHost(registryScope(reg))] = struct{}{}
+		} else {
+			scopes = append(scopes, registryScope(reg))
+		}
+	}
+	scopes = append(append(scopes, edit.opts.BlockedScopes...), edit.opts.InsecureScopes...)
+	for _, mirrorSet := range allMirrorSets {
+		for _, scope := range scopes {
+			key := lowercaseScopeHost(scope)
+			if _, ok := hasMirrors[key]; ok || !ScopeIsNestedInsideScope(scope, mirrorSet.source) {
+				continue
+			}
+			hasMirrors[key] = struct{}{}
+			if _, err := mirrorsAdjustedForNestedScope(mirrorSet.source, scope, sourceMirrors[lowercaseScopeHost(mirrorSet.source)]); err != nil {
+				return err
+			}
+		}
+	}
+	if edit.opts.InheritNestedScopeMirrors {
+		for _, mirrorSet := range allMirrorSets {
+			for _, ancestor := range allMirrorSets {
+				if ancestor.source == mirrorSet.source || !ScopeIsNestedInsideScope(mirrorSet.source, ancestor.source) {
+					continue
+				}
+				if _, err := mirrorsAdjustedForNestedScope(ancestor.source, mirrorSet.source, sourceMirrors[lowercaseScopeHost(ancestor.source)]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// apply edits, IN PLACE, config, logging to logger.
+func (edit *preparedEdit) apply(logger klog.Logger, config *sysregistriesv2.V2RegistriesConf) error {
+	opts := edit.opts
+	if opts.Strict {
+		if blocked := blockedSearchRegistries(config, opts.BlockedScopes); len(blocked) != 0 {
+			errs := []error{}
+			for _, msg := range blocked {
+				errs = append(errs, errors.New(msg))
+			}
+			return fmt.Errorf("strict mode: %w", utilerrors.NewAggregate(errs))
+		}
+	}
+	if err := edit.validateNestedScopeMirrors(config); err != nil {
+		return err
+	}
+	insecureScopes, blockedScopes := opts.InsecureScopes, opts.BlockedScopes
+	digestMirrorSets, tagMirrorSets := edit.digestMirrorSets, edit.tagMirrorSets
+
 	// addRegistryEntry creates a Registry object corresponding to scope.
 	// NOTE: The pointer is valid only until the next getRegistryEntry call.
 	addRegistryEntry := func(scope string) *sysregistriesv2.Registry {
@@ -253,40 +1078,70 @@ func EditRegistriesConfig(config *sysregistriesv2.V2RegistriesConf, insecureScop
 	// getRegistryEntry returns a pointer to a modifiable Registry object corresponding to scope,
 	// creating it if necessary.
 	// If Prefix doesn't have a wildcard entry, we check Location for regular entries.
+	// Host names are compared case-insensitively, like in ScopeIsNestedInsideScope.
 	// NOTE: The pointer is valid only until the next getRegistryEntry call.
 	getRegistryEntry := func(scope string) *sysregistriesv2.Registry {
+		lowerScope := lowercaseScopeHost(scope)
 		for i := range config.Registries {
 			reg := &config.Registries[i]
-			if registryScope(reg) == scope {
+			if lowercaseScopeHost(registryScope(reg)) == lowerScope {
 				return reg
 			}
 		}
 		return addRegistryEntry(scope)
 	}
 
-	addMirrorsToRegistries := func(mergedMirrorSets []mergedMirrorSet, pullFromMirror string) {
+	addMirrorsToRegistries := func(mergedMirrorSets []mergedMirrorSet, newMirror func(location string) sysregistriesv2.Endpoint) error {
 		for _, mirrorItem := range mergedMirrorSets {
 			reg := getRegistryEntry(mirrorItem.source)
 			if mirrorItem.mirrorSourcePolicy == apicfgv1.NeverContactSource {
 				reg.Blocked = true
 			}
+		nextMirror:
 			for _, mirror := range mirrorItem.mirrors {
-				reg.Mirrors = append(reg.Mirrors, sysregistriesv2.Endpoint{Location: mirror, PullFromMirror: pullFromMirror})
+				location, err := rewriteMirror(opts.MirrorRewrite, mirrorItem.source, mirror)
+				if err != nil {
+					return err
+				}
+				endpoint := newMirror(location)
+				if opts.AllMirrorsInsecure {
+					endpoint.Insecure = true
+				}
+				if override, ok := mirrorItem.pullFromMirror[mirror]; ok {
+					endpoint.PullFromMirror = override
+				}
+				if location != mirror {
+					for _, m := range reg.Mirrors {
+						if m == endpoint { // Several mirrors were rewritten to the same location
+							continue nextMirror
+						}
+					}
+				}
+				reg.Mirrors = append(reg.Mirrors, endpoint)
 			}
 		}
+		return nil
 	}
 
-	digestMirrorSets, err := mergedDigestMirrorSets(idmsRules, icspRules)
-	if err != nil {
+	first, second := digestMirrorSets, tagMirrorSets
+	firstMirror, secondMirror := NewDigestMirror, NewTagMirror
+	if opts.TagMirrorsFirst {
+		first, second = tagMirrorSets, digestMirrorSets
+		firstMirror, secondMirror = NewTagMirror, NewDigestMirror
+	}
+	if err := addMirrorsToRegistries(first, firstMirror); err != nil {
 		return err
 	}
-	addMirrorsToRegistries(digestMirrorSets, sysregistriesv2.MirrorByDigestOnly)
-
-	tagMirrorSets, err := mergedTagMirrorSets(itmsRules)
-	if err != nil {
+	if err := addMirrorsToRegistries(second, secondMirror); err != nil {
 		return err
 	}
-	addMirrorsToRegistries(tagMirrorSets, sysregistriesv2.MirrorByTagOnly)
+
+	allMirrorSets := append(append([]mergedMirrorSet{}, digestMirrorSets...), tagMirrorSets...)
+	if opts.InheritNestedScopeMirrors {
+		if err := inheritNestedScopeMirrors(config, allMirrorSets); err != nil {
+			return err
+		}
+	}
 
 	// Add the blocked registry entries to the registries list so that we can find sub-scopes of insecure registries and set both the
 	// blocked and insecure flags accordingly.
@@ -309,12 +1164,6 @@ func EditRegistriesConfig(config *sysregistriesv2.V2RegistriesConf, insecureScop
 			if ScopeIsNestedInsideScope(registryScope(reg), insecureScope) {
 				reg.Insecure = true
 			}
-			for j := range reg.Mirrors {
-				mirror := &reg.Mirrors[j]
-				if ScopeIsNestedInsideScope(mirror.Location, insecureScope) {
-					mirror.Insecure = true
-				}
-			}
 		}
 	}
 	for _, blockedScope := range blockedScopes {
@@ -328,7 +1177,6 @@ func EditRegistriesConfig(config *sysregistriesv2.V2RegistriesConf, insecureScop
 		}
 	}
 
-	allMirrorSets := append(digestMirrorSets, tagMirrorSets...)
 	for _, mirrorSet := range allMirrorSets {
 		mirroredReg := getRegistryEntry(mirrorSet.source)
 		mirroredScope := registryScope(mirroredReg)
@@ -347,9 +1195,769 @@ func EditRegistriesConfig(config *sysregistriesv2.V2RegistriesConf, insecureScop
 			}
 		}
 	}
+	// Mark mirrors nested inside insecure scopes as insecure. This must happen after generating the mirrors for sub-scopes above:
+	// they may be nested inside an insecure scope even if the mirrors they were derived from are not
+	// (e.g. mirror.com/primary/insecure, derived from mirror.com/primary, for an insecure scope mirror.com/primary/insecure).
+	for _, insecureScope := range insecureScopes {
+		for i := range config.Registries {
+			reg := &config.Registries[i]
+			for j := range reg.Mirrors {
+				mirror := &reg.Mirrors[j]
+				if ScopeIsNestedInsideScope(mirror.Location, insecureScope) {
+					mirror.Insecure = true
+				}
+			}
+		}
+	}
+
+	if opts.BlockMirrorsOfBlockedScopes {
+		mirroredSources := map[string]struct{}{}
+		for _, mirrorSet := range allMirrorSets {
+			mirroredSources[mirrorSet.source] = struct{}{}
+		}
+		for _, blockedScope := range blockedScopes {
+			if _, ok := mirroredSources[blockedScope]; ok {
+				continue
+			}
+			// Copy the mirrors, getRegistryEntry may invalidate the pointer.
+			mirrors := append([]sysregistriesv2.Endpoint{}, getRegistryEntry(blockedScope).Mirrors...)
+			for _, mirror := range mirrors {
+				reg := getRegistryEntry(mirror.Location)
+				reg.Blocked = true
+				reg.Insecure = reg.Insecure || mirror.Insecure // Don't make an existing insecure entry secure
+			}
+		}
+	}
+	if opts.DigestOnlyMirrors {
+		for i := range config.Registries {
+			reg := &config.Registries[i]
+			if reg.MirrorByDigestOnly { // sysregistriesv2 doesn't allow pull-from-mirror values for mirrors of such an entry
+				continue
+			}
+			for j := range reg.Mirrors {
+				reg.Mirrors[j].PullFromMirror = sysregistriesv2.MirrorByDigestOnly
+			}
+		}
+	}
+	if opts.LegacyMirrorFormat {
+		if err := useLegacyMirrorFormat(config); err != nil {
+			return fmt.Errorf("legacy mirror format: %w", err)
+		}
+	}
+	if opts.DropRedundantBlockedEntries {
+		dropRedundantBlockedEntries(config)
+	}
+	if opts.SortRegistries {
+		sort.SliceStable(config.Registries, func(i, j int) bool {
+			return registryScope(&config.Registries[i]) < registryScope(&config.Registries[j])
+		})
+	}
+	// The edit should never create duplicate entries, but the edited configuration may already contain some.
+	if err := ValidateNoDuplicateScopes(config); err != nil {
+		return err
+	}
+	if opts.VerifyMirrorModes {
+		if err := verifyMirrorModes(config, edit); err != nil {
+			return fmt.Errorf("internal error: %w", err)
+		}
+	}
+	logger.V(4).Info("Edited registries configuration", "registries", len(config.Registries))
+	if opts.Observer != nil {
+		sources := map[string]struct{}{}
+		for _, mirrorSet := range allMirrorSets {
+			sources[mirrorSet.source] = struct{}{}
+		}
+		mirrors, blocked, insecure := 0, 0, 0
+		for _, reg := range config.Registries {
+			mirrors += len(reg.Mirrors)
+			if reg.Blocked {
+				blocked++
+			}
+			if reg.Insecure {
+				insecure++
+			}
+		}
+		opts.Observer.ObserveMerge(len(sources), mirrors, blocked, insecure)
+	}
 	return nil
 }
 
+// dropRedundantBlockedEntries implements EditOptions.DropRedundantBlockedEntries.
+func dropRedundantBlockedEntries(config *sysregistriesv2.V2RegistriesConf) {
+	isPureBlock := func(reg *sysregistriesv2.Registry) bool {
+		return reg.Blocked && len(reg.Mirrors) == 0 && !reg.MirrorByDigestOnly && reg.PullFromMirror == ""
+	}
+	// Removing an entry can make another one redundant (e.g. foo.example.com/ns inside foo.example.com), so repeat until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for i := range config.Registries {
+			reg := &config.Registries[i]
+			if !isPureBlock(reg) || (reg.Prefix != "" && reg.Location != "" && reg.Prefix != reg.Location) {
+				continue
+			}
+			parent, parentScope := findGoverningRegistry(config, canonicalScope(registryScope(reg), nil), nil, i)
+			if parent != nil && strings.HasPrefix(parentScope, "*.") && isPureBlock(parent) && parent.Insecure == reg.Insecure {
+				config.Registries = append(config.Registries[:i], config.Registries[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+}
+
+// useLegacyMirrorFormat implements EditOptions.LegacyMirrorFormat, replacing, IN PLACE, the pull-from-mirror values of all mirrors
+// in config by the mirror-by-digest-only setting of their registry entries.
+func useLegacyMirrorFormat(config *sysregistriesv2.V2RegistriesConf) error {
+	for i := range config.Registries {
+		reg := &config.Registries[i]
+		if len(reg.Mirrors) == 0 {
+			continue
+		}
+		digestOnly, all := "", "" // The first mirror with each mode
+		for _, mirror := range reg.Mirrors {
+			switch effectiveMirrorMode(reg, mirror) {
+			case sysregistriesv2.MirrorByDigestOnly:
+				if digestOnly == "" {
+					digestOnly = mirror.Location
+				}
+			case sysregistriesv2.MirrorByTagOnly:
+				return fmt.Errorf("registry %#v: tag-only mirror %#v can't be represented", registryScope(reg), mirror.Location)
+			default:
+				if all == "" {
+					all = mirror.Location
+				}
+			}
+		}
+		if digestOnly != "" && all != "" {
+			return fmt.Errorf("registry %#v: digest-only mirror %#v and mirror %#v, which is used for all pulls, can't be represented together",
+				registryScope(reg), digestOnly, all)
+		}
+		reg.MirrorByDigestOnly = digestOnly != ""
+		for j := range reg.Mirrors {
+			reg.Mirrors[j].PullFromMirror = ""
+		}
+	}
+	return nil
+}
+
+// rewriteMirror returns the location to use for mirror, a mirror of source, according to rewrite (see EditOptions.MirrorRewrite),
+// which may be nil.
+func rewriteMirror(rewrite func(location string) (string, error), source, mirror string) (string, error) {
+	if rewrite == nil {
+		return mirror, nil
+	}
+	res, err := rewrite(mirror)
+	if err != nil {
+		return "", fmt.Errorf("rewriting mirror %#v of %#v: %w", mirror, source, err)
+	}
+	if !IsValidMirrorLocation(res) {
+		return "", fmt.Errorf("rewriting mirror %#v of %#v: invalid location %#v", mirror, source, res)
+	}
+	return res, nil
+}
+
+// inheritNestedScopeMirrors implements EditOptions.InheritNestedScopeMirrors for the registry entries of mirrorSets,
+// which must already exist in config.
+func inheritNestedScopeMirrors(config *sysregistriesv2.V2RegistriesConf, mirrorSets []mergedMirrorSet) error {
+	sources := []string{}
+	ownMirrors := map[string][]sysregistriesv2.Endpoint{} // Key == Source
+	for i := range config.Registries {
+		reg := &config.Registries[i]
+		scope := registryScope(reg)
+		for _, mirrorSet := range mirrorSets {
+			if scope == mirrorSet.source {
+				if _, ok := ownMirrors[scope]; !ok {
+					sources = append(sources, scope)
+					ownMirrors[scope] = append([]sysregistriesv2.Endpoint{}, reg.Mirrors...)
+				}
+				break
+			}
+		}
+	}
+
+	for i := range config.Registries {
+		reg := &config.Registries[i]
+		scope := registryScope(reg)
+		if _, ok := ownMirrors[scope]; !ok {
+			continue
+		}
+		ancestors := []string{}
+		for _, source := range sources {
+			if source != scope && ScopeIsNestedInsideScope(scope, source) {
+				ancestors = append(ancestors, source)
+			}
+		}
+		// The enclosing scope that would govern scope (if it had no entry of its own) first, as in sysregistriesv2.
+		sort.SliceStable(ancestors, func(i, j int) bool {
+			return prefixTakesPrecedence(ancestors[i], ancestors[j])
+		})
+		for _, ancestor := range ancestors {
+			inherited, err := mirrorsAdjustedForNestedScope(ancestor, scope, ownMirrors[ancestor])
+			if err != nil {
+				return err
+			}
+			for _, mirror := range inherited {
+				present := false
+				for _, existing := range reg.Mirrors {
+					if existing == mirror {
+						present = true
+						break
+					}
+				}
+				if !present {
+					reg.Mirrors = append(reg.Mirrors, mirror)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateBlockedSourcePolicies returns an error if a scope in opts.BlockedScopes is, or contains, a source of an
+// ImageDigestMirrorSet or ImageTagMirrorSet rule that explicitly sets MirrorSourcePolicy to AllowContactingSource,
+// which is contradictory. (An unset MirrorSourcePolicy, and ImageContentSourcePolicy rules, which have no policy, are
+// accepted: blocking the source of such rules is the traditional way to prevent contacting it.)
+func validateBlockedSourcePolicies(opts EditOptions) error {
+	check := func(kind, name, source string, policy apicfgv1.MirrorSourcePolicy) error {
+		if policy != apicfgv1.AllowContactingSource {
+			return nil
+		}
+		for _, blockedScope := range opts.BlockedScopes {
+			if ScopeIsNestedInsideScope(source, blockedScope) {
+				return fmt.Errorf("%s %q: source %q is blocked, but the mirror configuration uses mirrorSourcePolicy %s", kind, name, source, policy)
+			}
+		}
+		return nil
+	}
+	for _, idms := range opts.IDMSRules {
+		for _, set := range idms.Spec.ImageDigestMirrors {
+			if err := check("ImageDigestMirrorSet", idms.Name, set.Source, set.MirrorSourcePolicy); err != nil {
+				return err
+			}
+		}
+	}
+	for _, itms := range opts.ITMSRules {
+		for _, set := range itms.Spec.ImageTagMirrors {
+			if err := check("ImageTagMirrorSet", itms.Name, set.Source, set.MirrorSourcePolicy); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// imageContentPolicyMirrors returns the mirrors of set, an ImageContentPolicy mirror set.
+func imageContentPolicyMirrors(set apicfgv1.RepositoryDigestMirrors) []apicfgv1.ImageMirror {
+	res := []apicfgv1.ImageMirror{}
+	for _, m := range set.Mirrors {
+		res = append(res, apicfgv1.ImageMirror(m))
+	}
+	return res
+}
+
+// forEachMirrorSet calls fn for each mirror set of the rules in opts, with the kind and name of the object it comes from.
+// policy is the mirrorSourcePolicy of the mirror set; it is "" for ImageContentSourcePolicy and ImageContentPolicy, which don't have one.
+func forEachMirrorSet(opts EditOptions, fn func(kind, name, source string, policy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror)) {
+	for _, icsp := range opts.ICSPRules {
+		for _, set := range icsp.Spec.RepositoryDigestMirrors {
+			imgMirrors := []apicfgv1.ImageMirror{}
+			for _, m := range set.Mirrors {
+				imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
+			}
+			fn("ImageContentSourcePolicy", icsp.Name, set.Source, "", imgMirrors)
+		}
+	}
+	for _, icp := range opts.ICPRules {
+		for _, set := range icp.Spec.RepositoryDigestMirrors {
+			fn("ImageContentPolicy", icp.Name, set.Source, "", imageContentPolicyMirrors(set))
+		}
+	}
+	for _, idms := range opts.IDMSRules {
+		for _, set := range idms.Spec.ImageDigestMirrors {
+			fn("ImageDigestMirrorSet", idms.Name, set.Source, set.MirrorSourcePolicy, set.Mirrors)
+		}
+	}
+	for _, itms := range opts.ITMSRules {
+		for _, set := range itms.Spec.ImageTagMirrors {
+			fn("ImageTagMirrorSet", itms.Name, set.Source, set.MirrorSourcePolicy, set.Mirrors)
+		}
+	}
+}
+
+// withMirrorSetsMapped returns a copy of opts in which the source and the mirrors of each mirror set of the rules are replaced by
+// the values returned by fn, which is called like by forEachMirrorSet (without the policy). The rules in opts are not modified.
+func (opts EditOptions) withMirrorSetsMapped(fn func(kind, name, source string, mirrors []apicfgv1.ImageMirror) (string, []apicfgv1.ImageMirror)) EditOptions {
+	res := opts
+	res.ICSPRules = []*apioperatorsv1alpha1.ImageContentSourcePolicy{}
+	for _, icsp := range opts.ICSPRules {
+		icsp = icsp.DeepCopy()
+		for i := range icsp.Spec.RepositoryDigestMirrors {
+			set := &icsp.Spec.RepositoryDigestMirrors[i]
+			imgMirrors := []apicfgv1.ImageMirror{}
+			for _, m := range set.Mirrors {
+				imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
+			}
+			source, mirrors := fn("ImageContentSourcePolicy", icsp.Name, set.Source, imgMirrors)
+			set.Source, set.Mirrors = source, []string{}
+			for _, m := range mirrors {
+				set.Mirrors = append(set.Mirrors, string(m))
+			}
+		}
+		res.ICSPRules = append(res.ICSPRules, icsp)
+	}
+	res.ICPRules = []*apicfgv1.ImageContentPolicy{}
+	for _, icp := range opts.ICPRules {
+		icp = icp.DeepCopy()
+		for i := range icp.Spec.RepositoryDigestMirrors {
+			set := &icp.Spec.RepositoryDigestMirrors[i]
+			source, mirrors := fn("ImageContentPolicy", icp.Name, set.Source, imageContentPolicyMirrors(*set))
+			set.Source, set.Mirrors = source, []apicfgv1.Mirror{}
+			for _, m := range mirrors {
+				set.Mirrors = append(set.Mirrors, apicfgv1.Mirror(m))
+			}
+		}
+		res.ICPRules = append(res.ICPRules, icp)
+	}
+	res.IDMSRules = []*apicfgv1.ImageDigestMirrorSet{}
+	for _, idms := range opts.IDMSRules {
+		idms = idms.DeepCopy()
+		for i := range idms.Spec.ImageDigestMirrors {
+			set := &idms.Spec.ImageDigestMirrors[i]
+			set.Source, set.Mirrors = fn("ImageDigestMirrorSet", idms.Name, set.Source, set.Mirrors)
+		}
+		res.IDMSRules = append(res.IDMSRules, idms)
+	}
+	res.ITMSRules = []*apicfgv1.ImageTagMirrorSet{}
+	for _, itms := range opts.ITMSRules {
+		itms = itms.DeepCopy()
+		for i := range itms.Spec.ImageTagMirrors {
+			set := &itms.Spec.ImageTagMirrors[i]
+			set.Source, set.Mirrors = fn("ImageTagMirrorSet", itms.Name, set.Source, set.Mirrors)
+		}
+		res.ITMSRules = append(res.ITMSRules, itms)
+	}
+	return res
+}
+
+// sourceOnlyMirrorsWarnings returns a warning for each rule in opts which lists mirrors, but only ones equal to the source.
+// Such rules are silently ignored by the merge (see mirrorSets.addMirrorSet).
+func sourceOnlyMirrorsWarnings(opts EditOptions) []string {
+	res := []string{}
+	forEachMirrorSet(opts, func(kind, name, source string, _ apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if len(mirrors) != 0 && !mirrorsContainsARealMirror(source, mirrors) {
+			res = append(res, fmt.Sprintf("%s %q: mirrors of %q contain only the source, ignoring", kind, name, source))
+		}
+	})
+	return res
+}
+
+// sameHostMirrorsWarnings returns a warning for each mirror in opts which is on the same registry host (including the :port,
+// compared case-insensitively) as its source, but not equal to it (e.g. quay.io/prod mirrored to quay.io/prod-mirror):
+// such a mirror is usually a mistake, because it is served by the same registry as the source. Mirrors equal to the source
+// are reported by sourceOnlyMirrorsWarnings, if there are no other mirrors.
+func sameHostMirrorsWarnings(opts EditOptions) []string {
+	hostPort := func(scope string) string {
+		if i := strings.IndexByte(scope, '/'); i != -1 {
+			scope = scope[:i]
+		}
+		return strings.ToLower(scope)
+	}
+	res := []string{}
+	forEachMirrorSet(opts, func(kind, name, source string, _ apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if strings.HasPrefix(source, "*.") {
+			return
+		}
+		for _, mirror := range mirrors {
+			if string(mirror) != source && hostPort(string(mirror)) == hostPort(source) {
+				res = appendUnique(res, fmt.Sprintf("%s %q: mirror %q of %q is on the same registry host as the source", kind, name, mirror, source))
+			}
+		}
+	})
+	return res
+}
+
+// blockedMirrorsWarnings returns a warning for each mirror in opts which is nested inside one of opts.BlockedScopes (e.g. a mirror
+// mirror.example.com/prod with a blocked scope *.example.com): the blocked flag applies to the mirror location as well, so pulls
+// from the mirror fail, which is easy to miss, especially in disconnected setups. Mirrors equal to their source are ignored.
+func blockedMirrorsWarnings(opts EditOptions) []string {
+	res := []string{}
+	forEachMirrorSet(opts, func(kind, name, source string, _ apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		for _, mirror := range mirrors {
+			if string(mirror) == source {
+				continue
+			}
+			for _, blocked := range opts.BlockedScopes {
+				if ScopeIsNestedInsideScope(string(mirror), blocked) {
+					res = appendUnique(res, fmt.Sprintf("%s %q: mirror %q of %q is inside blocked scope %q", kind, name, mirror, source, blocked))
+				}
+			}
+		}
+	})
+	return res
+}
+
+// blockedSearchRegistries returns a description of each entry of config.UnqualifiedSearchRegistries which is nested inside one of
+// blockedScopes (e.g. quay.io with a blocked scope quay.io, or registry.example.com with *.example.com): short-name pulls
+// which try it fail, which is confusing, so it is likely that the search registry was forgotten when blocking the scope.
+func blockedSearchRegistries(config *sysregistriesv2.V2RegistriesConf, blockedScopes []string) []string {
+	res := []string{}
+	for _, registry := range config.UnqualifiedSearchRegistries {
+		for _, blocked := range blockedScopes {
+			if ScopeIsNestedInsideScope(registry, blocked) {
+				res = append(res, fmt.Sprintf("unqualified search registry %q is inside blocked scope %q, so short-name pulls from it fail", registry, blocked))
+				break
+			}
+		}
+	}
+	return res
+}
+
+// duplicateSources returns a description of each source listed more than once within a single object of the rules in opts.
+func duplicateSources(opts EditOptions) []string {
+	res := []string{}
+	// check returns a function which reports the sources it is called with more than once for kind and name.
+	check := func(kind, name string) func(source string) {
+		seen := map[string]struct{}{}
+		reported := map[string]struct{}{}
+		return func(source string) {
+			if _, ok := seen[source]; !ok {
+				seen[source] = struct{}{}
+				return
+			}
+			if _, ok := reported[source]; !ok {
+				reported[source] = struct{}{}
+				res = append(res, fmt.Sprintf("%s %q: duplicate source %q, merging its mirror sets", kind, name, source))
+			}
+		}
+	}
+	for _, icsp := range opts.ICSPRules {
+		add := check("ImageContentSourcePolicy", icsp.Name)
+		for _, set := range icsp.Spec.RepositoryDigestMirrors {
+			add(set.Source)
+		}
+	}
+	for _, icp := range opts.ICPRules {
+		add := check("ImageContentPolicy", icp.Name)
+		for _, set := range icp.Spec.RepositoryDigestMirrors {
+			add(set.Source)
+		}
+	}
+	for _, idms := range opts.IDMSRules {
+		add := check("ImageDigestMirrorSet", idms.Name)
+		for _, set := range idms.Spec.ImageDigestMirrors {
+			add(set.Source)
+		}
+	}
+	for _, itms := range opts.ITMSRules {
+		add := check("ImageTagMirrorSet", itms.Name)
+		for _, set := range itms.Spec.ImageTagMirrors {
+			add(set.Source)
+		}
+	}
+	return res
+}
+
+// ineffectiveInsecureScopesWarnings returns a warning for each mirror set in opts with mirrorSourcePolicy NeverContactSource,
+// whose source is inside an insecure scope which doesn't contain any of its mirrors: the scope only marks the source as insecure,
+// which has no effect because the source is never contacted.
+func ineffectiveInsecureScopesWarnings(opts EditOptions) []string {
+	res := []string{}
+	check := func(kind, name, source string, policy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if policy != apicfgv1.NeverContactSource || !mirrorsContainsARealMirror(source, mirrors) {
+			return
+		}
+	nextScope:
+		for _, insecure := range opts.InsecureScopes {
+			if !ScopeIsNestedInsideScope(source, insecure) {
+				continue
+			}
+			for _, mirror := range mirrors {
+				if ScopeIsNestedInsideScope(string(mirror), insecure) {
+					continue nextScope
+				}
+			}
+			res = append(res, fmt.Sprintf("%s %q: source %q uses mirrorSourcePolicy NeverContactSource, so insecure scope %q has no effect on it", kind, name, source, insecure))
+		}
+	}
+	for _, idms := range opts.IDMSRules {
+		for _, set := range idms.Spec.ImageDigestMirrors {
+			check("ImageDigestMirrorSet", idms.Name, set.Source, set.MirrorSourcePolicy, set.Mirrors)
+		}
+	}
+	for _, itms := range opts.ITMSRules {
+		for _, set := range itms.Spec.ImageTagMirrors {
+			check("ImageTagMirrorSet", itms.Name, set.Source, set.MirrorSourcePolicy, set.Mirrors)
+		}
+	}
+	return res
+}
+
+// mixedSourcePolicyWarnings returns a warning for each mirror set in opts whose source is blocked because a mirror set of the
+// other kind (digest or tag) for the same source uses mirrorSourcePolicy NeverContactSource, although the mirror set itself
+// doesn't: registries.conf can only block a source as a whole, so pulls of its kind only use its mirrors, which may be surprising.
+// Mirror sets that only list the source are ignored, like their mirrorSourcePolicy is by the merge.
+func mixedSourcePolicyWarnings(opts EditOptions) []string {
+	digestBlocking := map[string]string{} // Key == source, value == name of the first ImageDigestMirrorSet with NeverContactSource
+	tagBlocking := map[string]string{}    // Key == source, value == name of the first ImageTagMirrorSet with NeverContactSource
+	forEachMirrorSet(opts, func(kind, name, source string, policy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if policy != apicfgv1.NeverContactSource || !mirrorsContainsARealMirror(source, mirrors) {
+			return // ImageContentSourcePolicy and ImageContentPolicy have no policy, so they never block a source
+		}
+		blocking := digestBlocking
+		if kind == "ImageTagMirrorSet" {
+			blocking = tagBlocking
+		}
+		if _, ok := blocking[source]; !ok {
+			blocking[source] = name
+		}
+	})
+
+	res := []string{}
+	forEachMirrorSet(opts, func(kind, name, source string, policy apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if policy == apicfgv1.NeverContactSource || !mirrorsContainsARealMirror(source, mirrors) {
+			return
+		}
+		// ImageContentSourcePolicy and ImageContentPolicy mirrors are used for pulls by digest, like ImageDigestMirrorSet mirrors.
+		blocking, blockingKind, pullKind := tagBlocking, "ImageTagMirrorSet", "digest"
+		if kind == "ImageTagMirrorSet" {
+			blocking, blockingKind, pullKind = digestBlocking, "ImageDigestMirrorSet", "tag"
+		}
+		if blockingName, ok := blocking[source]; ok {
+			res = appendUnique(res, fmt.Sprintf("%s %q: source %q is never contacted, because %s %q uses mirrorSourcePolicy NeverContactSource for it; pulls by %s only use the mirrors",
+				kind, name, source, blockingKind, blockingName, pullKind))
+		}
+	})
+	return res
+}
+
+// duplicateMirrors returns a description of each repeated mirror location within a single mirror set of the rules in opts.
+// Only the first occurrence is used by the merge (see mirrorSets.addMirrorSet). Mirror sets which only list the source
+// are not included, they are reported by sourceOnlyMirrorsWarnings.
+func duplicateMirrors(opts EditOptions) []string {
+	res := []string{}
+	forEachMirrorSet(opts, func(kind, name, source string, _ apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if !mirrorsContainsARealMirror(source, mirrors) {
+			return
+		}
+		seen := map[apicfgv1.ImageMirror]struct{}{}
+		for _, mirror := range mirrors {
+			if _, ok := seen[mirror]; ok {
+				res = append(res, fmt.Sprintf("%s %q: duplicate mirror %q of %q, ignoring", kind, name, mirror, source))
+				continue
+			}
+			seen[mirror] = struct{}{}
+		}
+	})
+	return res
+}
+
+// withoutDefaultPort returns scope with an explicit default port removed from its host part.
+// If insecure, both :443 and :80 are considered default ports, otherwise only :443 is.
+func withoutDefaultPort(scope string, insecure bool) string {
+	hostLen := scopeHostLen(scope)
+	port, rest := scope[hostLen:], ""
+	if i := strings.IndexByte(port, '/'); i != -1 {
+		port, rest = port[:i], port[i:]
+	}
+	for _, defaultPort := range []string{":443", ":80"} {
+		if port == defaultPort {
+			return scope[:hostLen] + rest
+		}
+		if !insecure {
+			break
+		}
+	}
+	return scope
+}
+
+// forArchitecture returns a copy of opts without the ImageDigestMirrorSet and ImageTagMirrorSet objects that don't apply
+// to opts.Architecture (see ArchitectureAnnotation). The rules in opts are not modified.
+func (opts EditOptions) forArchitecture() (EditOptions, error) {
+	applies := func(kind, name string, annotations map[string]string) (bool, error) {
+		archs, err := architectures(annotations)
+		if err != nil {
+			return false, fmt.Errorf("%s %q: %w", kind, name, err)
+		}
+		_, ok := archs[opts.Architecture]
+		return archs == nil || ok, nil
+	}
+	idmsRules := []*apicfgv1.ImageDigestMirrorSet{}
+	for _, idms := range opts.IDMSRules {
+		ok, err := applies("ImageDigestMirrorSet", idms.Name, idms.Annotations)
+		if err != nil {
+			return EditOptions{}, err
+		}
+		if ok {
+			idmsRules = append(idmsRules, idms)
+		}
+	}
+	itmsRules := []*apicfgv1.ImageTagMirrorSet{}
+	for _, itms := range opts.ITMSRules {
+		ok, err := applies("ImageTagMirrorSet", itms.Name, itms.Annotations)
+		if err != nil {
+			return EditOptions{}, err
+		}
+		if ok {
+			itmsRules = append(itmsRules, itms)
+		}
+	}
+	opts.IDMSRules, opts.ITMSRules = idmsRules, itmsRules
+	return opts, nil
+}
+
+// withAnnotatedInsecureSources returns a copy of opts with the sources listed in InsecureSourcesAnnotation of its rules added
+// to InsecureScopes. The rules in opts are not modified.
+func (opts EditOptions) withAnnotatedInsecureSources() (EditOptions, error) {
+	insecureScopes := append([]string{}, opts.InsecureScopes...)
+	add := func(kind, name string, annotations map[string]string, sources map[string]struct{}) error {
+		listed, err := insecureSources(annotations)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", kind, name, err)
+		}
+		for _, source := range listed {
+			if _, ok := sources[source]; !ok {
+				return fmt.Errorf("%s %q: %s lists %#v, which is not a source of this object", kind, name, InsecureSourcesAnnotation, source)
+			}
+			insecureScopes = appendUnique(insecureScopes, source)
+		}
+		return nil
+	}
+	for _, idms := range opts.IDMSRules {
+		sources := map[string]struct{}{}
+		for _, set := range idms.Spec.ImageDigestMirrors {
+			sources[set.Source] = struct{}{}
+		}
+		if err := add("ImageDigestMirrorSet", idms.Name, idms.Annotations, sources); err != nil {
+			return EditOptions{}, err
+		}
+	}
+	for _, itms := range opts.ITMSRules {
+		sources := map[string]struct{}{}
+		for _, set := range itms.Spec.ImageTagMirrors {
+			sources[set.Source] = struct{}{}
+		}
+		if err := add("ImageTagMirrorSet", itms.Name, itms.Annotations, sources); err != nil {
+			return EditOptions{}, err
+		}
+	}
+	opts.InsecureScopes = insecureScopes
+	return opts, nil
+}
+
+// withWildcardApexScopes returns a copy of opts with the domain of every wildcard entry of InsecureScopes and BlockedScopes
+// (e.g. example.com for *.example.com) added to the same list, if it is not already listed. opts is not modified.
+func (opts EditOptions) withWildcardApexScopes() EditOptions {
+	withApex := func(scopes []string) []string {
+		res := append([]string{}, scopes...)
+		for _, scope := range scopes {
+			if strings.HasPrefix(scope, "*.") {
+				res = appendUnique(res, strings.TrimPrefix(scope, "*."))
+			}
+		}
+		return res
+	}
+	opts.InsecureScopes = withApex(opts.InsecureScopes)
+	opts.BlockedScopes = withApex(opts.BlockedScopes)
+	return opts
+}
+
+// withoutDisabledMirrors returns a copy of opts with the mirrors listed in opts.DisabledMirrors removed from all rules.
+// A mirror set left with no mirrors other than the source is emptied, so that it is ignored without being reported
+// by sourceOnlyMirrorsWarnings: listing only the source is not a mistake in the rule in that case.
+// The rules in opts are not modified.
+func (opts EditOptions) withoutDisabledMirrors() EditOptions {
+	disabled := map[string]struct{}{}
+	for _, location := range opts.DisabledMirrors {
+		disabled[location] = struct{}{}
+	}
+	return opts.withMirrorSetsMapped(func(kind, name, source string, mirrors []apicfgv1.ImageMirror) (string, []apicfgv1.ImageMirror) {
+		res := []apicfgv1.ImageMirror{}
+		removed := false
+		for _, mirror := range mirrors {
+			if _, ok := disabled[string(mirror)]; ok {
+				removed = true
+				continue
+			}
+			res = append(res, mirror)
+		}
+		if removed && !mirrorsContainsARealMirror(source, res) {
+			res = []apicfgv1.ImageMirror{}
+		}
+		return source, res
+	})
+}
+
+// withDefaultPortsRemoved returns a copy of opts with withoutDefaultPort applied to all scopes.
+// The rules in opts are not modified.
+func (opts EditOptions) withDefaultPortsRemoved() EditOptions {
+	insecureScopes := []string{}
+	for _, scope := range opts.InsecureScopes {
+		insecureScopes = append(insecureScopes, withoutDefaultPort(scope, true))
+	}
+	normalize := func(scope string) string {
+		for _, insecureScope := range insecureScopes {
+			if ScopeIsNestedInsideScope(withoutDefaultPort(scope, true), insecureScope) {
+				return withoutDefaultPort(scope, true)
+			}
+		}
+		return withoutDefaultPort(scope, false)
+	}
+
+	res := opts.withMirrorSetsMapped(func(kind, name, source string, mirrors []apicfgv1.ImageMirror) (string, []apicfgv1.ImageMirror) {
+		normalized := []apicfgv1.ImageMirror{}
+		for _, mirror := range mirrors {
+			normalized = append(normalized, apicfgv1.ImageMirror(normalize(string(mirror))))
+		}
+		return normalize(source), normalized
+	})
+	res.InsecureScopes = insecureScopes
+	res.BlockedScopes = []string{}
+	for _, scope := range opts.BlockedScopes {
+		res.BlockedScopes = append(res.BlockedScopes, normalize(scope))
+	}
+	return res
+}
+
+// EditRegistriesConfigFromImage edits, IN PLACE, the /etc/containers/registries.conf configuration provided in config,
+// like EditRegistriesConfig, deriving insecureScopes and blockedScopes from img.Spec.RegistrySources.
+// AllowedRegistries and BlockedRegistries are mutually exclusive; an error is returned if both are set.
+// AllowedRegistries itself is not represented in registries.conf (it is enforced via policy.json), so it only
+// participates in that check: registries.conf has no catch-all scope that could block "everything else"
+// (a Prefix must be a host[:port][/path] or a *.example.com wildcard), so an allow-list can't be expressed here
+// without blocking unrelated registries. Allowed registries keep their mirror and insecure configuration as usual.
+// img may be nil, in which case no scopes are marked insecure or blocked.
+func EditRegistriesConfigFromImage(config *sysregistriesv2.V2RegistriesConf, img *apicfgv1.Image, icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy,
+	idmsRules []*apicfgv1.ImageDigestMirrorSet, itmsRules []*apicfgv1.ImageTagMirrorSet,
+) error {
+	var insecureScopes, blockedScopes []string
+	if img != nil {
+		sources := img.Spec.RegistrySources
+		if len(sources.AllowedRegistries) > 0 && len(sources.BlockedRegistries) > 0 {
+			return fmt.Errorf("only one of allowedRegistries and blockedRegistries may be set in image.config.openshift.io %q", img.Name)
+		}
+		insecureScopes = sources.InsecureRegistries
+		blockedScopes = sources.BlockedRegistries
+	}
+	return EditRegistriesConfig(config, insecureScopes, blockedScopes, icspRules, idmsRules, itmsRules)
+}
+
+// RegistrySourcesFromScopes returns the image.config.openshift.io RegistrySources that mark insecure and blocked as insecure and
+// blocked scopes, i.e. the inverse of how EditRegistriesConfigFromImage derives them; e.g. to reflect scopes edited in registries.conf
+// back into the cluster's Image configuration. AllowedRegistries is never set, so the result can always be used with blocked scopes.
+// Scopes are validated like by EditRegistriesConfig, and an error naming the first invalid one is returned.
+func RegistrySourcesFromScopes(insecure, blocked []string) (apicfgv1.RegistrySources, error) {
+	if errs := ValidateScopeList(insecure); len(errs) != 0 {
+		return apicfgv1.RegistrySources{}, fmt.Errorf("insecure scopes: %w", errs[0])
+	}
+	if errs := ValidateScopeList(blocked); len(errs) != 0 {
+		return apicfgv1.RegistrySources{}, fmt.Errorf("blocked scopes: %w", errs[0])
+	}
+	return apicfgv1.RegistrySources{
+		InsecureRegistries: append([]string(nil), insecure...),
+		BlockedRegistries:  append([]string(nil), blocked...),
+	}, nil
+}
+
 // IsValidRegistriesConfScope returns true if scope is a valid scope for the Prefix key in registries.conf
 // This function can be used to validate the registries entries prior to calling EditRegistriesConfig
 // in the MCO or builds code
@@ -357,6 +1965,18 @@ func IsValidRegistriesConfScope(scope string) bool {
 	if scope == "" {
 		return false
 	}
+	// Brackets are only valid around an IPv6 literal host name, e.g. [fd00::1]:5000/ns
+	if strings.ContainsAny(scope, "[]") {
+		hostLen := scopeHostLen(scope)
+		rest := scope[hostLen:]
+		if !strings.HasPrefix(scope, "[") || scope[hostLen-1] != ']' || strings.ContainsAny(rest, "[]") ||
+			(rest != "" && rest[0] != ':' && rest[0] != '/') {
+			return false
+		}
+		if ip := net.ParseIP(scope[1 : hostLen-1]); ip == nil || !strings.Contains(scope[1:hostLen-1], ":") {
+			return false
+		}
+	}
 	// If scope does not contain the wildcard character, we will assume it is a regular registry entry, which is valid
 	if !strings.Contains(scope, "*") {
 		return true
@@ -368,3 +1988,34 @@ func IsValidRegistriesConfScope(scope string) bool {
 	}
 	return false
 }
+
+// IsValidMirrorLocation returns true if location is a valid mirror location (sysregistriesv2.Endpoint.Location of a mirror),
+// i.e. a host[:port][/path] value which, unlike a scope, is not a wildcard, and doesn't refer to a digest.
+func IsValidMirrorLocation(location string) bool {
+	return IsValidRegistriesConfScope(location) && !strings.ContainsAny(location, "*@")
+}
+
+// ScopeValidationError describes an invalid entry in a list of scopes validated by ValidateScopeList.
+type ScopeValidationError struct {
+	Index int    // The index of the invalid entry in the list
+	Scope string // The invalid entry
+}
+
+// Error returns a human-readable description of the error.
+func (e ScopeValidationError) Error() string {
+	return fmt.Sprintf("invalid scope %#v at index %d", e.Scope, e.Index)
+}
+
+// ValidateScopeList validates every entry of scopes using IsValidRegistriesConfScope, and returns an error for
+// each invalid entry, in order; the returned slice is empty if all entries are valid.
+// This can be used to validate the insecure and blocked scopes prior to calling EditRegistriesConfig,
+// reporting all errors at once.
+func ValidateScopeList(scopes []string) []ScopeValidationError {
+	res := []ScopeValidationError{}
+	for i, scope := range scopes {
+		if !IsValidRegistriesConfScope(scope) {
+			res = append(res, ScopeValidationError{Index: i, Scope: scope})
+		}
+	}
+	return res
+}
@@ -2,17 +2,26 @@ package registries
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/types"
+	"github.com/go-logr/logr/funcr"
 	apicfgv1 "github.com/openshift/api/config/v1"
 	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
 )
 
 func TestScopeIsNestedInsideScope(t *testing.T) {
@@ -43,6 +52,18 @@ func TestScopeIsNestedInsideScope(t *testing.T) {
 		{"foo.example.com:443/bar/baz", "*.example.com/bar/baz", false},
 		{"foo.example.com", "*example.com", false},
 		{"foo.example.com", "*/example.com", false},
+		{"[fd00::1]", "[fd00::1]", true},                   // IPv6 literal
+		{"[fd00::1]:5000", "[fd00::1]", false},             // Port mismatch
+		{"[fd00::1]:5000/ns", "[fd00::1]:5000", true},      // Valid namespace
+		{"[fd00::1]:5000/ns", "[fd00::1]", false},          // Port mismatch
+		{"[fd00::1]/ns", "[fd00::1]:5000", false},          // Port mismatch
+		{"[fd00::1]:5000/ns", "[fd00::1]:5000/ns2", false}, // Namespace mismatch
+		{"[fd00::1]:5000/ns", "*.example.com", false},      // Wildcards only match host names
+		{"Quay.io/ns1", "quay.io", true},                   // Host names are case-insensitive
+		{"quay.io:5000/ns1", "QUAY.IO:5000", true},         // Host names are case-insensitive
+		{"Foo.Example.com/NS", "*.example.COM", true},      // Host names are case-insensitive
+		{"quay.io/NS", "quay.io/ns", false},                // Paths are case-sensitive
+		{"Quay.io/ns", "quay.io/NS", false},                // Paths are case-sensitive
 	} {
 		t.Run(fmt.Sprintf("%#v, %#v", tt.subScope, tt.superScope), func(t *testing.T) {
 			res := ScopeIsNestedInsideScope(tt.subScope, tt.superScope)
@@ -51,6 +72,31 @@ func TestScopeIsNestedInsideScope(t *testing.T) {
 	}
 }
 
+func TestScopeEquals(t *testing.T) {
+	for _, tt := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"quay.io", "quay.io", true},
+		{"quay.io", "example.com", false},
+		{"quay.io/ns", "quay.io", false}, // Nested, not equal
+		{"quay.io", "quay.io/ns", false}, // Nested, not equal
+		{"quay.io:443", "quay.io", false},
+		{"Quay.IO/ns", "quay.io/ns", true},  // Host names are case-insensitive
+		{"quay.io/NS", "quay.io/ns", false}, // Paths are case-sensitive
+		{"quay.io/ns/", "quay.io/ns", true}, // Trailing slash
+		{"quay.io/", "Quay.io", true},       // Trailing slash
+		{"*.Example.com", "*.example.com", true},
+		{"foo.example.com", "*.example.com", false},
+		{"[FD00::1]:5000/ns", "[fd00::1]:5000/ns", true},
+	} {
+		t.Run(fmt.Sprintf("%#v, %#v", tt.a, tt.b), func(t *testing.T) {
+			assert.Equal(t, tt.expected, ScopeEquals(tt.a, tt.b))
+			assert.Equal(t, tt.expected, ScopeEquals(tt.b, tt.a))
+		})
+	}
+}
+
 func TestIsValidRegistriesConfScope(t *testing.T) {
 	for _, tt := range []struct {
 		scope    string
@@ -67,7 +113,17 @@ func TestIsValidRegistriesConfScope(t *testing.T) {
 		{"*example.com", false},
 		{"*/example.com", false},
 		{"*.*example.com", false},
-		{"", false}, // Invalid empty string entry
+		{"", false},                   // Invalid empty string entry
+		{"[fd00::1]", true},           // IPv6 literal
+		{"[fd00::1]:5000", true},      // IPv6 literal with a port
+		{"[fd00::1]:5000/ns", true},   // IPv6 literal with a port and a namespace
+		{"[fd00::1", false},           // Unterminated bracket
+		{"fd00::1]", false},           // Brackets only around the host name
+		{"[fd00::1]ns", false},        // Invalid text after the host name
+		{"[fd00::1]/[ns]", false},     // Brackets only around the host name
+		{"[example.com]", false},      // Brackets only around IPv6 literals
+		{"[192.168.0.1]:5000", false}, // Brackets only around IPv6 literals
+		{"*.[fd00::1]", false},        // Invalid wildcard entry
 	} {
 		t.Run(fmt.Sprintf("%#v", tt.scope), func(t *testing.T) {
 			res := IsValidRegistriesConfScope(tt.scope)
@@ -76,6 +132,29 @@ func TestIsValidRegistriesConfScope(t *testing.T) {
 	}
 }
 
+func TestIsValidMirrorLocation(t *testing.T) {
+	for _, tt := range []struct {
+		location string
+		expected bool
+	}{
+		{"example.com", true},
+		{"example.com:5000", true},
+		{"example.com:5000/ns/repo", true},
+		{"[fd00::1]:5000/ns", true},
+		{"", false},
+		{"*.example.com", false}, // Wildcards are only valid in scopes
+		{"*example.com", false},
+		{"example.com/*", false},
+		{"example.com/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", false},
+		{"[fd00::1", false},
+	} {
+		t.Run(fmt.Sprintf("%#v", tt.location), func(t *testing.T) {
+			res := IsValidMirrorLocation(tt.location)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
+
 func TestMirrorsContainsARealMirror(t *testing.T) {
 	const source = "source.example.com"
 
@@ -195,6 +274,17 @@ var mergedMirrorsetsTestcases = []struct {
 		},
 		result: []mergedMirrorSet{},
 	},
+	{
+		name: "Duplicate mirrors within a single source",
+		input: [][]mergedMirrorSet{
+			{
+				{source: "source.example.com", mirrors: []string{"z1.example.com", "y2.example.com", "z1.example.com", "x3.example.com", "y2.example.com"}},
+			},
+		},
+		result: []mergedMirrorSet{
+			{source: "source.example.com", mirrors: []string{"z1.example.com", "y2.example.com", "x3.example.com"}},
+		},
+	},
 	// More complex mirror set combinations are mostly tested in TestTopoGraph
 	{
 		name: "Example",
@@ -242,7 +332,7 @@ func TestMergedICSPMirrorSets(t *testing.T) {
 					},
 				})
 			}
-			res, err := mergedDigestMirrorSets(nil, in)
+			res, err := mergedDigestMirrorSets(nil, in, nil, false)
 			require.Nil(t, err)
 			assert.Equal(t, tc.result, res)
 		})
@@ -268,7 +358,7 @@ func TestMergedTagMirrorSets(t *testing.T) {
 					},
 				})
 			}
-			res, err := mergedTagMirrorSets(in)
+			res, err := mergedTagMirrorSets(in, false)
 			require.Nil(t, err)
 			assert.Equal(t, tc.result, res)
 		})
@@ -294,11 +384,23 @@ func TestMergedDigestMirrorSets(t *testing.T) {
 					},
 				})
 			}
-			res, err := mergedDigestMirrorSets(in, nil)
+			res, err := mergedDigestMirrorSets(in, nil, nil, false)
 			require.Nil(t, err)
 			assert.Equal(t, tc.result, res)
 		})
 	}
+
+	// Invalid mirror locations are rejected when merging
+	_, err := mergedDigestMirrorSets([]*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com", "*.mirror.registry-a.com"}},
+				},
+			},
+		},
+	}, nil, nil, false)
+	assert.EqualError(t, err, `invalid mirror "*.mirror.registry-a.com" of source "registry-a.com"`)
 }
 
 func TestMirrorsAdjustedForNestedScope(t *testing.T) {
@@ -307,7 +409,8 @@ func TestMirrorsAdjustedForNestedScope(t *testing.T) {
 		mirroredScope, subScope string
 	}{
 		{"mirrored.com", "unrelated.com"},
-		{"*.example.com", "*.nested.example.com"},
+		{"*.example.com", "*.unrelated.com"},
+		{"*.nested.example.com", "*.example.com"},
 	} {
 		_, err := mirrorsAdjustedForNestedScope(tt.mirroredScope, tt.subScope, []sysregistriesv2.Endpoint{})
 		assert.Error(t, err, fmt.Sprintf("%#v", tt))
@@ -326,25 +429,66 @@ func TestMirrorsAdjustedForNestedScope(t *testing.T) {
 		{Location: "mirror-2.com/nested/subscope"},
 		{Location: "example.com/subscope"},
 	}, res)
-}
 
-func TestEditRegistriesConfig(t *testing.T) {
-	templateConfig := sysregistriesv2.V2RegistriesConf{ // This matches templates/*/01-*-container-runtime/_base/files/container-registries.yaml
-		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+	// Wildcard scopes
+	for _, tt := range []struct {
+		mirroredScope, subScope string
+		expected                []sysregistriesv2.Endpoint
+	}{
+		{ // The mirrors replace only the matched host name, which is the same for both scopes
+			"*.example.com", "*.nested.example.com",
+			[]sysregistriesv2.Endpoint{{Location: "mirror-1.com"}, {Location: "mirror-2.com/nested"}},
+		},
+		{
+			"*.example.com", "foo.example.com",
+			[]sysregistriesv2.Endpoint{{Location: "mirror-1.com"}, {Location: "mirror-2.com/nested"}},
+		},
+		{
+			"*.example.com", "foo.example.com/ns/repo",
+			[]sysregistriesv2.Endpoint{{Location: "mirror-1.com/ns/repo"}, {Location: "mirror-2.com/nested/ns/repo"}},
+		},
+	} {
+		res, err := mirrorsAdjustedForNestedScope(tt.mirroredScope, tt.subScope,
+			[]sysregistriesv2.Endpoint{
+				{Location: "mirror-1.com"},
+				{Location: "mirror-2.com/nested"},
+			})
+		require.NoError(t, err, fmt.Sprintf("%#v", tt))
+		assert.Equal(t, tt.expected, res, fmt.Sprintf("%#v", tt))
 	}
-	buf := bytes.Buffer{}
-	err := toml.NewEncoder(&buf).Encode(templateConfig)
+
+	// A port is not a part of the matched host name, so it follows the mirror host name
+	res, err = mirrorsAdjustedForNestedScope("*.example.com", "foo.example.com:5000/ns",
+		[]sysregistriesv2.Endpoint{{Location: "mirror-1.com"}, {Location: "[2001:db8::1]"}})
 	require.NoError(t, err)
-	templateBytes := buf.Bytes()
+	assert.Equal(t, []sysregistriesv2.Endpoint{{Location: "mirror-1.com:5000/ns"}, {Location: "[2001:db8::1]:5000/ns"}}, res)
+	// This is not possible if the mirror location already contains a path or a port
+	for _, mirror := range []string{"mirror-2.com/nested", "mirror-3.com:6000", "[2001:db8::1]:6000"} {
+		_, err := mirrorsAdjustedForNestedScope("*.example.com", "foo.example.com:5000/ns",
+			[]sysregistriesv2.Endpoint{{Location: "mirror-1.com"}, {Location: mirror}})
+		assert.Error(t, err, mirror)
+	}
+}
 
-	tests := []struct {
-		name              string
-		insecure, blocked []string
-		idmsRules         []*apicfgv1.ImageDigestMirrorSet
-		itmsRules         []*apicfgv1.ImageTagMirrorSet
-		icspRules         []*apioperatorsv1alpha1.ImageContentSourcePolicy
-		want              sysregistriesv2.V2RegistriesConf
-	}{
+// editRegistriesConfigTestcase is a test case of TestEditRegistriesConfig.
+type editRegistriesConfigTestcase struct {
+	name              string
+	insecure, blocked []string
+	idmsRules         []*apicfgv1.ImageDigestMirrorSet
+	itmsRules         []*apicfgv1.ImageTagMirrorSet
+	icspRules         []*apioperatorsv1alpha1.ImageContentSourcePolicy
+	want              sysregistriesv2.V2RegistriesConf
+}
+
+// editRegistriesConfigTemplate is the configuration edited in TestEditRegistriesConfig.
+var editRegistriesConfigTemplate = sysregistriesv2.V2RegistriesConf{ // This matches templates/*/01-*-container-runtime/_base/files/container-registries.yaml
+	UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+}
+
+// editRegistriesConfigTestcases returns the test cases of TestEditRegistriesConfig, which edit editRegistriesConfigTemplate.
+func editRegistriesConfigTestcases() []editRegistriesConfigTestcase {
+	templateConfig := editRegistriesConfigTemplate
+	return []editRegistriesConfigTestcase{
 		{
 			name: "unchanged",
 			want: templateConfig,
@@ -718,6 +862,94 @@ func TestEditRegistriesConfig(t *testing.T) {
 			},
 		},
 
+		{
+			// A source is blocked if a mirror set of either kind uses NeverContactSource, regardless of the policy
+			// of mirror sets of the other kind, because registries.conf can only block the source as a whole.
+			name: "mirrorSourcePolicy of imageDigestMirrorSet and imageTagMirrorSet for the same source",
+			idmsRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+							{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-b.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+							{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-c.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+							{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-d.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+						},
+					},
+				},
+			},
+			itmsRules: []*apicfgv1.ImageTagMirrorSet{
+				{
+					Spec: apicfgv1.ImageTagMirrorSetSpec{
+						ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+							{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-a.com"}},
+							{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-b.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+							{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-c.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+							{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-d.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+							{Source: "registry-e.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-e.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						},
+					},
+				},
+			},
+			want: sysregistriesv2.V2RegistriesConf{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+				Registries: []sysregistriesv2.Registry{
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-a.com",
+						},
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror-digest.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+							{Location: "mirror-tag.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+						},
+					},
+
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-b.com",
+						},
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror-digest.registry-b.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+							{Location: "mirror-tag.registry-b.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+						},
+					},
+
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-c.com",
+						},
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror-digest.registry-c.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+							{Location: "mirror-tag.registry-c.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+						},
+					},
+
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-d.com",
+						},
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror-digest.registry-d.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+							{Location: "mirror-tag.registry-d.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+						},
+					},
+
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-e.com",
+						},
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror-tag.registry-e.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+						},
+					},
+				},
+			},
+		},
+
 		{
 			name:     "insecure+blocked scopes inside a configured mirror",
 			insecure: []string{"primary.com/top/insecure"},
@@ -788,6 +1020,86 @@ func TestEditRegistriesConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "generated mirror locations inside an insecure scope",
+			insecure: []string{"foo.insecure-example.com/deep/path"},
+			blocked:  []string{"primary.com/top/path"},
+			idmsRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "primary.com/top", Mirrors: []apicfgv1.ImageMirror{"foo.insecure-example.com/deep"}},
+						},
+					},
+				},
+			},
+			want: sysregistriesv2.V2RegistriesConf{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+				Registries: []sysregistriesv2.Registry{
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "primary.com/top",
+						},
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "foo.insecure-example.com/deep", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+						},
+					},
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "primary.com/top/path",
+						},
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "foo.insecure-example.com/deep/path", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+						},
+					},
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "foo.insecure-example.com/deep/path",
+							Insecure: true,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "blocked scopes inside a configured wildcard mirror",
+			blocked: []string{"*.example.com", "foo.staging.example.com/ns"},
+			idmsRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "*.staging.example.com", Mirrors: []apicfgv1.ImageMirror{"mirror.com/staging"}},
+						},
+					},
+				},
+			},
+			want: sysregistriesv2.V2RegistriesConf{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+				Registries: []sysregistriesv2.Registry{
+					{
+						Prefix:  "*.staging.example.com",
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror.com/staging", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+						},
+					},
+					{
+						Prefix:  "*.example.com",
+						Blocked: true,
+					},
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "foo.staging.example.com/ns",
+						},
+						Blocked: true,
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "mirror.com/staging/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+						},
+					},
+				},
+			},
+		},
 		{
 			name:     "insecure+blocked scopes inside a configured mirror in ImageContentSourcePolicy",
 			insecure: []string{"primary.com/top/insecure"},
@@ -986,6 +1298,89 @@ func TestEditRegistriesConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			// ImageContentSourcePolicy and ImageDigestMirrorSet objects are merged together, so the same source in both
+			// results in a single registry entry (registries.conf does not allow two entries with the same prefix).
+			name: "imageContentSourcePolicy and imageDigestMirrorSet with the same source",
+			icspRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+				{
+					Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+						RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+							{Source: "registry-a.com", Mirrors: []string{"z-icsp.registry-a.com", "shared.registry-a.com"}},
+						},
+					},
+				},
+			},
+			idmsRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"shared.registry-a.com", "a-idms.registry-a.com"}},
+						},
+					},
+				},
+			},
+			want: sysregistriesv2.V2RegistriesConf{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+				Registries: []sysregistriesv2.Registry{
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-a.com",
+						},
+						Mirrors: []sysregistriesv2.Endpoint{
+							{Location: "z-icsp.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+							{Location: "shared.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+							{Location: "a-idms.registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+						},
+					},
+				},
+			},
+		},
+		{
+			// Entries with an empty (not nil) mirror list are ignored, like in mergedMirrorSets, even with a mirrorSourcePolicy;
+			// registry entries are only created for their sources if they are independently insecure or blocked.
+			name:     "imageDigestMirrorSet and imageTagMirrorSet entries without mirrors",
+			insecure: []string{"registry-c.com"},
+			blocked:  []string{"registry-d.com"},
+			idmsRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{}},
+							{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+							{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{}},
+						},
+					},
+				},
+			},
+			itmsRules: []*apicfgv1.ImageTagMirrorSet{
+				{
+					Spec: apicfgv1.ImageTagMirrorSetSpec{
+						ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+							{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{}},
+							{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{}},
+						},
+					},
+				},
+			},
+			want: sysregistriesv2.V2RegistriesConf{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+				Registries: []sysregistriesv2.Registry{
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-d.com",
+						},
+						Blocked: true,
+					},
+					{
+						Endpoint: sysregistriesv2.Endpoint{
+							Location: "registry-c.com",
+							Insecure: true,
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "imageContentSourcePolicy+imageDigestMirrorSet+imageTagMirrorSet",
 			icspRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
@@ -1046,13 +1441,32 @@ func TestEditRegistriesConfig(t *testing.T) {
 			},
 		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Create config from templateBytes to get a fresh copy we can edit.
-			config := sysregistriesv2.V2RegistriesConf{}
-			_, err := toml.Decode(string(templateBytes), &config)
-			require.NoError(t, err)
-			err = EditRegistriesConfig(&config, tt.insecure, tt.blocked, tt.icspRules, tt.idmsRules, tt.itmsRules)
+}
+
+// findEditRegistriesConfigTestcase returns the editRegistriesConfigTestcases entry with the specified name.
+func findEditRegistriesConfigTestcase(t *testing.T, name string) editRegistriesConfigTestcase {
+	for _, tt := range editRegistriesConfigTestcases() {
+		if tt.name == name {
+			return tt
+		}
+	}
+	require.FailNow(t, "unknown test case", name)
+	return editRegistriesConfigTestcase{}
+}
+
+func TestEditRegistriesConfig(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := toml.NewEncoder(&buf).Encode(editRegistriesConfigTemplate)
+	require.NoError(t, err)
+	templateBytes := buf.Bytes()
+
+	for _, tt := range editRegistriesConfigTestcases() {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create config from templateBytes to get a fresh copy we can edit.
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := toml.Decode(string(templateBytes), &config)
+			require.NoError(t, err)
+			err = EditRegistriesConfig(&config, tt.insecure, tt.blocked, tt.icspRules, tt.idmsRules, tt.itmsRules)
 			if err != nil {
 				t.Errorf("updateRegistriesConfig() error = %v", err)
 				return
@@ -1076,3 +1490,3101 @@ func TestEditRegistriesConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestEditRegistriesConfigEmptyOptions(t *testing.T) {
+	withEntries := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"quay.io"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.registry-a.com")}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com", Insecure: true}},
+		},
+	}
+	withEntries.Aliases = map[string]string{"ubi": "registry.access.redhat.com/ubi9"}
+	templates := map[string]sysregistriesv2.V2RegistriesConf{
+		"editRegistriesConfigTemplate":        editRegistriesConfigTemplate,
+		"nil unqualified-search-registries":   {},
+		"empty unqualified-search-registries": {UnqualifiedSearchRegistries: []string{}},
+		"existing entries":                    withEntries,
+	}
+	optionsCases := map[string]EditOptions{
+		"zero value": {},
+		"empty slices": {
+			InsecureScopes:  []string{},
+			BlockedScopes:   []string{},
+			ICSPRules:       []*apioperatorsv1alpha1.ImageContentSourcePolicy{},
+			IDMSRules:       []*apicfgv1.ImageDigestMirrorSet{},
+			ITMSRules:       []*apicfgv1.ImageTagMirrorSet{},
+			ICPRules:        []*apicfgv1.ImageContentPolicy{},
+			DisabledMirrors: []string{},
+		},
+	}
+	for templateName, template := range templates {
+		expectedTOML, err := MarshalRegistriesConfTOML(&template)
+		require.NoError(t, err)
+		for optionsName, opts := range optionsCases {
+			t.Run(templateName+", "+optionsName, func(t *testing.T) {
+				config := CopyRegistriesConf(&template)
+				warnings, err := EditRegistriesConfigWithOptions(config, opts)
+				require.NoError(t, err)
+				assert.Empty(t, warnings)
+				assert.Equal(t, &template, config)
+				res, err := MarshalRegistriesConfTOML(config)
+				require.NoError(t, err)
+				assert.Equal(t, string(expectedTOML), string(res))
+
+				config = CopyRegistriesConf(&template)
+				err = EditRegistriesConfig(config, opts.InsecureScopes, opts.BlockedScopes, opts.ICSPRules, opts.IDMSRules, opts.ITMSRules)
+				require.NoError(t, err)
+				assert.Equal(t, &template, config)
+
+				planned, warnings, err := PlanRegistriesConfig(&template, opts)
+				require.NoError(t, err)
+				assert.Empty(t, warnings)
+				assert.Equal(t, &template, planned)
+				assert.True(t, IsEffectivelyDefault(planned, &template))
+			})
+		}
+	}
+}
+
+func TestEditRegistriesConfigWithChangedScopes(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "admin.example.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("existing.example.com/quay")}},
+		},
+	}
+	opts := EditOptions{
+		InsecureScopes: []string{"insecure.example.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/quay"}},
+						{Source: "registry.redhat.io", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/redhat"}},
+						{Source: "source-only.example.com", Mirrors: []apicfgv1.ImageMirror{"source-only.example.com"}},
+					},
+				},
+			},
+		},
+	}
+	expected := CopyRegistriesConf(&config)
+	expectedWarnings, err := EditRegistriesConfigWithOptions(expected, opts)
+	require.NoError(t, err)
+
+	warnings, changed, err := EditRegistriesConfigWithChangedScopes(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, expectedWarnings, warnings)
+	assert.Equal(t, expected, &config)
+	assert.Equal(t, []string{"insecure.example.com", "quay.io", "registry.redhat.io"}, changed)
+
+	// An edit without inputs changes nothing
+	_, changed, err = EditRegistriesConfigWithChangedScopes(CopyRegistriesConf(&config), EditOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, changed)
+
+	_, changed, err = EditRegistriesConfigWithChangedScopes(&config, EditOptions{BlockedScopes: []string{"*.example.com/ns"}})
+	assert.Error(t, err)
+	assert.Nil(t, changed)
+}
+
+func TestEditRegistriesConfigBatch(t *testing.T) {
+	templates := []*sysregistriesv2.V2RegistriesConf{
+		{UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"}},
+		{
+			UnqualifiedSearchRegistries: []string{"quay.io"},
+			Registries: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+					Mirrors:  []sysregistriesv2.Endpoint{{Location: "existing.registry-a.com"}},
+				},
+			},
+		},
+	}
+	for _, tc := range editRegistriesConfigTestcases() {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := EditOptions{
+				InsecureScopes: tc.insecure,
+				BlockedScopes:  tc.blocked,
+				ICSPRules:      tc.icspRules,
+				IDMSRules:      tc.idmsRules,
+				ITMSRules:      tc.itmsRules,
+			}
+			res, err := EditRegistriesConfigBatch(templates, opts)
+			require.NoError(t, err)
+			require.Len(t, res, len(templates))
+			for i, template := range templates {
+				expected := CopyRegistriesConf(template)
+				_, err := EditRegistriesConfigWithOptions(expected, opts)
+				require.NoError(t, err)
+				assert.Equal(t, expected, res[i])
+			}
+		})
+	}
+
+	opts := EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}},
+					},
+				},
+			},
+		},
+	}
+	// The results are independent of each other, and of the templates
+	res, err := EditRegistriesConfigBatch([]*sysregistriesv2.V2RegistriesConf{templates[1], templates[1]}, opts)
+	require.NoError(t, err)
+	res[0].Registries[0].Mirrors[0].Location = "modified.registry-a.com"
+	res[0].UnqualifiedSearchRegistries[0] = "modified.io"
+	assert.Equal(t, []sysregistriesv2.Endpoint{{Location: "existing.registry-a.com"}, NewDigestMirror("mirror.registry-a.com")}, res[1].Registries[0].Mirrors)
+	assert.Equal(t, []string{"quay.io"}, res[1].UnqualifiedSearchRegistries)
+	assert.Equal(t, []sysregistriesv2.Endpoint{{Location: "existing.registry-a.com"}}, templates[1].Registries[0].Mirrors)
+	assert.Equal(t, []string{"quay.io"}, templates[1].UnqualifiedSearchRegistries)
+
+	res, err = EditRegistriesConfigBatch(nil, opts)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	_, err = EditRegistriesConfigBatch(templates, EditOptions{InsecureScopes: []string{"*.example.com/ns"}})
+	assert.Error(t, err)
+}
+
+func TestPlanRegistriesConfig(t *testing.T) {
+	base := &sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"quay.io"},
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{{Location: "existing.registry-a.com"}},
+			},
+		},
+	}
+	original := CopyRegistriesConf(base)
+	observer := &recordingMergeObserver{}
+	opts := EditOptions{
+		BlockedScopes: []string{"blocked.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"registry-b.com"}}, // Only the source, ignored with a warning
+					},
+				},
+			},
+		},
+		Observer: observer,
+	}
+
+	res, warnings, err := PlanRegistriesConfig(base, opts)
+	require.NoError(t, err)
+	expected := CopyRegistriesConf(base)
+	expectedWarnings, err := EditRegistriesConfigWithOptions(expected, EditOptions{BlockedScopes: opts.BlockedScopes, IDMSRules: opts.IDMSRules})
+	require.NoError(t, err)
+	assert.Equal(t, expected, res)
+	assert.Equal(t, expectedWarnings, warnings)
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, original, base)
+	assert.Empty(t, observer.calls)
+	// The result is independent of base
+	res.Registries[0].Mirrors[0].Location = "modified.registry-a.com"
+	assert.Equal(t, original, base)
+
+	_, _, err = PlanRegistriesConfig(base, EditOptions{InsecureScopes: []string{"*.example.com/ns"}})
+	assert.Error(t, err)
+	assert.Equal(t, original, base)
+}
+
+// imageContentPolicyFromIDMS returns an ImageContentPolicy equivalent to idms, which must not use mirrorSourcePolicy or annotations.
+func imageContentPolicyFromIDMS(idms *apicfgv1.ImageDigestMirrorSet) *apicfgv1.ImageContentPolicy {
+	res := &apicfgv1.ImageContentPolicy{ObjectMeta: *idms.ObjectMeta.DeepCopy()}
+	for _, set := range idms.Spec.ImageDigestMirrors {
+		mirrors := []apicfgv1.Mirror{}
+		for _, m := range set.Mirrors {
+			mirrors = append(mirrors, apicfgv1.Mirror(m))
+		}
+		res.Spec.RepositoryDigestMirrors = append(res.Spec.RepositoryDigestMirrors, apicfgv1.RepositoryDigestMirrors{Source: set.Source, Mirrors: mirrors})
+	}
+	return res
+}
+
+func TestEditRegistriesConfigImageContentPolicy(t *testing.T) {
+	// ImageContentPolicy objects produce the same configuration as equivalent ImageDigestMirrorSet objects
+	tested := 0
+nextTestcase:
+	for _, tt := range editRegistriesConfigTestcases() {
+		if len(tt.idmsRules) == 0 {
+			continue
+		}
+		icpRules := []*apicfgv1.ImageContentPolicy{}
+		for _, idms := range tt.idmsRules {
+			if len(idms.Annotations) != 0 {
+				continue nextTestcase
+			}
+			for _, set := range idms.Spec.ImageDigestMirrors {
+				if set.MirrorSourcePolicy != "" {
+					continue nextTestcase
+				}
+			}
+			icpRules = append(icpRules, imageContentPolicyFromIDMS(idms))
+		}
+		tested++
+		t.Run(tt.name, func(t *testing.T) {
+			opts := EditOptions{
+				InsecureScopes: tt.insecure,
+				BlockedScopes:  tt.blocked,
+				ICSPRules:      tt.icspRules,
+				IDMSRules:      tt.idmsRules,
+				ITMSRules:      tt.itmsRules,
+			}
+			expected := editRegistriesConfigTemplate
+			_, err := EditRegistriesConfigWithOptions(&expected, opts)
+			require.NoError(t, err)
+
+			opts.IDMSRules, opts.ICPRules = nil, icpRules
+			config := editRegistriesConfigTemplate
+			_, err = EditRegistriesConfigWithOptions(&config, opts)
+			require.NoError(t, err)
+			assert.Equal(t, expected, config)
+		})
+	}
+	assert.NotZero(t, tested)
+
+	icp := &apicfgv1.ImageContentPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "icp"},
+		Spec: apicfgv1.ImageContentPolicySpec{
+			RepositoryDigestMirrors: []apicfgv1.RepositoryDigestMirrors{
+				{Source: "registry-a.com", Mirrors: []apicfgv1.Mirror{"mirror-1.registry-a.com"}},
+				{Source: "registry-b.com", Mirrors: []apicfgv1.Mirror{"mirror-1.registry-b.com", "mirror-2.registry-b.com"}, AllowMirrorByTags: true},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		ICPRules: []*apicfgv1.ImageContentPolicy{icp},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-0.registry-a.com", "mirror-1.registry-a.com"}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror-0.registry-a.com"), NewDigestMirror("mirror-1.registry-a.com")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror-1.registry-b.com", PullFromMirror: sysregistriesv2.MirrorAll},
+				{Location: "mirror-2.registry-b.com", PullFromMirror: sysregistriesv2.MirrorAll},
+			},
+		},
+	}, config.Registries)
+
+	// allowMirrorByTags can't be represented with DigestOnlyMirrors
+	_, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, EditOptions{ICPRules: []*apicfgv1.ImageContentPolicy{icp}, DigestOnlyMirrors: true})
+	assert.EqualError(t, err, `digest-only mirrors: ImageContentPolicy "icp": allowMirrorByTags is not allowed for source "registry-b.com"`)
+
+	// ImageContentPolicy objects are validated, and included in warnings
+	warnings, err := EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, EditOptions{
+		ICPRules: []*apicfgv1.ImageContentPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icp"},
+				Spec: apicfgv1.ImageContentPolicySpec{
+					RepositoryDigestMirrors: []apicfgv1.RepositoryDigestMirrors{{Source: "registry-a.com", Mirrors: []apicfgv1.Mirror{"registry-a.com"}}},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`ImageContentPolicy "icp": mirrors of "registry-a.com" contain only the source, ignoring`}, warnings)
+	_, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, EditOptions{
+		ICPRules: []*apicfgv1.ImageContentPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icp"},
+				Spec: apicfgv1.ImageContentPolicySpec{
+					RepositoryDigestMirrors: []apicfgv1.RepositoryDigestMirrors{{Source: "registry-a.com", Mirrors: []apicfgv1.Mirror{"*.example.com"}}},
+				},
+			},
+		},
+	})
+	assert.EqualError(t, err, `ImageContentPolicy "icp": invalid mirror "*.example.com" of source "registry-a.com"`)
+}
+
+func TestEditRegistriesConfigDigestAndTagMirrorOrder(t *testing.T) {
+	template := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{{Location: "existing.registry-a.com"}},
+			},
+		},
+	}
+	idmsRules := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"z-digest.registry-a.com", "y-digest.registry-a.com"}},
+				},
+			},
+		},
+	}
+	itmsRules := []*apicfgv1.ImageTagMirrorSet{
+		{
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"b-tag.registry-a.com", "a-tag.registry-a.com"}},
+				},
+			},
+		},
+	}
+	config := CopyRegistriesConf(&template)
+	err := EditRegistriesConfig(config, nil, nil, nil, idmsRules, itmsRules)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "existing.registry-a.com"},
+				NewDigestMirror("z-digest.registry-a.com"),
+				NewDigestMirror("y-digest.registry-a.com"),
+				NewTagMirror("b-tag.registry-a.com"),
+				NewTagMirror("a-tag.registry-a.com"),
+			},
+		},
+	}, config.Registries)
+
+	config = CopyRegistriesConf(&template)
+	_, err = EditRegistriesConfigWithOptions(config, EditOptions{IDMSRules: idmsRules, ITMSRules: itmsRules, TagMirrorsFirst: true})
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "existing.registry-a.com"}, // Existing mirrors are still first
+				NewTagMirror("b-tag.registry-a.com"),
+				NewTagMirror("a-tag.registry-a.com"),
+				NewDigestMirror("z-digest.registry-a.com"),
+				NewDigestMirror("y-digest.registry-a.com"),
+			},
+		},
+	}, config.Registries)
+}
+
+// TestEditRegistriesConfigInsecureSourceAndMirrors verifies that insecure scopes apply to a source and to its mirrors
+// independently, each based on its own location.
+func TestEditRegistriesConfigInsecureSourceAndMirrors(t *testing.T) {
+	idms := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "source.example.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror-1.example.net/ns", "mirror-2.example.org/ns"}},
+				},
+			},
+		},
+	}
+	for _, tt := range []struct {
+		name             string
+		insecure         []string
+		source           bool
+		mirror1, mirror2 bool
+	}{
+		{"none", nil, false, false, false},
+		{"source", []string{"source.example.com/ns"}, true, false, false},
+		{"source registry", []string{"source.example.com"}, true, false, false},
+		{"source wildcard", []string{"*.example.com"}, true, false, false},
+		{"nested in source", []string{"source.example.com/ns/repo"}, false, false, false},
+		{"one mirror", []string{"mirror-1.example.net"}, false, true, false},
+		{"mirror namespace", []string{"mirror-2.example.org/ns"}, false, false, true},
+		{"nested in mirror", []string{"mirror-2.example.org/ns/repo"}, false, false, false},
+		{"other namespace on mirror", []string{"mirror-1.example.net/other"}, false, false, false},
+		{"source and one mirror", []string{"source.example.com", "*.example.org"}, true, false, true},
+		{"mirrors only", []string{"*.example.net", "mirror-2.example.org"}, false, true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{}
+			err := EditRegistriesConfig(&config, tt.insecure, nil, nil, idms, nil)
+			require.NoError(t, err)
+			var reg *sysregistriesv2.Registry
+			for i := range config.Registries {
+				if config.Registries[i].Location == "source.example.com/ns" {
+					reg = &config.Registries[i]
+				}
+			}
+			require.NotNil(t, reg)
+			assert.Equal(t, tt.source, reg.Insecure, "source")
+			require.Len(t, reg.Mirrors, 2)
+			assert.Equal(t, tt.mirror1, reg.Mirrors[0].Insecure, "mirror-1")
+			assert.Equal(t, tt.mirror2, reg.Mirrors[1].Insecure, "mirror-2")
+		})
+	}
+}
+
+func TestEditRegistriesConfigAllMirrorsInsecure(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		InsecureScopes: []string{"insecure.com"},
+		BlockedScopes:  []string{"registry-a.com/blocked"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-a.com"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-a.com"}},
+					},
+				},
+			},
+		},
+		AllMirrorsInsecure: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror-digest.registry-a.com", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror-tag.registry-a.com", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com/blocked"},
+			Blocked:  true,
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror-digest.registry-a.com/blocked", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror-tag.registry-a.com/blocked", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure.com", Insecure: true},
+		},
+	}, config.Registries)
+}
+
+func TestEditRegistriesConfigDropRedundantBlockedEntries(t *testing.T) {
+	opts := EditOptions{
+		InsecureScopes: []string{"*.insecure.example.com", "insecure.example.net"},
+		BlockedScopes:  []string{"*.example.com", "foo.example.com", "foo.example.com/ns", "a.insecure.example.com", "example.net", "insecure.example.net"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "mirrored.example.com", Mirrors: []apicfgv1.ImageMirror{"mirror.com/mirrored"}},
+					},
+				},
+			},
+		},
+		DropRedundantBlockedEntries: true,
+	}
+	config := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{ // An entry with other configuration is not dropped
+				Endpoint:           sysregistriesv2.Endpoint{Location: "digest-only.example.com"},
+				MirrorByDigestOnly: true,
+			},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint:           sysregistriesv2.Endpoint{Location: "digest-only.example.com"},
+			Blocked:            true,
+			MirrorByDigestOnly: true,
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "mirrored.example.com"},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/mirrored")},
+		},
+		{
+			Prefix:  "*.example.com",
+			Blocked: true,
+		},
+		// foo.example.com and foo.example.com/ns are covered by *.example.com,
+		// a.insecure.example.com is covered by *.insecure.example.com.
+		{ // Not nested inside a wildcard
+			Endpoint: sysregistriesv2.Endpoint{Location: "example.net"},
+			Blocked:  true,
+		},
+		{ // Not nested inside a wildcard
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.net", Insecure: true},
+			Blocked:  true,
+		},
+		{ // A different insecure flag than *.example.com
+			Prefix:   "*.insecure.example.com",
+			Endpoint: sysregistriesv2.Endpoint{Insecure: true},
+			Blocked:  true,
+		},
+	}, config.Registries)
+
+	// Without the option, all entries are kept
+	config = sysregistriesv2.V2RegistriesConf{}
+	opts.DropRedundantBlockedEntries = false
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Len(t, config.Registries, 8)
+}
+
+func TestEditRegistriesConfigInvalidScopes(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		insecure, blocked []string
+		expectedError     string
+	}{
+		{"insecure wildcard with a path", []string{"insecure.com", "*.example.com/foo/bar"}, nil, `insecure scopes: invalid scope "*.example.com/foo/bar" at index 1`},
+		{"blocked wildcard with a path", nil, []string{"*.example.com/foo"}, `blocked scopes: invalid scope "*.example.com/foo" at index 0`},
+		{"empty blocked scope", []string{"insecure.com"}, []string{""}, `blocked scopes: invalid scope "" at index 0`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{}
+			err := EditRegistriesConfig(&config, tt.insecure, tt.blocked, nil, nil, nil)
+			assert.EqualError(t, err, tt.expectedError)
+			assert.Empty(t, config.Registries)
+		})
+	}
+}
+
+func TestEditRegistriesConfigNestedScopePortAfterMirrorPath(t *testing.T) {
+	idms := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "*.a.com", Mirrors: []apicfgv1.ImageMirror{"b.com/c"}},
+				},
+			},
+		},
+	}
+	for _, tt := range []struct {
+		name     string
+		template []sysregistriesv2.Registry
+		opts     EditOptions
+	}{
+		{
+			name: "blocked scope",
+			opts: EditOptions{BlockedScopes: []string{"x.a.com:5000"}, IDMSRules: idms},
+		},
+		{
+			name: "insecure scope",
+			opts: EditOptions{InsecureScopes: []string{"x.a.com:5000/ns"}, IDMSRules: idms},
+		},
+		{
+			name:     "template entry",
+			template: []sysregistriesv2.Registry{{Endpoint: sysregistriesv2.Endpoint{Location: "y.a.com:5000"}}},
+			opts:     EditOptions{IDMSRules: idms},
+		},
+		{
+			name: "inherited by a nested source",
+			opts: EditOptions{
+				IDMSRules: append([]*apicfgv1.ImageDigestMirrorSet{
+					{
+						Spec: apicfgv1.ImageDigestMirrorSetSpec{
+							ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+								{Source: "x.a.com:5000", Mirrors: []apicfgv1.ImageMirror{"mirror.com"}},
+							},
+						},
+					},
+				}, idms...),
+				InheritNestedScopeMirrors: true,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{
+				UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+				Registries: append([]sysregistriesv2.Registry{
+					{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}, Mirrors: []sysregistriesv2.Endpoint{{Location: "mirror.com/quay"}}},
+				}, tt.template...),
+			}
+			original := CopyRegistriesConf(&config)
+			_, err := EditRegistriesConfigWithOptions(&config, tt.opts)
+			assert.ErrorContains(t, err, `mirror "b.com/c" of "*.a.com" can't be used for`)
+			assert.Equal(t, original, &config)
+		})
+	}
+
+	// The error is reported before the edit in EditRegistriesConfigFromPolicy as well.
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfigFromPolicy(&config, &RegistryPolicy{
+		BlockedScopes:               []string{"x.a.com:5000"},
+		IDMSRules:                   idms,
+		UnqualifiedSearchRegistries: []string{"quay.io"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, sysregistriesv2.V2RegistriesConf{}, config)
+
+	// A mirror which is a host name can be used.
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, EditOptions{
+		BlockedScopes: []string{"x.a.com:5000"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "*.a.com", Mirrors: []apicfgv1.ImageMirror{"b.com"}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Endpoint{NewDigestMirror("b.com:5000")}, config.Registries[1].Mirrors)
+}
+
+func TestEditRegistriesConfigFromImage(t *testing.T) {
+	imageDigestMirrorSets := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.insecure.com/registry-a"}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		sources apicfgv1.RegistrySources
+		want    []sysregistriesv2.Registry
+	}{
+		{
+			name: "empty",
+			want: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+					Mirrors: []sysregistriesv2.Endpoint{
+						{Location: "mirror.insecure.com/registry-a", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+					},
+				},
+			},
+		},
+		{
+			name: "insecure+blocked",
+			sources: apicfgv1.RegistrySources{
+				InsecureRegistries: []string{"mirror.insecure.com"},
+				BlockedRegistries:  []string{"registry-a.com"},
+			},
+			want: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+					Blocked:  true,
+					Mirrors: []sysregistriesv2.Endpoint{
+						{Location: "mirror.insecure.com/registry-a", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+					},
+				},
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "mirror.insecure.com", Insecure: true},
+				},
+			},
+		},
+		{
+			name: "allowed is not represented in registries.conf",
+			sources: apicfgv1.RegistrySources{
+				AllowedRegistries: []string{"registry-a.com"},
+			},
+			want: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+					Mirrors: []sysregistriesv2.Endpoint{
+						{Location: "mirror.insecure.com/registry-a", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{}
+			img := &apicfgv1.Image{Spec: apicfgv1.ImageSpec{RegistrySources: tt.sources}}
+			err := EditRegistriesConfigFromImage(&config, img, nil, imageDigestMirrorSets, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.Registries)
+		})
+	}
+
+	// allowedRegistries and blockedRegistries are mutually exclusive
+	config := sysregistriesv2.V2RegistriesConf{}
+	img := &apicfgv1.Image{Spec: apicfgv1.ImageSpec{RegistrySources: apicfgv1.RegistrySources{
+		AllowedRegistries: []string{"allowed.com"},
+		BlockedRegistries: []string{"blocked.com"},
+	}}}
+	err := EditRegistriesConfigFromImage(&config, img, nil, imageDigestMirrorSets, nil)
+	assert.Error(t, err)
+
+	// A nil Image is accepted
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfigFromImage(&config, nil, nil, imageDigestMirrorSets, nil)
+	require.NoError(t, err)
+	assert.Len(t, config.Registries, 1)
+}
+
+func TestRegistrySourcesFromScopes(t *testing.T) {
+	insecure := []string{"insecure.example.com", "*.insecure.example.net"}
+	blocked := []string{"blocked.example.com/ns"}
+	res, err := RegistrySourcesFromScopes(insecure, blocked)
+	require.NoError(t, err)
+	assert.Equal(t, apicfgv1.RegistrySources{
+		InsecureRegistries: []string{"insecure.example.com", "*.insecure.example.net"},
+		BlockedRegistries:  []string{"blocked.example.com/ns"},
+	}, res)
+	res.InsecureRegistries[0] = "modified.example.com" // The inputs are not shared
+	assert.Equal(t, "insecure.example.com", insecure[0])
+
+	res, err = RegistrySourcesFromScopes(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, apicfgv1.RegistrySources{}, res)
+
+	// The result is accepted by EditRegistriesConfigFromImage, with the same effect as the scopes
+	res, err = RegistrySourcesFromScopes(insecure, blocked)
+	require.NoError(t, err)
+	fromImage := sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfigFromImage(&fromImage, &apicfgv1.Image{Spec: apicfgv1.ImageSpec{RegistrySources: res}}, nil, nil, nil)
+	require.NoError(t, err)
+	fromScopes := sysregistriesv2.V2RegistriesConf{}
+	require.NoError(t, EditRegistriesConfig(&fromScopes, insecure, blocked, nil, nil, nil))
+	assert.Equal(t, fromScopes, fromImage)
+
+	_, err = RegistrySourcesFromScopes([]string{"insecure.example.com", "*.example.com/ns"}, nil)
+	assert.EqualError(t, err, `insecure scopes: invalid scope "*.example.com/ns" at index 1`)
+	_, err = RegistrySourcesFromScopes(nil, []string{""})
+	assert.EqualError(t, err, `blocked scopes: invalid scope "" at index 0`)
+}
+func TestWithoutDefaultPort(t *testing.T) {
+	for _, tt := range []struct {
+		scope    string
+		insecure bool
+		expected string
+	}{
+		{"quay.io", false, "quay.io"},
+		{"quay.io:443", false, "quay.io"},
+		{"quay.io:443/ns/repo", false, "quay.io/ns/repo"},
+		{"quay.io:80", false, "quay.io:80"},
+		{"quay.io:80", true, "quay.io"},
+		{"quay.io:80/ns", true, "quay.io/ns"},
+		{"quay.io:443", true, "quay.io"},
+		{"quay.io:5000", true, "quay.io:5000"},
+		{"quay.io/ns:443", false, "quay.io/ns:443"}, // Not a port
+		{"*.example.com", false, "*.example.com"},
+		{"quay.io:8443", false, "quay.io:8443"},
+		{"[fd00::1]:443/ns", false, "[fd00::1]/ns"},
+		{"[fd00::443]", false, "[fd00::443]"}, // Part of the IPv6 literal, not a port
+		{"[fd00::80]:80", true, "[fd00::80]"},
+	} {
+		t.Run(fmt.Sprintf("%#v, %v", tt.scope, tt.insecure), func(t *testing.T) {
+			res := withoutDefaultPort(tt.scope, tt.insecure)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
+
+func TestEditRegistriesConfigTreatDefaultPortsAsEqual(t *testing.T) {
+	idmsRules := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "quay.io:443/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com:443/ns"}},
+					{Source: "quay.io/ns", Mirrors: []apicfgv1.ImageMirror{"insecure.example.com:80/ns"}},
+				},
+			},
+		},
+	}
+	opts := EditOptions{
+		InsecureScopes: []string{"insecure.example.com"},
+		BlockedScopes:  []string{"quay.io:443/ns/blocked"},
+		IDMSRules:      idmsRules,
+	}
+
+	// The default: scopes with an explicit default port are distinct
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "insecure.example.com:80/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io:443/ns"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.example.com:443/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io:443/ns/blocked"},
+			Blocked:  true,
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.example.com:443/ns/blocked", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.com", Insecure: true},
+		},
+	}, config.Registries)
+
+	opts.TreatDefaultPortsAsEqual = true
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "insecure.example.com/ns", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror.example.com/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns/blocked"},
+			Blocked:  true,
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "insecure.example.com/ns/blocked", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror.example.com/ns/blocked", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.com", Insecure: true},
+		},
+	}, config.Registries)
+	// The input objects are not modified
+	assert.Equal(t, "quay.io:443/ns", idmsRules[0].Spec.ImageDigestMirrors[0].Source)
+
+	// Rules of all kinds are normalized
+	normalized := EditOptions{
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "quay.io:443/ns", Mirrors: []string{"mirror.example.com:443/ns"}},
+					},
+				},
+			},
+		},
+		ICPRules: []*apicfgv1.ImageContentPolicy{
+			{
+				Spec: apicfgv1.ImageContentPolicySpec{
+					RepositoryDigestMirrors: []apicfgv1.RepositoryDigestMirrors{
+						{Source: "quay.io:443/ns", Mirrors: []apicfgv1.Mirror{"mirror.example.com:443/ns"}},
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "quay.io:443/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com:443/ns"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "quay.io:443/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com:443/ns"}},
+					},
+				},
+			},
+		},
+	}.withDefaultPortsRemoved()
+	kinds := []string{}
+	forEachMirrorSet(normalized, func(kind, name, source string, _ apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		assert.Equal(t, "quay.io/ns", source, kind)
+		assert.Equal(t, []apicfgv1.ImageMirror{"mirror.example.com/ns"}, mirrors, kind)
+		kinds = append(kinds, kind)
+	})
+	assert.Equal(t, []string{"ImageContentSourcePolicy", "ImageContentPolicy", "ImageDigestMirrorSet", "ImageTagMirrorSet"}, kinds)
+}
+
+func TestPullFromMirrorOverrides(t *testing.T) {
+	res, err := pullFromMirrorOverrides(nil)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = pullFromMirrorOverrides(map[string]string{PullFromMirrorAnnotation: "a.example.com/ns=all, b.example.com=tag-only,a.example.com/ns=all"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"a.example.com/ns": sysregistriesv2.MirrorAll,
+		"b.example.com":    sysregistriesv2.MirrorByTagOnly,
+	}, res)
+
+	for _, value := range []string{
+		"",
+		"a.example.com",
+		"=all",
+		"a.example.com=",
+		"a.example.com=both",
+		"a.example.com=all,a.example.com=tag-only", // Conflicting overrides
+	} {
+		_, err := pullFromMirrorOverrides(map[string]string{PullFromMirrorAnnotation: value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestEditRegistriesConfigPullFromMirrorAnnotation(t *testing.T) {
+	idmsRules := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "idms",
+				Annotations: map[string]string{PullFromMirrorAnnotation: "mirror-all.example.com=all"},
+			},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-all.example.com", "mirror-digest.example.com"}},
+				},
+			},
+		},
+	}
+	itmsRules := []*apicfgv1.ImageTagMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "itms",
+				Annotations: map[string]string{PullFromMirrorAnnotation: "mirror-tag.example.com=digest-only"},
+			},
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.example.com"}},
+				},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, nil, nil, nil, idmsRules, itmsRules)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror-all.example.com", PullFromMirror: sysregistriesv2.MirrorAll},
+				{Location: "mirror-digest.example.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror-tag.example.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+	}, config.Registries)
+
+	// Invalid annotation values are rejected
+	idmsRules[0].Annotations[PullFromMirrorAnnotation] = "mirror-all.example.com=invalid"
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, nil, nil, idmsRules, nil)
+	assert.Error(t, err)
+
+	// Conflicting overrides for the same mirror of a source, from different objects, are rejected
+	idmsRules[0].Annotations[PullFromMirrorAnnotation] = "mirror-all.example.com=all"
+	idmsRules = append(idmsRules, &apicfgv1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "idms-2",
+			Annotations: map[string]string{PullFromMirrorAnnotation: "mirror-all.example.com=tag-only"},
+		},
+		Spec: apicfgv1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+				{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-all.example.com"}},
+			},
+		},
+	})
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, nil, nil, idmsRules, nil)
+	assert.Error(t, err)
+}
+
+func TestMirrorPriorities(t *testing.T) {
+	res, err := mirrorPriorities(nil)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = mirrorPriorities(map[string]string{MirrorPriorityAnnotation: "a.example.com/ns=10, b.example.com=-5,a.example.com/ns=10"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a.example.com/ns": 10, "b.example.com": -5}, res)
+
+	for _, value := range []string{
+		"",
+		"a.example.com",
+		"=1",
+		"a.example.com=",
+		"a.example.com=high",
+		"a.example.com=1.5",
+		"a.example.com=1,a.example.com=2", // Conflicting priorities
+	} {
+		_, err := mirrorPriorities(map[string]string{MirrorPriorityAnnotation: value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestEditRegistriesConfigMirrorPriorityAnnotation(t *testing.T) {
+	idmsRules := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "idms",
+				Annotations: map[string]string{MirrorPriorityAnnotation: "slow.example.com=-1,fast.example.com=10,fast-2.example.com=10"},
+			},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"slow.example.com", "default.example.com", "fast.example.com", "fast-2.example.com", "registry-a.com"}},
+					{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"slow.example.com", "other.example.com"}}, // The annotation applies to all sources
+				},
+			},
+		},
+	}
+	itmsRules := []*apicfgv1.ImageTagMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "itms",
+				Annotations: map[string]string{MirrorPriorityAnnotation: "tag-2.example.com=1"},
+			},
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"tag-1.example.com", "tag-2.example.com"}},
+				},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, nil, nil, nil, idmsRules, itmsRules)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				NewDigestMirror("fast.example.com"),
+				NewDigestMirror("fast-2.example.com"),
+				NewDigestMirror("default.example.com"),
+				NewDigestMirror("slow.example.com"),
+				// Priorities don't move tag-only mirrors before digest-only ones
+				NewTagMirror("tag-2.example.com"),
+				NewTagMirror("tag-1.example.com"),
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("other.example.com"), NewDigestMirror("slow.example.com")},
+		},
+	}, config.Registries)
+
+	// Invalid annotation values are rejected
+	idmsRules[0].Annotations[MirrorPriorityAnnotation] = "fast.example.com=high"
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, nil, nil, idmsRules, nil)
+	assert.EqualError(t, err, `ImageDigestMirrorSet "idms": invalid runtime-utils.openshift.io/mirror-priority value "high" for mirror "fast.example.com"`)
+
+	// Conflicting priorities for the same mirror of a source, from different objects, are rejected
+	idmsRules[0].Annotations[MirrorPriorityAnnotation] = "fast.example.com=10"
+	idmsRules = append(idmsRules, &apicfgv1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "idms-2",
+			Annotations: map[string]string{MirrorPriorityAnnotation: "fast.example.com=5"},
+		},
+		Spec: apicfgv1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+				{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"fast.example.com"}},
+			},
+		},
+	})
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, nil, nil, idmsRules, nil)
+	assert.EqualError(t, err, `conflicting runtime-utils.openshift.io/mirror-priority values 10 and 5 for mirror "fast.example.com" of "registry-a.com"`)
+}
+
+func TestEditRegistriesConfigSourceOnlyMirrorsWarnings(t *testing.T) {
+	opts := EditOptions{
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []string{"registry-a.com", "registry-a.com"}},
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"registry-b.com"}},
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-c.com"}},
+						{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{}}, // No mirrors at all is not reported
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-e.com", Mirrors: []apicfgv1.ImageMirror{"registry-e.com"}},
+					},
+				},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ImageContentSourcePolicy "icsp": mirrors of "registry-a.com" contain only the source, ignoring`,
+		`ImageDigestMirrorSet "idms": mirrors of "registry-b.com" contain only the source, ignoring`,
+		`ImageTagMirrorSet "itms": mirrors of "registry-e.com" contain only the source, ignoring`,
+	}, warnings)
+	// The generated configuration still ignores the source-only entries
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.registry-c.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+	}, config.Registries)
+
+	warnings, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, EditOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestEditRegistriesConfigInsecureNeverContactedSourceWarnings(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		InsecureScopes: []string{"registry-a.com", "*.example.com", "registry-c.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						{Source: "registry.example.com", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource}, // The scope also applies to the mirror
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-c.com"}},                                                     // The source can be contacted
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ImageDigestMirrorSet "idms": source "registry-a.com/ns" uses mirrorSourcePolicy NeverContactSource, so insecure scope "registry-a.com" has no effect on it`,
+		`ImageTagMirrorSet "itms": source "registry-a.com" uses mirrorSourcePolicy NeverContactSource, so insecure scope "registry-a.com" has no effect on it`,
+	}, warnings)
+	// The configuration is generated as before
+	reg, _ := findGoverningRegistry(&config, "registry-a.com", nil, -1)
+	require.NotNil(t, reg)
+	assert.True(t, reg.Blocked)
+	assert.True(t, reg.Insecure)
+}
+
+func TestEditRegistriesConfigSameHostMirrorsWarnings(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "quay.io/prod", Mirrors: []string{"Quay.io/prod-mirror"}}, // Host names are case-insensitive
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "quay.io/prod", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/prod", "quay.io/prod-mirror", "quay.io/prod"}},
+						{Source: "registry-a.com:5000/ns", Mirrors: []apicfgv1.ImageMirror{"registry-a.com/ns", "registry-a.com:5001/ns"}}, // Different ports
+						{Source: "*.example.com", Mirrors: []apicfgv1.ImageMirror{"foo.example.com/mirror"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com:5000/ns", Mirrors: []apicfgv1.ImageMirror{"registry-a.com:5000/ns-mirror"}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ImageContentSourcePolicy "icsp": mirror "Quay.io/prod-mirror" of "quay.io/prod" is on the same registry host as the source`,
+		`ImageDigestMirrorSet "idms": mirror "quay.io/prod-mirror" of "quay.io/prod" is on the same registry host as the source`,
+		`ImageTagMirrorSet "itms": mirror "registry-a.com:5000/ns-mirror" of "registry-a.com:5000/ns" is on the same registry host as the source`,
+	}, warnings)
+	// The configuration is generated as before
+	reg, _ := findGoverningRegistry(&config, "quay.io/prod", nil, -1)
+	require.NotNil(t, reg)
+	assert.Len(t, reg.Mirrors, 3)
+}
+
+func TestEditRegistriesConfigBlockedMirrorsWarnings(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		BlockedScopes: []string{"*.example.com", "registry-b.com/blocked", "registry-c.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ns", "registry-b.com/blocked/ns", "registry-b.com/ok/ns"}},
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror.example.net/c", "registry-c.com"}}, // The source itself may be blocked
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com/ns", Mirrors: []apicfgv1.ImageMirror{"Mirror.Example.com/ns"}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ImageDigestMirrorSet "idms": mirror "mirror.example.com/ns" of "registry-a.com/ns" is inside blocked scope "*.example.com"`,
+		`ImageDigestMirrorSet "idms": mirror "registry-b.com/blocked/ns" of "registry-a.com/ns" is inside blocked scope "registry-b.com/blocked"`,
+		`ImageTagMirrorSet "itms": mirror "Mirror.Example.com/ns" of "registry-a.com/ns" is inside blocked scope "*.example.com"`,
+	}, warnings)
+	// The configuration is generated as before
+	reg, _ := findGoverningRegistry(&config, "registry-a.com/ns", nil, -1)
+	require.NotNil(t, reg)
+	assert.Len(t, reg.Mirrors, 4)
+}
+
+func TestEditRegistriesConfigMixedSourcePolicyWarnings(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []string{"mirror-icsp.registry-b.com"}},
+					},
+				},
+			},
+		},
+		ICPRules: []*apicfgv1.ImageContentPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icp"},
+				Spec: apicfgv1.ImageContentPolicySpec{
+					RepositoryDigestMirrors: []apicfgv1.RepositoryDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []apicfgv1.Mirror{"mirror-icp.registry-b.com"}},
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-c.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{"registry-d.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource}, // Ignored
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"tag-mirror.registry-a.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"tag-mirror.registry-b.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"tag-mirror.registry-c.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource}, // Consistent
+						{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{"tag-mirror.registry-d.com"}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ImageDigestMirrorSet "idms": mirrors of "registry-d.com" contain only the source, ignoring`,
+		`ImageContentSourcePolicy "icsp": source "registry-b.com" is never contacted, because ImageTagMirrorSet "itms" uses mirrorSourcePolicy NeverContactSource for it; pulls by digest only use the mirrors`,
+		`ImageContentPolicy "icp": source "registry-b.com" is never contacted, because ImageTagMirrorSet "itms" uses mirrorSourcePolicy NeverContactSource for it; pulls by digest only use the mirrors`,
+		`ImageDigestMirrorSet "idms": source "registry-b.com" is never contacted, because ImageTagMirrorSet "itms" uses mirrorSourcePolicy NeverContactSource for it; pulls by digest only use the mirrors`,
+		`ImageTagMirrorSet "itms": source "registry-a.com" is never contacted, because ImageDigestMirrorSet "idms" uses mirrorSourcePolicy NeverContactSource for it; pulls by tag only use the mirrors`,
+	}, warnings)
+	// The configuration is generated as before
+	for _, scope := range []string{"registry-a.com", "registry-b.com", "registry-c.com"} {
+		reg, _ := findGoverningRegistry(&config, scope, nil, -1)
+		require.NotNil(t, reg, scope)
+		assert.True(t, reg.Blocked, scope)
+	}
+}
+
+func TestEditRegistriesConfigKeepSourceOnlyMirrors(t *testing.T) {
+	opts := EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"registry-a.com", "registry-a.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"registry-b.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}}, // Real mirrors take precedence
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{}},                        // No mirrors at all is still ignored
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{"registry-d.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+					},
+				},
+			},
+		},
+		KeepSourceOnlyMirrors: true,
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "registry-a.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.registry-b.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-d.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "registry-d.com", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+			},
+		},
+	}, config.Registries)
+}
+
+func TestEditRegistriesConfigSourceMirrorsLast(t *testing.T) {
+	// The inputs of mergedMirrorsetsTestcases which list the source among the mirrors
+	input := func(name string) []*apicfgv1.ImageDigestMirrorSet {
+		for _, tc := range mergedMirrorsetsTestcases {
+			if tc.name != name {
+				continue
+			}
+			res := []*apicfgv1.ImageDigestMirrorSet{}
+			for _, items := range tc.input {
+				idm := []apicfgv1.ImageDigestMirrors{}
+				for _, item := range items {
+					imgMirrors := []apicfgv1.ImageMirror{}
+					for _, m := range item.mirrors {
+						imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
+					}
+					idm = append(idm, apicfgv1.ImageDigestMirrors{Source: item.source, Mirrors: imgMirrors})
+				}
+				res = append(res, &apicfgv1.ImageDigestMirrorSet{Spec: apicfgv1.ImageDigestMirrorSetSpec{ImageDigestMirrors: idm}})
+			}
+			return res
+		}
+		require.FailNow(t, "unknown test case", name)
+		return nil
+	}
+
+	for _, tt := range []struct {
+		name                  string
+		input                 string
+		keepSourceOnlyMirrors bool
+		sourceMirrorsLast     bool
+		expected              []sysregistriesv2.Registry
+	}{
+		{
+			name:  "default",
+			input: "Source included in mirrors",
+			expected: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "source.example.com"},
+					Mirrors: []sysregistriesv2.Endpoint{
+						NewDigestMirror("z1.example.com"), NewDigestMirror("source.example.com"), NewDigestMirror("y2.example.com"), NewDigestMirror("x3.example.com"),
+					},
+				},
+			},
+		},
+		{
+			name:              "source mirrors last",
+			input:             "Source included in mirrors",
+			sourceMirrorsLast: true,
+			expected: []sysregistriesv2.Registry{
+				{
+					Endpoint: sysregistriesv2.Endpoint{Location: "source.example.com"},
+					Mirrors: []sysregistriesv2.Endpoint{
+						NewDigestMirror("z1.example.com"), NewDigestMirror("y2.example.com"), NewDigestMirror("x3.example.com"), NewDigestMirror("source.example.com"),
+					},
+				},
+			},
+		},
+		{
+			name:              "only the source",
+			input:             "Mirrors includes only source",
+			sourceMirrorsLast: true,
+			expected:          nil,
+		},
+		{
+			name:                  "only the source, kept",
+			input:                 "Mirrors includes only source",
+			keepSourceOnlyMirrors: true,
+			sourceMirrorsLast:     true,
+			expected: []sysregistriesv2.Registry{
+				{Endpoint: sysregistriesv2.Endpoint{Location: "source.example.com"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("source.example.com")}},
+				{Endpoint: sysregistriesv2.Endpoint{Location: "source.example.net"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("source.example.net")}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+				IDMSRules:             input(tt.input),
+				KeepSourceOnlyMirrors: tt.keepSourceOnlyMirrors,
+				SourceMirrorsLast:     tt.sourceMirrorsLast,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, config.Registries)
+		})
+	}
+
+	// The merged mirror sets are not modified
+	sets := []mergedMirrorSet{{source: "source.example.com", mirrors: []string{"source.example.com", "z1.example.com"}}}
+	assert.Equal(t, []mergedMirrorSet{{source: "source.example.com", mirrors: []string{"z1.example.com", "source.example.com"}}}, withSourceMirrorsLast(sets))
+	assert.Equal(t, []string{"source.example.com", "z1.example.com"}, sets[0].mirrors)
+}
+
+func TestEditRegistriesConfigStrict(t *testing.T) {
+	opts := EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"registry-a.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.registry-b.com", "mirror-2.registry-b.com", "mirror-1.registry-b.com"}},
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-c.com"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						// Repeating the source as a fallback among real mirrors is not dropped; only the duplicate is.
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"registry-c.com", "mirror-tag.registry-c.com", "registry-c.com"}},
+					},
+				},
+			},
+		},
+		Strict: true,
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	assert.EqualError(t, err, "strict mode: some inputs would be dropped: ["+
+		`ImageDigestMirrorSet "idms": mirrors of "registry-a.com" contain only the source, ignoring, `+
+		`ImageDigestMirrorSet "idms": duplicate mirror "mirror-1.registry-b.com" of "registry-b.com", ignoring, `+
+		`ImageTagMirrorSet "itms": duplicate mirror "registry-c.com" of "registry-c.com", ignoring`+
+		"]")
+	assert.Empty(t, config.Registries)
+
+	// With KeepSourceOnlyMirrors, source-only mirror configurations are not dropped.
+	opts.KeepSourceOnlyMirrors = true
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	assert.EqualError(t, err, "strict mode: some inputs would be dropped: ["+
+		`ImageDigestMirrorSet "idms": duplicate mirror "mirror-1.registry-b.com" of "registry-b.com", ignoring, `+
+		`ImageTagMirrorSet "itms": duplicate mirror "registry-c.com" of "registry-c.com", ignoring`+
+		"]")
+
+	// Inputs which are fully represented are accepted.
+	opts.IDMSRules[0].Spec.ImageDigestMirrors[1].Mirrors = []apicfgv1.ImageMirror{"mirror-1.registry-b.com", "mirror-2.registry-b.com"}
+	opts.ITMSRules = nil
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Len(t, config.Registries, 3)
+
+	// Without Strict, the same inputs are accepted.
+	config = sysregistriesv2.V2RegistriesConf{}
+	opts = EditOptions{IDMSRules: opts.IDMSRules}
+	opts.IDMSRules[0].Spec.ImageDigestMirrors[1].Mirrors = append(opts.IDMSRules[0].Spec.ImageDigestMirrors[1].Mirrors, "mirror-1.registry-b.com")
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Len(t, config.Registries, 2)
+}
+
+func TestEditRegistriesConfigReportDuplicateSources(t *testing.T) {
+	opts := EditOptions{
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []string{"mirror-icsp.registry-a.com"}},
+						{Source: "registry-a.com", Mirrors: []string{"mirror-icsp-2.registry-a.com"}},
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms-1"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.registry-a.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.registry-b.com"}},
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-a.com"}},
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-3.registry-a.com"}}, // Reported only once
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms-2"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-b.com"}}, // Not a duplicate within idms-2
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag-1.registry-b.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag-2.registry-b.com"}},
+					},
+				},
+			},
+		},
+	}
+	expectedWarnings := []string{
+		`ImageContentSourcePolicy "icsp": duplicate source "registry-a.com", merging its mirror sets`,
+		`ImageDigestMirrorSet "idms-1": duplicate source "registry-a.com", merging its mirror sets`,
+		`ImageTagMirrorSet "itms": duplicate source "registry-b.com", merging its mirror sets`,
+	}
+
+	// The duplicates are merged either way; they are only reported with ReportDuplicateSources.
+	config := sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	expected := config
+
+	opts.ReportDuplicateSources = true
+	config = sysregistriesv2.V2RegistriesConf{}
+	warnings, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, expectedWarnings, warnings)
+	assert.Equal(t, expected, config)
+
+	opts.Strict = true
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	assert.EqualError(t, err, "strict mode: some inputs would be dropped: ["+strings.Join(expectedWarnings, ", ")+"]")
+	assert.Empty(t, config.Registries)
+}
+
+func TestValidateScopeList(t *testing.T) {
+	res := ValidateScopeList(nil)
+	assert.Empty(t, res)
+
+	res = ValidateScopeList([]string{"example.com", "*.example.com", "example.com/ns/repo"})
+	assert.Empty(t, res)
+
+	res = ValidateScopeList([]string{"", "example.com", "**.example.com", "example.*.com", "*.example.com"})
+	assert.Equal(t, []ScopeValidationError{
+		{Index: 0, Scope: ""},
+		{Index: 2, Scope: "**.example.com"},
+		{Index: 3, Scope: "example.*.com"},
+	}, res)
+	assert.Equal(t, `invalid scope "**.example.com" at index 2`, res[1].Error())
+}
+
+func TestEditRegistriesConfigInheritNestedScopeMirrors(t *testing.T) {
+	opts := EditOptions{
+		InsecureScopes: []string{"insecure-mirror.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror.com/quay", "insecure-mirror.com/quay"}},
+						{Source: "quay.io/myorg", Mirrors: []apicfgv1.ImageMirror{"mirror.com/myorg"}},
+						{Source: "quay.io/myorg/team", Mirrors: []apicfgv1.ImageMirror{"team-mirror.com"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.com/quay"}},
+					},
+				},
+			},
+		},
+	}
+
+	// By default, nested sources only use their own mirrors.
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Endpoint{
+		{Location: "mirror.com/myorg", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+	}, config.Registries[1].Mirrors)
+
+	opts.InheritNestedScopeMirrors = true
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.com/quay", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "insecure-mirror.com/quay", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror-tag.com/quay", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/myorg"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.com/myorg", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror.com/quay/myorg", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "insecure-mirror.com/quay/myorg", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror-tag.com/quay/myorg", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/myorg/team"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "team-mirror.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				// quay.io/myorg before quay.io
+				{Location: "mirror.com/myorg/team", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror.com/quay/myorg/team", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "insecure-mirror.com/quay/myorg/team", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror-tag.com/quay/myorg/team", PullFromMirror: sysregistriesv2.MirrorByTagOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure-mirror.com", Insecure: true},
+		},
+	}, config.Registries)
+
+	// Enclosing sources are ordered like sysregistriesv2 orders matching entries: of scopes with the same length,
+	// *.example.com governs a.example.com/ns before a.example.com does.
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "a.example.com", Mirrors: []apicfgv1.ImageMirror{"a-mirror.com"}},
+						{Source: "*.example.com", Mirrors: []apicfgv1.ImageMirror{"wildcard-mirror.com"}},
+						{Source: "a.example.com/ns", Mirrors: []apicfgv1.ImageMirror{"ns-mirror.com"}},
+					},
+				},
+			},
+		},
+		InheritNestedScopeMirrors: true,
+	})
+	require.NoError(t, err)
+	var nested *sysregistriesv2.Registry
+	for i := range config.Registries {
+		if registryScope(&config.Registries[i]) == "a.example.com/ns" {
+			nested = &config.Registries[i]
+		}
+	}
+	require.NotNil(t, nested)
+	assert.Equal(t, []sysregistriesv2.Endpoint{
+		{Location: "ns-mirror.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+		{Location: "wildcard-mirror.com/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+		{Location: "a-mirror.com/ns", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+	}, nested.Mirrors)
+}
+
+// fuzzScopeRegexp restricts the scopes used by FuzzEditRegistriesConfig to well-formed values; EditRegistriesConfig
+// expects its callers to validate the scopes.
+var fuzzScopeRegexp = regexp.MustCompile(`^(\*\.[a-z0-9-]+(\.[a-z0-9-]+)*|[a-z0-9-]+(\.[a-z0-9-]+)*(:[0-9]+)?(/[a-z0-9_-]+)*)$`)
+
+// encodeFuzzMirrorSets encodes mirror sets for FuzzEditRegistriesConfig: one "source=mirror,mirror" line per set,
+// with a "!" prefix if the source uses NeverContactSource.
+func encodeFuzzMirrorSets(sources []string, mirrors [][]apicfgv1.ImageMirror, policies []apicfgv1.MirrorSourcePolicy) string {
+	lines := []string{}
+	for i, source := range sources {
+		strMirrors := []string{}
+		for _, m := range mirrors[i] {
+			strMirrors = append(strMirrors, string(m))
+		}
+		line := source + "=" + strings.Join(strMirrors, ",")
+		if policies[i] == apicfgv1.NeverContactSource {
+			line = "!" + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decodeFuzzMirrorSets is the inverse of encodeFuzzMirrorSets. It returns false if the input is not valid.
+func decodeFuzzMirrorSets(data string) (sources []string, mirrors [][]apicfgv1.ImageMirror, policies []apicfgv1.MirrorSourcePolicy, ok bool) {
+	if data == "" {
+		return nil, nil, nil, true
+	}
+	for _, line := range strings.Split(data, "\n") {
+		policy := apicfgv1.MirrorSourcePolicy("")
+		if strings.HasPrefix(line, "!") {
+			line = line[1:]
+			policy = apicfgv1.NeverContactSource
+		}
+		source, strMirrors, found := strings.Cut(line, "=")
+		if !found || !fuzzScopeRegexp.MatchString(source) {
+			return nil, nil, nil, false
+		}
+		imgMirrors := []apicfgv1.ImageMirror{}
+		if strMirrors != "" {
+			for _, m := range strings.Split(strMirrors, ",") {
+				if !fuzzScopeRegexp.MatchString(m) || strings.HasPrefix(m, "*.") { // Mirrors can't be wildcards
+					return nil, nil, nil, false
+				}
+				imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
+			}
+		}
+		sources = append(sources, source)
+		mirrors = append(mirrors, imgMirrors)
+		policies = append(policies, policy)
+	}
+	return sources, mirrors, policies, true
+}
+
+func FuzzEditRegistriesConfig(f *testing.F) {
+	for _, tt := range editRegistriesConfigTestcases() {
+		var icspSources, idmsSources, itmsSources []string
+		var icspMirrors, idmsMirrors, itmsMirrors [][]apicfgv1.ImageMirror
+		var icspPolicies, idmsPolicies, itmsPolicies []apicfgv1.MirrorSourcePolicy
+		for _, icsp := range tt.icspRules {
+			for _, set := range icsp.Spec.RepositoryDigestMirrors {
+				imgMirrors := []apicfgv1.ImageMirror{}
+				for _, m := range set.Mirrors {
+					imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
+				}
+				icspSources, icspMirrors, icspPolicies = append(icspSources, set.Source), append(icspMirrors, imgMirrors), append(icspPolicies, "")
+			}
+		}
+		for _, idms := range tt.idmsRules {
+			for _, set := range idms.Spec.ImageDigestMirrors {
+				idmsSources, idmsMirrors, idmsPolicies = append(idmsSources, set.Source), append(idmsMirrors, set.Mirrors), append(idmsPolicies, set.MirrorSourcePolicy)
+			}
+		}
+		for _, itms := range tt.itmsRules {
+			for _, set := range itms.Spec.ImageTagMirrors {
+				itmsSources, itmsMirrors, itmsPolicies = append(itmsSources, set.Source), append(itmsMirrors, set.Mirrors), append(itmsPolicies, set.MirrorSourcePolicy)
+			}
+		}
+		f.Add(strings.Join(tt.insecure, "\n"), strings.Join(tt.blocked, "\n"),
+			encodeFuzzMirrorSets(icspSources, icspMirrors, icspPolicies),
+			encodeFuzzMirrorSets(idmsSources, idmsMirrors, idmsPolicies),
+			encodeFuzzMirrorSets(itmsSources, itmsMirrors, itmsPolicies))
+	}
+	// Mirrors of a wildcard source can't be used for a nested scope with a :port if they contain a path.
+	f.Add("", "x.a.com:5000", "", "*.a.com=b.com/c", "")
+
+	f.Fuzz(func(t *testing.T, insecure, blocked, icsp, idms, itms string) {
+		scopeList := func(data string) []string {
+			if data == "" {
+				return nil
+			}
+			res := strings.Split(data, "\n")
+			for _, scope := range res {
+				if !fuzzScopeRegexp.MatchString(scope) {
+					t.Skip("invalid scope")
+				}
+			}
+			return res
+		}
+		insecureScopes, blockedScopes := scopeList(insecure), scopeList(blocked)
+
+		icspSources, icspMirrors, _, ok := decodeFuzzMirrorSets(icsp)
+		if !ok {
+			t.Skip("invalid ImageContentSourcePolicy input")
+		}
+		rdms := []apioperatorsv1alpha1.RepositoryDigestMirrors{}
+		for i, source := range icspSources {
+			strMirrors := []string{}
+			for _, m := range icspMirrors[i] {
+				strMirrors = append(strMirrors, string(m))
+			}
+			rdms = append(rdms, apioperatorsv1alpha1.RepositoryDigestMirrors{Source: source, Mirrors: strMirrors})
+		}
+		idmsSources, idmsMirrors, idmsPolicies, ok := decodeFuzzMirrorSets(idms)
+		if !ok {
+			t.Skip("invalid ImageDigestMirrorSet input")
+		}
+		idm := []apicfgv1.ImageDigestMirrors{}
+		for i, source := range idmsSources {
+			idm = append(idm, apicfgv1.ImageDigestMirrors{Source: source, Mirrors: idmsMirrors[i], MirrorSourcePolicy: idmsPolicies[i]})
+		}
+		itmsSources, itmsMirrors, itmsPolicies, ok := decodeFuzzMirrorSets(itms)
+		if !ok {
+			t.Skip("invalid ImageTagMirrorSet input")
+		}
+		itm := []apicfgv1.ImageTagMirrors{}
+		for i, source := range itmsSources {
+			itm = append(itm, apicfgv1.ImageTagMirrors{Source: source, Mirrors: itmsMirrors[i], MirrorSourcePolicy: itmsPolicies[i]})
+		}
+
+		config := CopyRegistriesConf(&editRegistriesConfigTemplate)
+		err := EditRegistriesConfig(config, insecureScopes, blockedScopes,
+			[]*apioperatorsv1alpha1.ImageContentSourcePolicy{{Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{RepositoryDigestMirrors: rdms}}},
+			[]*apicfgv1.ImageDigestMirrorSet{{Spec: apicfgv1.ImageDigestMirrorSetSpec{ImageDigestMirrors: idm}}},
+			[]*apicfgv1.ImageTagMirrorSet{{Spec: apicfgv1.ImageTagMirrorSetSpec{ImageTagMirrors: itm}}})
+		if err != nil {
+			// Some combinations of well-formed inputs are rejected (e.g. mirrors of a wildcard source which can't be used for
+			// a nested scope with a :port); that must happen before the configuration is modified.
+			require.NotContains(t, err.Error(), "internal error")
+			require.Equal(t, &editRegistriesConfigTemplate, config, err.Error())
+			return
+		}
+
+		// Ensure that the generated configuration is actually valid.
+		buf := bytes.Buffer{}
+		err = toml.NewEncoder(&buf).Encode(config)
+		require.NoError(t, err)
+		registriesConf, err := os.CreateTemp("", "registries.conf")
+		require.NoError(t, err)
+		defer os.Remove(registriesConf.Name())
+		_, err = registriesConf.Write(buf.Bytes())
+		require.NoError(t, err)
+		err = registriesConf.Close()
+		require.NoError(t, err)
+		_, err = sysregistriesv2.GetRegistries(&types.SystemContext{
+			SystemRegistriesConfPath: registriesConf.Name(),
+		})
+		assert.NoError(t, err, buf.String())
+	})
+}
+
+func TestEditRegistriesConfigBlockMirrorsOfBlockedScopes(t *testing.T) {
+	opts := EditOptions{
+		InsecureScopes: []string{"insecure-mirror.com"},
+		BlockedScopes:  []string{"primary.com/top/blocked", "unmirrored.com/blocked", "registry-a.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "primary.com/top", Mirrors: []apicfgv1.ImageMirror{"mirror.com/primary", "insecure-mirror.com/primary"}},
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.com/registry-a"}},
+					},
+				},
+			},
+		},
+		BlockMirrorsOfBlockedScopes: true,
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "primary.com/top"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.com/primary", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "insecure-mirror.com/primary", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{ // A mirrored source is not affected
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Blocked:  true,
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.com/registry-a", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "primary.com/top/blocked"},
+			Blocked:  true,
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "mirror.com/primary/blocked", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "insecure-mirror.com/primary/blocked", Insecure: true, PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+		{ // Not nested inside any mirrored source: a standalone blocked entry
+			Endpoint: sysregistriesv2.Endpoint{Location: "unmirrored.com/blocked"},
+			Blocked:  true,
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure-mirror.com", Insecure: true},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "mirror.com/primary/blocked"},
+			Blocked:  true,
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "insecure-mirror.com/primary/blocked", Insecure: true},
+			Blocked:  true,
+		},
+	}, config.Registries)
+	template := sysregistriesv2.V2RegistriesConf{}
+	assert.NoError(t, AssertStableOutput(&template, opts))
+
+	// An existing insecure entry for a mirror stays insecure
+	config = sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{{Prefix: "mirror.com/q/ns", Endpoint: sysregistriesv2.Endpoint{Insecure: true}}},
+	}
+	_, err = EditRegistriesConfigWithOptions(&config, EditOptions{
+		BlockedScopes: []string{"quay.io/ns"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror.com/q"}},
+					},
+				},
+			},
+		},
+		BlockMirrorsOfBlockedScopes: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{Prefix: "mirror.com/q/ns", Endpoint: sysregistriesv2.Endpoint{Insecure: true}, Blocked: true},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/q")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/q/ns")},
+		},
+	}, config.Registries)
+}
+
+func TestEditRegistriesConfigCtx(t *testing.T) {
+	opts := EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"registry-b.com"}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, verbosity := range []int{0, 4} {
+		messages := []string{}
+		logger := funcr.New(func(prefix, args string) {
+			messages = append(messages, args)
+		}, funcr.Options{Verbosity: verbosity})
+		ctx := klog.NewContext(context.Background(), logger)
+
+		config := sysregistriesv2.V2RegistriesConf{}
+		warnings, err := EditRegistriesConfigCtx(ctx, &config, opts)
+		require.NoError(t, err)
+		assert.Len(t, warnings, 1)
+		assert.Len(t, config.Registries, 1)
+		if verbosity < 4 {
+			assert.Empty(t, messages)
+		} else {
+			assert.Equal(t, []string{
+				`"level"=4 "msg"="Dropped mirror configurations that contain only the source" "count"=1`,
+				`"level"=4 "msg"="Merged mirror sets" "digestSources"=1 "tagSources"=0`,
+				`"level"=4 "msg"="Edited registries configuration" "registries"=1`,
+			}, messages)
+		}
+	}
+}
+
+// recordingMergeObserver is a MergeObserver that records all calls.
+type recordingMergeObserver struct {
+	calls [][4]int
+}
+
+func (o *recordingMergeObserver) ObserveMerge(sources, mirrors, blocked, insecure int) {
+	o.calls = append(o.calls, [4]int{sources, mirrors, blocked, insecure})
+}
+
+func TestEditRegistriesConfigObserver(t *testing.T) {
+	observer := &recordingMergeObserver{}
+	tt := findEditRegistriesConfigTestcase(t, "imageDigestMirrorSet + imageTagMirrorSet")
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		InsecureScopes: []string{"mirror-tag-1.registry-a.com"},
+		BlockedScopes:  []string{"registry-a.com", "blocked.com"},
+		IDMSRules:      tt.idmsRules,
+		ITMSRules:      tt.itmsRules,
+		Observer:       observer,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, [][4]int{{2, 8, 2, 1}}, observer.calls)
+
+	// The observer is not called on failure
+	observer = &recordingMergeObserver{}
+	_, err = EditRegistriesConfigWithOptions(&config, EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PullFromMirrorAnnotation: "invalid"}}},
+		},
+		Observer: observer,
+	})
+	assert.Error(t, err)
+	assert.Empty(t, observer.calls)
+
+	// A nil observer is fine
+	_, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, EditOptions{IDMSRules: tt.idmsRules})
+	assert.NoError(t, err)
+}
+
+func TestEditRegistriesConfigBlockedContactableSource(t *testing.T) {
+	for _, policy := range []apicfgv1.MirrorSourcePolicy{"", apicfgv1.NeverContactSource} {
+		config := sysregistriesv2.V2RegistriesConf{}
+		err := EditRegistriesConfig(&config, nil, []string{"registry-a.com"}, nil, []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: policy},
+					},
+				},
+			},
+		}, nil)
+		assert.NoError(t, err, policy)
+	}
+
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, nil, []string{"registry-a.com"}, nil, []*apicfgv1.ImageDigestMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+				},
+			},
+		},
+	}, nil)
+	assert.EqualError(t, err, `ImageDigestMirrorSet "idms": source "registry-a.com" is blocked, but the mirror configuration uses mirrorSourcePolicy AllowContactingSource`)
+
+	err = EditRegistriesConfig(&config, nil, []string{"registry-a.com"}, nil, nil, []*apicfgv1.ImageTagMirrorSet{
+		{
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+				},
+			},
+		},
+	})
+	assert.Error(t, err)
+
+	// The source is also blocked if it is nested inside a blocked scope
+	for _, tt := range []struct{ blocked, source string }{
+		{"quay.io", "quay.io/ns"},
+		{"*.example.com", "reg.example.com"},
+		{"*.example.com", "*.nested.example.com"},
+		{"Registry-A.com", "registry-a.com/ns"},
+	} {
+		config = sysregistriesv2.V2RegistriesConf{}
+		err = EditRegistriesConfig(&config, nil, []string{tt.blocked}, nil, []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: tt.source, Mirrors: []apicfgv1.ImageMirror{"mirror.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+					},
+				},
+			},
+		}, nil)
+		assert.EqualError(t, err, fmt.Sprintf(`ImageDigestMirrorSet "idms": source %q is blocked, but the mirror configuration uses mirrorSourcePolicy AllowContactingSource`, tt.source), tt.blocked)
+	}
+
+	// A blocked scope nested inside the source is not a contradiction
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, []string{"registry-a.com/blocked"}, nil, nil, []*apicfgv1.ImageTagMirrorSet{
+		{
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+}
+
+// TestEditRegistriesConfigBlockedSourceWithMirrors verifies, using the registries.conf lookup of containers/image, that a blocked source
+// with mirrors can only be pulled from through the mirrors, for both digest and tag mirrors.
+func TestEditRegistriesConfigBlockedSourceWithMirrors(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, nil, []string{"quay.io"}, nil, []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/quay"}},
+				},
+			},
+		},
+	}, []*apicfgv1.ImageTagMirrorSet{
+		{
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"tag-mirror.example.com/quay"}},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	data, err := MarshalRegistriesConfTOML(&config)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	confPath := dir + "/registries.conf"
+	require.NoError(t, os.WriteFile(confPath, data, 0o600))
+	sys := &types.SystemContext{SystemRegistriesConfPath: confPath, SystemRegistriesConfDirPath: dir + "/registries.conf.d"}
+
+	for _, tt := range []struct {
+		ref      string
+		expected []string // Pull sources, in order; the last one is the source itself
+	}{
+		{"quay.io/ns/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", []string{"mirror.example.com/quay/ns/repo", "quay.io/ns/repo"}},
+		{"quay.io/ns/repo:tag", []string{"tag-mirror.example.com/quay/ns/repo", "quay.io/ns/repo"}},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			ref, err := reference.ParseNormalizedNamed(tt.ref)
+			require.NoError(t, err)
+			reg, err := sysregistriesv2.FindRegistry(sys, tt.ref)
+			require.NoError(t, err)
+			require.NotNil(t, reg)
+			sources, err := reg.PullSourcesFromReference(ref)
+			require.NoError(t, err)
+			names := []string{}
+			for _, source := range sources {
+				names = append(names, source.Reference.Name())
+			}
+			assert.Equal(t, tt.expected, names)
+
+			// containers/image refuses to contact a registry if the entry governing the (rewritten) reference is blocked.
+			for i, source := range sources {
+				sourceReg, err := sysregistriesv2.FindRegistry(sys, source.Reference.String())
+				require.NoError(t, err)
+				blocked := sourceReg != nil && sourceReg.Blocked
+				assert.Equal(t, i == len(sources)-1, blocked, source.Reference.String())
+			}
+		})
+	}
+}
+
+func TestEditRegistriesConfigMixedCaseScopes(t *testing.T) {
+	// Host names are case-insensitive, so insecure and blocked scopes which differ from a mirrored source only in the case of
+	// the host name are nested inside it.
+	idms := []*apicfgv1.ImageDigestMirrorSet{
+		{
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror.com/quay"}},
+				},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, []string{"QUAY.io"}, []string{"Quay.io/ns"}, nil, idms, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io", Insecure: true},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/quay")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "Quay.io/ns", Insecure: true},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/quay/ns")},
+		},
+	}, config.Registries)
+	data, err := MarshalRegistriesConfTOML(&config)
+	require.NoError(t, err)
+	registriesConf := t.TempDir() + "/registries.conf"
+	require.NoError(t, os.WriteFile(registriesConf, data, 0o600))
+	_, err = sysregistriesv2.GetRegistries(&types.SystemContext{SystemRegistriesConfPath: registriesConf})
+	assert.NoError(t, err)
+
+	// The same with a mixed-case source
+	idms[0].Spec.ImageDigestMirrors[0].Source = "Quay.io"
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, []string{"quay.io/ns"}, nil, idms, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "Quay.io"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/quay")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.com/quay/ns")},
+		},
+	}, config.Registries)
+}
+
+func TestEditRegistriesConfigNamespacedInsecureScope(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		template []sysregistriesv2.Registry
+		want     []sysregistriesv2.Registry
+	}{
+		{
+			name: "no parent entry",
+			want: []sysregistriesv2.Registry{
+				{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/internal-http-ns", Insecure: true}},
+			},
+		},
+		{
+			name:     "secure parent entry",
+			template: []sysregistriesv2.Registry{{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}}},
+			want: []sysregistriesv2.Registry{
+				{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}},
+				{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/internal-http-ns", Insecure: true}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{Registries: tt.template}
+			err := EditRegistriesConfig(&config, []string{"quay.io/internal-http-ns"}, nil, nil, nil, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.Registries)
+
+			data, err := MarshalRegistriesConfTOML(&config)
+			require.NoError(t, err)
+			dir := t.TempDir()
+			confPath := dir + "/registries.conf"
+			require.NoError(t, os.WriteFile(confPath, data, 0o600))
+			sys := &types.SystemContext{SystemRegistriesConfPath: confPath, SystemRegistriesConfDirPath: dir + "/registries.conf.d"}
+			for _, c := range []struct {
+				ref      string
+				insecure bool
+			}{
+				{"quay.io/internal-http-ns/repo:tag", true},
+				{"quay.io/internal-http-ns", true},
+				{"quay.io/internal-http-ns-other/repo:tag", false},
+				{"quay.io/ns/repo:tag", false},
+				{"quay.io/repo:tag", false},
+			} {
+				reg, err := sysregistriesv2.FindRegistry(sys, c.ref)
+				require.NoError(t, err)
+				insecure := reg != nil && reg.Insecure
+				assert.Equal(t, c.insecure, insecure, c.ref)
+			}
+		})
+	}
+}
+
+func TestEditRegistriesConfigWildcardScopesIncludeApex(t *testing.T) {
+	for _, tt := range []struct {
+		includeApex bool
+		want        []sysregistriesv2.Registry
+		covered     map[string][2]bool // Key == reference, value == [insecure, blocked]
+	}{
+		{
+			includeApex: false,
+			want: []sysregistriesv2.Registry{
+				{Prefix: "*.blocked.example.com", Blocked: true},
+				{Prefix: "*.insecure.example.com", Endpoint: sysregistriesv2.Endpoint{Insecure: true}},
+			},
+			covered: map[string][2]bool{
+				"registry.insecure.example.com/repo": {true, false},
+				"insecure.example.com/repo":          {false, false},
+				"registry.blocked.example.com/repo":  {false, true},
+				"blocked.example.com/repo":           {false, false},
+			},
+		},
+		{
+			includeApex: true,
+			want: []sysregistriesv2.Registry{
+				{Prefix: "*.blocked.example.com", Blocked: true},
+				{Prefix: "*.insecure.example.com", Endpoint: sysregistriesv2.Endpoint{Insecure: true}},
+				{Endpoint: sysregistriesv2.Endpoint{Location: "blocked.example.com"}, Blocked: true},
+				{Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.com", Insecure: true}},
+			},
+			covered: map[string][2]bool{
+				"registry.insecure.example.com/repo": {true, false},
+				"insecure.example.com/repo":          {true, false},
+				"insecure.example.com:5000/repo":     {true, false}, // containers/image matches host entries with any port
+				"registry.blocked.example.com/repo":  {false, true},
+				"blocked.example.com/repo":           {false, true},
+				"other.example.com/repo":             {false, false},
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("%t", tt.includeApex), func(t *testing.T) {
+			opts := EditOptions{
+				InsecureScopes:            []string{"*.insecure.example.com"},
+				BlockedScopes:             []string{"*.blocked.example.com"},
+				SortRegistries:            true,
+				WildcardScopesIncludeApex: tt.includeApex,
+			}
+			if tt.includeApex {
+				opts.BlockedScopes = append(opts.BlockedScopes, "blocked.example.com") // Already listed, not added again
+			}
+			originalBlocked := append([]string{}, opts.BlockedScopes...)
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := EditRegistriesConfigWithOptions(&config, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.Registries)
+			// The inputs are not modified
+			assert.Equal(t, []string{"*.insecure.example.com"}, opts.InsecureScopes)
+			assert.Equal(t, originalBlocked, opts.BlockedScopes)
+
+			data, err := MarshalRegistriesConfTOML(&config)
+			require.NoError(t, err)
+			dir := t.TempDir()
+			confPath := dir + "/registries.conf"
+			require.NoError(t, os.WriteFile(confPath, data, 0o600))
+			sys := &types.SystemContext{SystemRegistriesConfPath: confPath, SystemRegistriesConfDirPath: dir + "/registries.conf.d"}
+			for ref, expected := range tt.covered {
+				reg, err := sysregistriesv2.FindRegistry(sys, ref)
+				require.NoError(t, err)
+				assert.Equal(t, expected, [2]bool{reg != nil && reg.Insecure, reg != nil && reg.Blocked}, ref)
+			}
+		})
+	}
+}
+
+func TestEditRegistriesConfigPullThroughMirrors(t *testing.T) {
+	newIDMS := func(annotation string, policy apicfgv1.MirrorSourcePolicy) []*apicfgv1.ImageDigestMirrorSet {
+		return []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "idms",
+					Annotations: map[string]string{PullThroughMirrorsAnnotation: annotation},
+				},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"cache.example.com/registry-a", "mirror.example.com"}, MirrorSourcePolicy: policy},
+					},
+				},
+			},
+		}
+	}
+
+	// The mirror entries are not affected by the annotation
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfig(&config, nil, nil, nil, newIDMS("cache.example.com/registry-a", ""), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "cache.example.com/registry-a", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+				{Location: "mirror.example.com", PullFromMirror: sysregistriesv2.MirrorByDigestOnly},
+			},
+		},
+	}, config.Registries)
+
+	// A pull-through mirror requires contacting the source
+	err = EditRegistriesConfig(&sysregistriesv2.V2RegistriesConf{}, nil, nil, nil, newIDMS("other.example.com, cache.example.com/registry-a", apicfgv1.NeverContactSource), nil)
+	assert.EqualError(t, err, `ImageDigestMirrorSet "idms": pull-through mirror "cache.example.com/registry-a" requires contacting source "registry-a.com", which uses mirrorSourcePolicy NeverContactSource`)
+	err = EditRegistriesConfig(&sysregistriesv2.V2RegistriesConf{}, nil, nil, nil, newIDMS("other.example.com", apicfgv1.NeverContactSource), nil)
+	assert.NoError(t, err)
+
+	// Invalid annotation values
+	err = EditRegistriesConfig(&sysregistriesv2.V2RegistriesConf{}, nil, nil, nil, newIDMS("cache.example.com,", ""), nil)
+	assert.Error(t, err)
+
+	// ImageTagMirrorSet
+	err = EditRegistriesConfig(&sysregistriesv2.V2RegistriesConf{}, nil, nil, nil, nil, []*apicfgv1.ImageTagMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PullThroughMirrorsAnnotation: "cache.example.com"}},
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"cache.example.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+				},
+			},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestEditRegistriesConfigArchitecture(t *testing.T) {
+	idms := func(name, archs, mirror string) *apicfgv1.ImageDigestMirrorSet {
+		res := &apicfgv1.ImageDigestMirrorSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{apicfgv1.ImageMirror(mirror)}},
+				},
+			},
+		}
+		if archs != "" {
+			res.Annotations = map[string]string{ArchitectureAnnotation: archs}
+		}
+		return res
+	}
+	opts := EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			idms("amd64", "amd64", "mirror-amd64.internal"),
+			idms("arm64", "arm64, ppc64le", "mirror-arm64.internal"),
+			idms("all", "", "mirror.internal"),
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "itms",
+					Annotations: map[string]string{ArchitectureAnnotation: "arm64"},
+				},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"tag-mirror-arm64.internal"}},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range []struct {
+		arch     string
+		expected []sysregistriesv2.Endpoint
+	}{
+		{"", []sysregistriesv2.Endpoint{ // All objects are used
+			NewDigestMirror("mirror-amd64.internal"), NewDigestMirror("mirror-arm64.internal"), NewDigestMirror("mirror.internal"),
+			NewTagMirror("tag-mirror-arm64.internal"),
+		}},
+		{"amd64", []sysregistriesv2.Endpoint{NewDigestMirror("mirror-amd64.internal"), NewDigestMirror("mirror.internal")}},
+		{"arm64", []sysregistriesv2.Endpoint{NewDigestMirror("mirror-arm64.internal"), NewDigestMirror("mirror.internal"), NewTagMirror("tag-mirror-arm64.internal")}},
+		{"ppc64le", []sysregistriesv2.Endpoint{NewDigestMirror("mirror-arm64.internal"), NewDigestMirror("mirror.internal")}},
+		{"s390x", []sysregistriesv2.Endpoint{NewDigestMirror("mirror.internal")}},
+	} {
+		t.Run(tt.arch, func(t *testing.T) {
+			opts := opts
+			opts.Architecture = tt.arch
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := EditRegistriesConfigWithOptions(&config, opts)
+			require.NoError(t, err)
+			require.Len(t, config.Registries, 1)
+			assert.Equal(t, tt.expected, config.Registries[0].Mirrors)
+		})
+	}
+
+	// Invalid annotations are only rejected if Architecture is set
+	opts.IDMSRules = append(opts.IDMSRules, idms("invalid", "amd64,", "mirror-invalid.internal"))
+	_, err := EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, opts)
+	assert.NoError(t, err)
+	opts.Architecture = "amd64"
+	_, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, opts)
+	assert.EqualError(t, err, `ImageDigestMirrorSet "invalid": invalid runtime-utils.openshift.io/architecture value "amd64,": empty architecture`)
+}
+
+func TestEditRegistriesConfigInsecureSourcesAnnotation(t *testing.T) {
+	idms := func(annotation string) *apicfgv1.ImageDigestMirrorSet {
+		return &apicfgv1.ImageDigestMirrorSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "idms", Annotations: map[string]string{InsecureSourcesAnnotation: annotation}},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/a"}},
+					{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/b"}},
+				},
+			},
+		}
+	}
+	opts := EditOptions{
+		InsecureScopes: []string{"mirror.example.com/b"},
+		IDMSRules:      []*apicfgv1.ImageDigestMirrorSet{idms("registry-a.com/ns")},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms", Annotations: map[string]string{InsecureSourcesAnnotation: " registry-c.com , registry-c.com"}},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-c.com", Mirrors: []apicfgv1.ImageMirror{"registry-c.com/mirror"}}, // Nested inside the insecure source
+					},
+				},
+			},
+		},
+	}
+	insecure := func(config *sysregistriesv2.V2RegistriesConf) []string {
+		res := []string{}
+		for _, reg := range config.Registries {
+			if reg.Insecure {
+				res = append(res, registryScope(&reg))
+			}
+			for _, mirror := range reg.Mirrors {
+				if mirror.Insecure {
+					res = append(res, registryScope(&reg)+" → "+mirror.Location)
+				}
+			}
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	// Without InsecureSourcesFromAnnotations, the annotation is ignored
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirror.example.com/b", "registry-b.com → mirror.example.com/b"}, insecure(&config))
+
+	opts.InsecureSourcesFromAnnotations = true
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"mirror.example.com/b", "registry-a.com/ns", "registry-b.com → mirror.example.com/b", "registry-c.com", "registry-c.com → registry-c.com/mirror",
+	}, insecure(&config))
+	assert.Equal(t, []string{"mirror.example.com/b"}, opts.InsecureScopes) // The inputs are not modified
+
+	for _, tt := range []struct {
+		annotation, expected string
+	}{
+		{"registry-c.com", `ImageDigestMirrorSet "idms": runtime-utils.openshift.io/insecure-sources lists "registry-c.com", which is not a source of this object`},
+		{"registry-a.com", `ImageDigestMirrorSet "idms": runtime-utils.openshift.io/insecure-sources lists "registry-a.com", which is not a source of this object`},
+		{"registry-a.com/ns,", `ImageDigestMirrorSet "idms": invalid runtime-utils.openshift.io/insecure-sources value "registry-a.com/ns,": empty source`},
+		{"*.example.com/ns", `ImageDigestMirrorSet "idms": invalid runtime-utils.openshift.io/insecure-sources value "*.example.com/ns": invalid scope "*.example.com/ns"`},
+	} {
+		t.Run(tt.annotation, func(t *testing.T) {
+			opts := opts
+			opts.IDMSRules = []*apicfgv1.ImageDigestMirrorSet{idms(tt.annotation)}
+			_, err := EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, opts)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestEditRegistriesConfigDisabledMirrors(t *testing.T) {
+	idms := &apicfgv1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+		Spec: apicfgv1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+				{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.example.com/a", "mirror-2.example.com/a", "mirror-3.example.com/a"}},
+				{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.example.com/b"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+			},
+		},
+	}
+	itms := &apicfgv1.ImageTagMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+		Spec: apicfgv1.ImageTagMirrorSetSpec{
+			ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+				{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.example.com/a"}},
+			},
+		},
+	}
+	icsp := &apioperatorsv1alpha1.ImageContentSourcePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+		Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+			RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+				{Source: "registry-c.com", Mirrors: []string{"mirror-2.example.com/c", "registry-c.com"}},
+			},
+		},
+	}
+	icp := &apicfgv1.ImageContentPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "icp"},
+		Spec: apicfgv1.ImageContentPolicySpec{
+			RepositoryDigestMirrors: []apicfgv1.RepositoryDigestMirrors{
+				{Source: "registry-d.com", Mirrors: []apicfgv1.Mirror{"mirror-2.example.com/d", "mirror-1.example.com/d"}},
+			},
+		},
+	}
+	opts := EditOptions{
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{icsp},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{idms},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{itms},
+		ICPRules:  []*apicfgv1.ImageContentPolicy{icp},
+	}
+	config := sysregistriesv2.V2RegistriesConf{}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	require.Len(t, config.Registries, 4)
+
+	opts.DisabledMirrors = []string{"mirror-2.example.com/a", "mirror-2.example.com/b", "mirror-2.example.com/c", "mirror-2.example.com/d", "unused.example.com"}
+	config = sysregistriesv2.V2RegistriesConf{}
+	warnings, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	// registry-b.com and registry-c.com are left without real mirrors, so they are dropped, and registry-b.com is no longer blocked.
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror-1.example.com/a"), NewDigestMirror("mirror-3.example.com/a")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-d.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror-1.example.com/d")},
+		},
+	}, config.Registries)
+	// Only listing the source is not a mistake in the rules when the other mirrors are disabled, so there is no warning.
+	assert.Empty(t, warnings)
+	// The inputs are not modified
+	assert.Len(t, idms.Spec.ImageDigestMirrors[0].Mirrors, 3)
+	assert.Len(t, itms.Spec.ImageTagMirrors[0].Mirrors, 1)
+	assert.Len(t, icsp.Spec.RepositoryDigestMirrors[0].Mirrors, 2)
+	assert.Len(t, icp.Spec.RepositoryDigestMirrors[0].Mirrors, 2)
+
+	// Disabling all real mirrors of a source does not make Strict mode fail
+	for _, keepSourceOnlyMirrors := range []bool{false, true} {
+		config = sysregistriesv2.V2RegistriesConf{}
+		warnings, err = EditRegistriesConfigWithOptions(&config, EditOptions{
+			IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+					Spec: apicfgv1.ImageDigestMirrorSetSpec{
+						ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+							{Source: "quay.io", Mirrors: []apicfgv1.ImageMirror{"mirror.com", "quay.io"}},
+						},
+					},
+				},
+			},
+			DisabledMirrors:       []string{"mirror.com"},
+			KeepSourceOnlyMirrors: keepSourceOnlyMirrors,
+			Strict:                true,
+		})
+		require.NoError(t, err, keepSourceOnlyMirrors)
+		assert.Empty(t, warnings, keepSourceOnlyMirrors)
+		assert.Empty(t, config.Registries, keepSourceOnlyMirrors)
+	}
+}
+
+func TestEditRegistriesConfigMirrorRewrite(t *testing.T) {
+	opts := EditOptions{
+		InsecureScopes: []string{"proxy.example.com/insecure.example.net"},
+		BlockedScopes:  []string{"registry-a.com/ns/blocked"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "idms",
+					Annotations: map[string]string{PullFromMirrorAnnotation: "mirror.example.com/a=all"},
+				},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/a", "mirror.example.org/a", "insecure.example.net/a"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/a"}},
+					},
+				},
+			},
+		},
+		// Route everything through a proxy, and treat example.org as an alias of example.com
+		MirrorRewrite: func(location string) (string, error) {
+			return "proxy.example.com/" + strings.Replace(location, ".example.org/", ".example.com/", 1), nil
+		},
+		VerifyMirrorModes: true,
+	}
+	config := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Mirrors: []sysregistriesv2.Endpoint{{Location: "mirror.example.com/b"}}},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	insecureMirror := NewDigestMirror("proxy.example.com/insecure.example.net/a")
+	insecureMirror.Insecure = true
+	insecureNestedMirror := NewDigestMirror("proxy.example.com/insecure.example.net/a/blocked")
+	insecureNestedMirror.Insecure = true
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Mirrors: []sysregistriesv2.Endpoint{{Location: "mirror.example.com/b"}}}, // Not rewritten
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com/ns"},
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "proxy.example.com/mirror.example.com/a", PullFromMirror: sysregistriesv2.MirrorAll},
+				NewDigestMirror("proxy.example.com/mirror.example.com/a"), // From mirror.example.org/a
+				insecureMirror,
+				NewTagMirror("proxy.example.com/mirror.example.com/a"),
+			},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com/ns/blocked"},
+			Blocked:  true,
+			Mirrors: []sysregistriesv2.Endpoint{
+				{Location: "proxy.example.com/mirror.example.com/a/blocked", PullFromMirror: sysregistriesv2.MirrorAll},
+				NewDigestMirror("proxy.example.com/mirror.example.com/a/blocked"),
+				insecureNestedMirror,
+				NewTagMirror("proxy.example.com/mirror.example.com/a/blocked"),
+			},
+		},
+		{Endpoint: sysregistriesv2.Endpoint{Location: "proxy.example.com/insecure.example.net", Insecure: true}},
+	}, config.Registries)
+
+	// Mirrors rewritten to the same location, with the same mode, are only added once
+	opts.MirrorRewrite = func(location string) (string, error) { return "proxy.example.com/a", nil }
+	opts.IDMSRules[0].Annotations = nil
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	require.NotEmpty(t, config.Registries)
+	assert.Equal(t, []sysregistriesv2.Endpoint{NewDigestMirror("proxy.example.com/a"), NewTagMirror("proxy.example.com/a")}, config.Registries[0].Mirrors)
+
+	opts.MirrorRewrite = func(location string) (string, error) {
+		if location == "mirror.example.org/a" {
+			return "", errors.New("unknown mirror")
+		}
+		return location, nil
+	}
+	_, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, opts)
+	assert.EqualError(t, err, `rewriting mirror "mirror.example.org/a" of "registry-a.com/ns": unknown mirror`)
+	opts.MirrorRewrite = func(location string) (string, error) { return "*." + location, nil }
+	_, err = EditRegistriesConfigWithOptions(&sysregistriesv2.V2RegistriesConf{}, opts)
+	assert.EqualError(t, err, `rewriting mirror "mirror.example.com/a" of "registry-a.com/ns": invalid location "*.mirror.example.com/a"`)
+}
+
+func TestEditRegistriesConfigBlockedSearchRegistries(t *testing.T) {
+	template := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io", "Quay.io:5000", "registry.example.com"},
+	}
+	opts := EditOptions{BlockedScopes: []string{"*.redhat.com", "docker.io/library", "quay.io:5000", "registry.example.com"}}
+	config := CopyRegistriesConf(&template)
+	warnings, err := EditRegistriesConfigWithOptions(config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`unqualified search registry "registry.access.redhat.com" is inside blocked scope "*.redhat.com", so short-name pulls from it fail`,
+		`unqualified search registry "Quay.io:5000" is inside blocked scope "quay.io:5000", so short-name pulls from it fail`,
+		`unqualified search registry "registry.example.com" is inside blocked scope "registry.example.com", so short-name pulls from it fail`,
+	}, warnings)
+	assert.Len(t, config.Registries, 4)
+
+	// In strict mode, the edit fails without modifying the configuration
+	opts.Strict = true
+	config = CopyRegistriesConf(&template)
+	_, err = EditRegistriesConfigWithOptions(config, opts)
+	assert.EqualError(t, err, "strict mode: ["+
+		`unqualified search registry "registry.access.redhat.com" is inside blocked scope "*.redhat.com", so short-name pulls from it fail, `+
+		`unqualified search registry "Quay.io:5000" is inside blocked scope "quay.io:5000", so short-name pulls from it fail, `+
+		`unqualified search registry "registry.example.com" is inside blocked scope "registry.example.com", so short-name pulls from it fail]`)
+	assert.Equal(t, &template, config)
+	_, err = EditRegistriesConfigBatch([]*sysregistriesv2.V2RegistriesConf{&template}, opts)
+	assert.Error(t, err)
+
+	opts.BlockedScopes = []string{"docker.io/library"}
+	_, err = EditRegistriesConfigWithOptions(CopyRegistriesConf(&template), opts)
+	assert.NoError(t, err)
+}
+
+func TestEditRegistriesConfigSortRegistries(t *testing.T) {
+	idms := func(name, source string, mirrors ...apicfgv1.ImageMirror) *apicfgv1.ImageDigestMirrorSet {
+		return &apicfgv1.ImageDigestMirrorSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{{Source: source, Mirrors: mirrors}},
+			},
+		}
+	}
+	itms := &apicfgv1.ImageTagMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+		Spec: apicfgv1.ImageTagMirrorSetSpec{
+			ImageTagMirrors: []apicfgv1.ImageTagMirrors{{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/a"}}},
+		},
+	}
+	template := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{{Endpoint: sysregistriesv2.Endpoint{Location: "template.example.com"}}},
+	}
+	scopes := func(config *sysregistriesv2.V2RegistriesConf) []string {
+		res := []string{}
+		for i := range config.Registries {
+			res = append(res, registryScope(&config.Registries[i]))
+		}
+		return res
+	}
+	edit := func(opts EditOptions) *sysregistriesv2.V2RegistriesConf {
+		config := CopyRegistriesConf(&template)
+		_, err := EditRegistriesConfigWithOptions(config, opts)
+		require.NoError(t, err)
+		return config
+	}
+
+	opts := EditOptions{
+		InsecureScopes: []string{"insecure.example.com", "*.example.net"},
+		BlockedScopes:  []string{"registry-b.com/ns/blocked", "blocked.example.com"},
+		IDMSRules:      []*apicfgv1.ImageDigestMirrorSet{idms("idms-1", "registry-b.com/ns", "mirror.example.com/b"), idms("idms-2", "registry-c.com", "mirror.example.com/c")},
+		ITMSRules:      []*apicfgv1.ImageTagMirrorSet{itms},
+	}
+	reversed := opts
+	reversed.InsecureScopes = []string{"*.example.net", "insecure.example.com"}
+	reversed.BlockedScopes = []string{"blocked.example.com", "registry-b.com/ns/blocked"}
+	reversed.IDMSRules = []*apicfgv1.ImageDigestMirrorSet{opts.IDMSRules[1], opts.IDMSRules[0]}
+	assert.Equal(t, []string{
+		"template.example.com", "registry-b.com/ns", "registry-c.com", "registry-a.com", "registry-b.com/ns/blocked", "blocked.example.com",
+		"insecure.example.com", "*.example.net",
+	}, scopes(edit(opts)))
+	assert.NotEqual(t, scopes(edit(opts)), scopes(edit(reversed)))
+
+	opts.SortRegistries, reversed.SortRegistries = true, true
+	sorted := edit(opts)
+	assert.Equal(t, []string{
+		"*.example.net", "blocked.example.com", "insecure.example.com", "registry-a.com", "registry-b.com/ns", "registry-b.com/ns/blocked",
+		"registry-c.com", "template.example.com",
+	}, scopes(sorted))
+	assert.Equal(t, sorted, edit(reversed))
+	// Mirrors are not reordered
+	reg, _ := findGoverningRegistry(sorted, "registry-b.com/ns/blocked", nil, -1)
+	require.NotNil(t, reg)
+	assert.Equal(t, []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/b/blocked")}, reg.Mirrors)
+}
+
+func TestEditRegistriesConfigLegacyMirrorFormat(t *testing.T) {
+	idms := func(annotations map[string]string, mirrors ...apicfgv1.ImageMirror) []*apicfgv1.ImageDigestMirrorSet {
+		return []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms", Annotations: annotations},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{{Source: "registry-a.com", Mirrors: mirrors}},
+				},
+			},
+		}
+	}
+	for _, tt := range []struct {
+		name     string
+		config   sysregistriesv2.V2RegistriesConf
+		opts     EditOptions
+		expected string
+	}{
+		{
+			name: "ImageTagMirrorSet",
+			opts: EditOptions{
+				ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+					{
+						ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+						Spec: apicfgv1.ImageTagMirrorSetSpec{
+							ImageTagMirrors: []apicfgv1.ImageTagMirrors{{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com"}}},
+						},
+					},
+				},
+			},
+			expected: `legacy mirror format: ImageTagMirrorSet "itms" is not allowed`,
+		},
+		{
+			name:     "tag-only annotation",
+			opts:     EditOptions{IDMSRules: idms(map[string]string{PullFromMirrorAnnotation: "mirror-2.example.com=tag-only"}, "mirror-1.example.com", "mirror-2.example.com")},
+			expected: `legacy mirror format: registry "registry-a.com": tag-only mirror "mirror-2.example.com" can't be represented`,
+		},
+		{
+			name:     "mixed modes",
+			opts:     EditOptions{IDMSRules: idms(map[string]string{PullFromMirrorAnnotation: "mirror-2.example.com=all"}, "mirror-1.example.com", "mirror-2.example.com")},
+			expected: `legacy mirror format: registry "registry-a.com": digest-only mirror "mirror-1.example.com" and mirror "mirror-2.example.com", which is used for all pulls, can't be represented together`,
+		},
+		{
+			name: "tag-only mirror in the template",
+			config: sysregistriesv2.V2RegistriesConf{
+				Registries: []sysregistriesv2.Registry{
+					{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Mirrors: []sysregistriesv2.Endpoint{NewTagMirror("mirror.example.com/b")}},
+				},
+			},
+			expected: `legacy mirror format: registry "registry-b.com": tag-only mirror "mirror.example.com/b" can't be represented`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.LegacyMirrorFormat = true
+			_, err := EditRegistriesConfigWithOptions(&tt.config, tt.opts)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+
+	// Mirrors already present in the configuration are converted as well, and entries without mirrors are not changed
+	config := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Mirrors: []sysregistriesv2.Endpoint{{Location: "mirror.example.com/b", PullFromMirror: sysregistriesv2.MirrorAll}}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"}, MirrorByDigestOnly: true},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&config, EditOptions{IDMSRules: idms(nil, "mirror.example.com/a"), LegacyMirrorFormat: true, VerifyMirrorModes: true})
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Mirrors: []sysregistriesv2.Endpoint{{Location: "mirror.example.com/b"}}},
+		{Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"}, MirrorByDigestOnly: true},
+		{Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"}, MirrorByDigestOnly: true, Mirrors: []sysregistriesv2.Endpoint{{Location: "mirror.example.com/a"}}},
+	}, config.Registries)
+}
+
+func TestEditRegistriesConfigDigestOnlyMirrors(t *testing.T) {
+	opts := EditOptions{
+		DigestOnlyMirrors: true,
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []string{"mirror.example.com/b"}},
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "idms",
+					Annotations: map[string]string{PullFromMirrorAnnotation: "mirror.example.com/a=digest-only"},
+				},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/a"}},
+					},
+				},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{{Location: "mirror.example.com/c"}, NewTagMirror("mirror.example.com/c-tags")},
+			},
+			{
+				Endpoint:           sysregistriesv2.Endpoint{Location: "registry-d.com"},
+				MirrorByDigestOnly: true,
+				Mirrors:            []sysregistriesv2.Endpoint{{Location: "mirror.example.com/d"}},
+			},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/c"), NewDigestMirror("mirror.example.com/c-tags")},
+		},
+		{
+			Endpoint:           sysregistriesv2.Endpoint{Location: "registry-d.com"},
+			MirrorByDigestOnly: true,
+			Mirrors:            []sysregistriesv2.Endpoint{{Location: "mirror.example.com/d"}},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/a")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/b")},
+		},
+	}, config.Registries)
+
+	for _, tt := range []struct {
+		name     string
+		edit     func(opts *EditOptions)
+		expected string
+	}{
+		{
+			name: "ImageTagMirrorSet",
+			edit: func(opts *EditOptions) {
+				for _, name := range []string{"itms-1", "itms-2"} {
+					opts.ITMSRules = append(opts.ITMSRules, &apicfgv1.ImageTagMirrorSet{
+						ObjectMeta: metav1.ObjectMeta{Name: name},
+						Spec: apicfgv1.ImageTagMirrorSetSpec{
+							ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+								{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"tag-mirror.example.com/a"}},
+							},
+						},
+					})
+				}
+			},
+			expected: `digest-only mirrors: [ImageTagMirrorSet "itms-1" is not allowed, ImageTagMirrorSet "itms-2" is not allowed]`,
+		},
+		{
+			name: "empty ImageTagMirrorSet",
+			edit: func(opts *EditOptions) {
+				opts.ITMSRules = []*apicfgv1.ImageTagMirrorSet{{ObjectMeta: metav1.ObjectMeta{Name: "itms"}}}
+			},
+			expected: `digest-only mirrors: ImageTagMirrorSet "itms" is not allowed`,
+		},
+		{
+			name: "pull-from-mirror override",
+			edit: func(opts *EditOptions) {
+				opts.IDMSRules[0].Annotations = map[string]string{PullFromMirrorAnnotation: "mirror.example.com/a=all"}
+			},
+			expected: `digest-only mirrors: runtime-utils.openshift.io/pull-from-mirror value "all" is not allowed for mirror "mirror.example.com/a" of "registry-a.com"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := opts
+			opts.IDMSRules = []*apicfgv1.ImageDigestMirrorSet{opts.IDMSRules[0].DeepCopy()}
+			tt.edit(&opts)
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := EditRegistriesConfigWithOptions(&config, opts)
+			assert.EqualError(t, err, tt.expected)
+			assert.Empty(t, config.Registries)
+		})
+	}
+}
+
+func TestEditRegistriesConfigBlockedParentOfMirroredSource(t *testing.T) {
+	idms := &apicfgv1.ImageDigestMirrorSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+		Spec: apicfgv1.ImageDigestMirrorSetSpec{
+			ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+				{Source: "quay.io/org", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/org"}},
+				{Source: "foo.example.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ns"}},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{ // Already present in the template
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/template"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/template")},
+			},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&config, EditOptions{
+		BlockedScopes: []string{"quay.io", "*.example.com"},
+		IDMSRules:     []*apicfgv1.ImageDigestMirrorSet{idms},
+	})
+	require.NoError(t, err)
+	// Blocking a parent scope blocks the nested mirrored sources, which can only be pulled from their mirrors
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/template"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/template")},
+			Blocked:  true,
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "foo.example.com/ns"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")},
+			Blocked:  true,
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/org"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/org")},
+			Blocked:  true,
+		},
+		{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}, Blocked: true},
+		{Prefix: "*.example.com", Blocked: true},
+	}, config.Registries)
+}
@@ -0,0 +1,473 @@
+package registries
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// builtinRegistryAliases are registry host name aliases which are always applied by FindGoverningScope and ResolveMirrors,
+// in addition to the caller-provided ones: docker.io is served by registry-1.docker.io.
+var builtinRegistryAliases = map[string]string{
+	"docker.io": "registry-1.docker.io",
+}
+
+// canonicalScope returns scope with its host name (including any :port) converted to lower case, and replaced by the canonical
+// name from aliases (a map of alias → canonical host name, in lower case; see lowercaseAliases), or from builtinRegistryAliases.
+// Wildcard scopes are only converted to lower case.
+func canonicalScope(scope string, aliases map[string]string) string {
+	scope = lowercaseScopeHost(scope)
+	if strings.HasPrefix(scope, "*.") {
+		return scope
+	}
+	host, rest := scope, ""
+	if i := strings.IndexByte(scope, '/'); i != -1 {
+		host, rest = scope[:i], scope[i:]
+	}
+	if canonical, ok := aliases[host]; ok {
+		return canonical + rest
+	}
+	if canonical, ok := builtinRegistryAliases[host]; ok {
+		return canonical + rest
+	}
+	return scope
+}
+
+// lowercaseAliases returns aliases (a map of alias → canonical host name, may be nil) with all host names converted to lower case,
+// as expected by canonicalScope.
+func lowercaseAliases(aliases map[string]string) map[string]string {
+	if aliases == nil {
+		return nil
+	}
+	res := make(map[string]string, len(aliases))
+	for alias, canonical := range aliases {
+		res[strings.ToLower(alias)] = strings.ToLower(canonical)
+	}
+	return res
+}
+
+// findGoverningRegistry returns the entry of conf that governs refScope (which must already be canonical), along with
+// the canonical form of its scope, or nil if there is no such entry. The entry at index skip, if any, is ignored.
+// Like sysregistriesv2, it prefers the matching entry with the longest scope, as written in conf (without replacing
+// aliases); see prefixTakesPrecedence.
+func findGoverningRegistry(conf *sysregistriesv2.V2RegistriesConf, refScope string, aliases map[string]string, skip int) (*sysregistriesv2.Registry, string) {
+	var res *sysregistriesv2.Registry
+	resScope := ""
+	for i := range conf.Registries {
+		if i == skip {
+			continue
+		}
+		reg := &conf.Registries[i]
+		scope := canonicalScope(registryScope(reg), aliases)
+		if !ScopeIsNestedInsideScope(refScope, scope) {
+			continue
+		}
+		if res == nil || prefixTakesPrecedence(registryScope(reg), registryScope(res)) {
+			res, resScope = reg, scope
+		}
+	}
+	return res, resScope
+}
+
+// prefixTakesPrecedence returns true if a registry entry with scope prefix governs a reference which is also matched by an entry
+// with scope other, following sysregistriesv2.FindRegistry: the longest prefix wins, and of prefixes with the same length, the first
+// one in sort.Strings order (which sysregistriesv2 uses to order the entries), so *.example.com beats a.example.com.
+// Of entries with the same prefix, sysregistriesv2 only uses the first one, so an equal prefix does not take precedence.
+func prefixTakesPrecedence(prefix, other string) bool {
+	return len(prefix) > len(other) || (len(prefix) == len(other) && prefix < other)
+}
+
+// FindGoverningScope returns the scope (as in sysregistriesv2.Registry.Prefix) of the registry entry in conf which governs
+// the image reference ref (as accepted by ScopeForReference), or "" if there is no such entry.
+// Host names in ref and in conf are compared case-insensitively, after replacing aliases using aliases (a map of alias → canonical
+// host name, may be nil) and a built-in docker.io → registry-1.docker.io alias, so an entry for docker.io governs
+// registry-1.docker.io/library/busybox, and vice versa.
+func FindGoverningScope(conf *sysregistriesv2.V2RegistriesConf, ref string, aliases map[string]string) (string, error) {
+	refScope, err := ScopeForReference(ref)
+	if err != nil {
+		return "", err
+	}
+	aliases = lowercaseAliases(aliases)
+	reg, _ := findGoverningRegistry(conf, canonicalScope(refScope, aliases), aliases, -1)
+	if reg == nil {
+		return "", nil
+	}
+	return registryScope(reg), nil
+}
+
+// ResolveMirrors returns the mirrors, in order, that would be used for the repository of the image reference ref
+// (as accepted by ScopeForReference), based on the registry entry governing it in conf (see FindGoverningScope,
+// including the handling of aliases); the mirror locations are adjusted to refer to the repository.
+// It returns nil if no entry governs ref, or if the governing entry has no mirrors.
+// To resolve many references against the same conf, use a Resolver instead.
+func ResolveMirrors(conf *sysregistriesv2.V2RegistriesConf, ref string, aliases map[string]string) ([]sysregistriesv2.Endpoint, error) {
+	return newResolver(conf, aliases).endpoints(ref)
+}
+
+// resolverEntry is a registry entry indexed by a Resolver.
+type resolverEntry struct {
+	reg   *sysregistriesv2.Registry
+	scope string // Canonical scope of reg
+}
+
+// takesPrecedence returns true if e governs a reference which is also matched by other, per prefixTakesPrecedence.
+func (e resolverEntry) takesPrecedence(other resolverEntry) bool {
+	return prefixTakesPrecedence(registryScope(e.reg), registryScope(other.reg))
+}
+
+// Resolver resolves mirrors of many image references against a single configuration, without having to scan all
+// of its registry entries for every reference like ResolveMirrors does.
+// The configuration must not be modified while the Resolver is used.
+type Resolver struct {
+	aliases   map[string]string
+	scopes    map[string]resolverEntry // Key == canonical non-wildcard scope
+	wildcards map[string]resolverEntry // Key == canonical wildcard scope, i.e. "*." + a lower-case host name suffix
+}
+
+// NewResolver returns a Resolver for conf, which applies the built-in registry aliases like ResolveMirrors does.
+func NewResolver(conf *sysregistriesv2.V2RegistriesConf) *Resolver {
+	return newResolver(conf, nil)
+}
+
+// newResolver returns a Resolver for conf, using aliases as in ResolveMirrors.
+func newResolver(conf *sysregistriesv2.V2RegistriesConf, aliases map[string]string) *Resolver {
+	res := &Resolver{
+		aliases:   lowercaseAliases(aliases),
+		scopes:    map[string]resolverEntry{},
+		wildcards: map[string]resolverEntry{},
+	}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		scope := canonicalScope(registryScope(reg), res.aliases)
+		index := res.scopes
+		if strings.HasPrefix(scope, "*.") {
+			index = res.wildcards
+		}
+		// Entries with different prefixes may have the same canonical scope (e.g. docker.io and registry-1.docker.io); keep the
+		// one findGoverningRegistry would choose. Like there, the first of duplicate entries wins.
+		entry := resolverEntry{reg: reg, scope: scope}
+		if existing, ok := index[scope]; !ok || entry.takesPrecedence(existing) {
+			index[scope] = entry
+		}
+	}
+	return res
+}
+
+// governingEntry returns the entry governing the canonical refScope, with the same precedence as findGoverningRegistry.
+// Instead of checking every entry, it looks up every scope that refScope can be nested inside: ScopeAncestors(refScope),
+// and wildcards for suffixes of its host name.
+func (r *Resolver) governingEntry(refScope string) (resolverEntry, bool) {
+	res, found := resolverEntry{}, false
+	consider := func(entry resolverEntry, ok bool) {
+		if ok && (!found || entry.takesPrecedence(res)) {
+			res, found = entry, true
+		}
+	}
+	for _, scope := range ScopeAncestors(refScope) {
+		entry, ok := r.scopes[scope]
+		consider(entry, ok)
+	}
+	host := refScope[:scopeHostLen(refScope)]
+	for i := strings.IndexByte(host, '.'); i != -1; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		entry, ok := r.wildcards["*."+host]
+		consider(entry, ok)
+	}
+	return res, found
+}
+
+// endpoints implements ResolveMirrors.
+func (r *Resolver) endpoints(ref string) ([]sysregistriesv2.Endpoint, error) {
+	refScope, err := ScopeForReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	refScope = canonicalScope(refScope, r.aliases)
+	entry, ok := r.governingEntry(refScope)
+	if !ok || len(entry.reg.Mirrors) == 0 {
+		return nil, nil
+	}
+	return mirrorsAdjustedForNestedScope(entry.scope, refScope, entry.reg.Mirrors)
+}
+
+// Mirrors returns the locations of the mirrors, in order, that would be used for the repository of the image reference ref,
+// like ResolveMirrors.
+func (r *Resolver) Mirrors(ref string) ([]string, error) {
+	endpoints, err := r.endpoints(ref)
+	if err != nil || endpoints == nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, endpoint := range endpoints {
+		res = append(res, endpoint.Location)
+	}
+	return res, nil
+}
+
+// WouldContactSource returns true if pulling the image reference ref (as accepted by ScopeForReference) using conf could
+// contact the source registry of ref, i.e. unless the registry entry governing ref (see FindGoverningScope) is blocked.
+// A blocked entry is how mirror sets with mirrorSourcePolicy NeverContactSource are represented; its mirrors can still
+// be used, but the source is never contacted. This can be used to verify that a configuration is suitable for air-gapped clusters.
+func WouldContactSource(conf *sysregistriesv2.V2RegistriesConf, ref string) (bool, error) {
+	refScope, err := ScopeForReference(ref)
+	if err != nil {
+		return false, err
+	}
+	reg, _ := findGoverningRegistry(conf, canonicalScope(refScope, nil), nil, -1)
+	return reg == nil || !reg.Blocked, nil
+}
+
+// IsScopeInsecure returns true if scope (as in sysregistriesv2.Registry.Prefix, e.g. a host name, a repository namespace or
+// a wildcard) is insecure in conf: if the registry entry governing it (see FindGoverningScope; the entry need not have
+// the exact scope) is insecure. Like in sysregistriesv2, only the governing entry matters, so a secure entry nested inside
+// an insecure one is secure.
+func IsScopeInsecure(conf *sysregistriesv2.V2RegistriesConf, scope string) bool {
+	reg, _ := findGoverningRegistry(conf, canonicalScope(scope, nil), nil, -1)
+	return reg != nil && reg.Insecure
+}
+
+// IsScopeBlocked returns true if scope (as in sysregistriesv2.Registry.Prefix) is blocked in conf: if the registry entry governing
+// it (see IsScopeInsecure) is blocked.
+func IsScopeBlocked(conf *sysregistriesv2.V2RegistriesConf, scope string) bool {
+	reg, _ := findGoverningRegistry(conf, canonicalScope(scope, nil), nil, -1)
+	return reg != nil && reg.Blocked
+}
+
+// SourcesWithFallback returns the sources (scopes, as in sysregistriesv2.Registry.Prefix, of registry entries with mirrors) in conf
+// for which the source remains reachable if none of the mirrors work: entries which are not blocked, and blocked entries which
+// list their own location as a mirror. Sources of blocked entries without such a mirror (i.e. mirror sets with mirrorSourcePolicy
+// NeverContactSource) are not included. The result is sorted and contains no duplicates.
+func SourcesWithFallback(conf *sysregistriesv2.V2RegistriesConf) []string {
+	unique := map[string]struct{}{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if len(reg.Mirrors) == 0 {
+			continue
+		}
+		if contactsSource(reg) {
+			unique[registryScope(reg)] = struct{}{}
+		}
+	}
+	res := []string{}
+	for scope := range unique {
+		res = append(res, scope)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// contactsSource returns true if the source of reg is contacted, after trying the mirrors: if reg is not blocked, or lists its own
+// location as a mirror.
+func contactsSource(reg *sysregistriesv2.Registry) bool {
+	if !reg.Blocked {
+		return true
+	}
+	for _, mirror := range reg.Mirrors {
+		if reg.Location != "" && ScopeEquals(mirror.Location, reg.Location) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule is a single row of the tabular view of a registries.conf returned by FlattenRules.
+type Rule struct {
+	Source        string // The scope of the registry entry, as in sysregistriesv2.Registry.Prefix
+	Mirror        string // The location of the mirror, or "" for an entry without mirrors
+	Mode          string // The effective pull-from-mirror mode of the mirror (see MirrorMode), or "" for an entry without mirrors
+	Insecure      bool   // Whether Mirror, or Source if Mirror is "", is insecure
+	Blocked       bool   // Whether Source is blocked
+	ContactSource bool   // Whether Source is contacted, after trying the mirrors (see SourcesWithFallback)
+}
+
+// FlattenRules returns the registry entries of conf as a flat list, e.g. for audits: one Rule for each mirror of each entry, and
+// one Rule with an empty Mirror for each blocked or insecure entry without mirrors. Entries without mirrors which are neither
+// blocked nor insecure have no effect, and are not included. A mirror listed more than once with the same mode is only
+// included once.
+// The result is sorted by Source; rules of the same entry are in the order of its mirrors, i.e. the order in which they are tried.
+func FlattenRules(conf *sysregistriesv2.V2RegistriesConf) []Rule {
+	res := []Rule{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		scope := registryScope(reg)
+		if len(reg.Mirrors) == 0 {
+			if reg.Blocked || reg.Insecure {
+				res = append(res, Rule{Source: scope, Insecure: reg.Insecure, Blocked: reg.Blocked, ContactSource: !reg.Blocked})
+			}
+			continue
+		}
+		seen := map[mirrorKey]struct{}{}
+		for _, mirror := range reg.Mirrors {
+			mode := effectiveMirrorMode(reg, mirror)
+			if _, ok := seen[mirrorKey{mirror.Location, mode}]; ok {
+				continue
+			}
+			seen[mirrorKey{mirror.Location, mode}] = struct{}{}
+			res = append(res, Rule{
+				Source:        scope,
+				Mirror:        mirror.Location,
+				Mode:          mode,
+				Insecure:      mirror.Insecure,
+				Blocked:       reg.Blocked,
+				ContactSource: contactsSource(reg),
+			})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Source < res[j].Source
+	})
+	return res
+}
+
+// MirrorMode returns the effective pull-from-mirror mode (sysregistriesv2.MirrorAll, MirrorByDigestOnly or MirrorByTagOnly)
+// of the mirror with location mirror, in the registry entry of conf with scope source (as in sysregistriesv2.Registry.Prefix),
+// and true; or false, if there is no such entry or mirror.
+// A mirror without an explicit pull-from-mirror value follows the mirror-by-digest-only setting of the entry; if the location
+// is listed more than once (e.g. both as a digest-only and a tag-only mirror), the result covers all of them.
+func MirrorMode(conf *sysregistriesv2.V2RegistriesConf, source, mirror string) (string, bool) {
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if registryScope(reg) != source {
+			continue
+		}
+		digest, tag := false, false
+		for _, m := range reg.Mirrors {
+			if m.Location != mirror {
+				continue
+			}
+			mode := effectiveMirrorMode(reg, m)
+			digest = digest || mode != sysregistriesv2.MirrorByTagOnly
+			tag = tag || mode != sysregistriesv2.MirrorByDigestOnly
+		}
+		switch {
+		case digest && tag:
+			return sysregistriesv2.MirrorAll, true
+		case digest:
+			return sysregistriesv2.MirrorByDigestOnly, true
+		case tag:
+			return sysregistriesv2.MirrorByTagOnly, true
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// MirrorsByMode returns the locations of the mirrors, in order, of the registry entry of conf with scope source (as in
+// sysregistriesv2.Registry.Prefix), partitioned by their effective pull-from-mirror mode (see MirrorMode): digest-only,
+// tag-only and "all" mirrors, and true; or false, if there is no such entry. A location listed more than once with the same
+// mode is only returned once.
+func MirrorsByMode(conf *sysregistriesv2.V2RegistriesConf, source string) (digest, tag, all []string, found bool) {
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if registryScope(reg) != source {
+			continue
+		}
+		digest, tag, all = []string{}, []string{}, []string{}
+		for _, m := range reg.Mirrors {
+			switch effectiveMirrorMode(reg, m) {
+			case sysregistriesv2.MirrorByDigestOnly:
+				digest = appendUnique(digest, m.Location)
+			case sysregistriesv2.MirrorByTagOnly:
+				tag = appendUnique(tag, m.Location)
+			default:
+				all = appendUnique(all, m.Location)
+			}
+		}
+		return digest, tag, all, true
+	}
+	return nil, nil, nil, false
+}
+
+// effectiveMirrorMode returns the pull-from-mirror mode of mirror, a mirror of reg: its explicit pull-from-mirror value if any,
+// otherwise the mode implied by the mirror-by-digest-only setting of reg.
+func effectiveMirrorMode(reg *sysregistriesv2.Registry, mirror sysregistriesv2.Endpoint) string {
+	switch {
+	case mirror.PullFromMirror != "":
+		return mirror.PullFromMirror
+	case reg.MirrorByDigestOnly:
+		return sysregistriesv2.MirrorByDigestOnly
+	default:
+		return sysregistriesv2.MirrorAll
+	}
+}
+
+// DetectMirrorCycles returns cycles of registry entries in conf, where a mirror of each entry is governed (see FindGoverningScope)
+// by the next entry, which has mirrors of its own, and the mirror of the last entry is governed by the first one; e.g. if registry-a.com
+// is mirrored to registry-b.com/a, and registry-b.com is mirrored to registry-a.com/b, the result is [[registry-a.com registry-b.com]].
+// A single entry with a mirror nested inside its own scope is a cycle as well.
+// containers/image does not follow mirrors of mirrors, but such topologies are likely a mistake, and a resolver following them
+// would loop. Each cycle is a list of scopes (as in sysregistriesv2.Registry.Prefix), starting with the entry which is
+// first in conf. If cycles overlap, only some of them may be returned.
+func DetectMirrorCycles(conf *sysregistriesv2.V2RegistriesConf) [][]string {
+	links := make([][]int, len(conf.Registries)) // Indices of the entries governing mirrors of each entry, without duplicates
+	for i := range conf.Registries {
+		for _, mirror := range conf.Registries[i].Mirrors {
+			reg, _ := findGoverningRegistry(conf, canonicalScope(mirror.Location, nil), nil, -1)
+			if reg == nil || len(reg.Mirrors) == 0 {
+				continue
+			}
+			for j := range conf.Registries {
+				if &conf.Registries[j] == reg {
+					links[i] = appendUniqueIndex(links[i], j)
+					break
+				}
+			}
+		}
+	}
+
+	res := [][]string{}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(conf.Registries))
+	path := []int{}
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range links[i] {
+			switch state[j] {
+			case unvisited:
+				visit(j)
+			case visiting: // j is on path, so the entries on path from j form a cycle
+				start := len(path) - 1
+				for path[start] != j {
+					start--
+				}
+				entries := path[start:]
+				first := 0
+				for k := range entries {
+					if entries[k] < entries[first] {
+						first = k
+					}
+				}
+				cycle := []string{}
+				for k := range entries {
+					cycle = append(cycle, registryScope(&conf.Registries[entries[(first+k)%len(entries)]]))
+				}
+				res = append(res, cycle)
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+	}
+	for i := range conf.Registries {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+	return res
+}
+
+// appendUniqueIndex returns list with value appended, unless it is already present.
+func appendUniqueIndex(list []int, value int) []int {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
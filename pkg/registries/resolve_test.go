@@ -0,0 +1,592 @@
+package registries
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var resolveTestConfig = sysregistriesv2.V2RegistriesConf{
+	Registries: []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "docker.io"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("hub-mirror.example.com")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/quay")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewTagMirror("mirror.example.com/quay-ns"), NewDigestMirror("mirror-2.example.com/ns")},
+		},
+		{
+			Prefix:  "*.example.com",
+			Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.net")},
+		},
+		{
+			Prefix: "*.foo.example.com",
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "bar.example.com/ns"},
+			Blocked:  true,
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "internal.example.org"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org")},
+		},
+	},
+}
+
+func TestFindGoverningScope(t *testing.T) {
+	aliases := map[string]string{"internal-alias.example.org": "internal.example.org"}
+	for _, tt := range []struct {
+		ref, expected string
+	}{
+		{"quay.io/other/repo:tag", "quay.io"},
+		{"quay.io/ns/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "quay.io/ns"},
+		{"quay.io/ns2/repo", "quay.io"},
+		{"quay.io:443/ns/repo", ""},
+		{"docker.io/library/busybox", "docker.io"},
+		{"registry-1.docker.io/library/busybox", "docker.io"}, // Built-in alias
+		{"internal-alias.example.org/repo", "internal.example.org"},
+		{"internal.example.org/repo", "internal.example.org"},
+		{"a.example.com/repo", "*.example.com"},
+		{"a.foo.example.com/repo", "*.foo.example.com"},
+		{"bar.example.com/ns/repo", "bar.example.com/ns"}, // The longest scope wins
+		{"bar.example.com/other", "*.example.com"},
+		{"example.com/repo", ""},
+		{"registry.example.net/repo", ""},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			res, err := FindGoverningScope(&resolveTestConfig, tt.ref, aliases)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+
+	_, err := FindGoverningScope(&resolveTestConfig, "quay.io/ns/repo:", nil)
+	assert.Error(t, err)
+}
+
+func TestFindGoverningScopeMatchesSysregistriesv2(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{Prefix: "*.example.com", Endpoint: sysregistriesv2.Endpoint{Insecure: true}},
+			{ // As generated for an ImageDigestMirrorSet with mirrorSourcePolicy NeverContactSource
+				Endpoint: sysregistriesv2.Endpoint{Location: "a.example.com"},
+				Blocked:  true,
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.net/a")},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "a.example.com/ns"}, Blocked: true},
+			{Prefix: "*.c.example.com"},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "x.c.example.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "zz.example.com/r"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"}, Blocked: true},
+		},
+	}
+	data, err := MarshalRegistriesConfTOML(&conf)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	confPath := dir + "/registries.conf"
+	require.NoError(t, os.WriteFile(confPath, data, 0o600))
+	sys := &types.SystemContext{SystemRegistriesConfPath: confPath, SystemRegistriesConfDirPath: dir + "/registries.conf.d"}
+	resolver := NewResolver(&conf)
+
+	for _, tt := range []struct {
+		ref, expected string
+	}{
+		{"a.example.com/img", "*.example.com"}, // Same length, *.example.com is first in sorted order
+		{"a.example.com/ns/img", "a.example.com/ns"},
+		{"x.c.example.com/img", "*.c.example.com"},
+		{"y.c.example.com/img", "*.c.example.com"},
+		{"zz.example.com/r/img", "zz.example.com/r"},
+		{"zz.example.com/other", "*.example.com"},
+		{"quay.io/ns/img", "quay.io/ns"},
+		{"quay.io/other/img", "quay.io"},
+		{"registry.example.net/img", ""},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			reg, err := sysregistriesv2.FindRegistry(sys, tt.ref)
+			require.NoError(t, err)
+			expected := ""
+			if reg != nil {
+				expected = reg.Prefix
+			}
+			require.Equal(t, tt.expected, expected, "sysregistriesv2")
+
+			res, err := FindGoverningScope(&conf, tt.ref, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+
+			refScope, err := ScopeForReference(tt.ref)
+			require.NoError(t, err)
+			entry, ok := resolver.governingEntry(canonicalScope(refScope, nil))
+			assert.Equal(t, tt.expected != "", ok)
+			if ok {
+				assert.Equal(t, tt.expected, registryScope(entry.reg))
+			}
+
+			blocked := reg != nil && reg.Blocked
+			assert.Equal(t, blocked, IsScopeBlocked(&conf, refScope))
+			contactsSource, err := WouldContactSource(&conf, tt.ref)
+			require.NoError(t, err)
+			assert.Equal(t, !blocked, contactsSource)
+		})
+	}
+
+	// The entry for a.example.com is not used, so its mirrors are not either.
+	mirrors, err := ResolveMirrors(&conf, "a.example.com/img", nil)
+	require.NoError(t, err)
+	assert.Nil(t, mirrors)
+}
+
+func TestResolveMirrors(t *testing.T) {
+	for _, tt := range []struct {
+		ref      string
+		aliases  map[string]string
+		expected []sysregistriesv2.Endpoint
+	}{
+		{"quay.io/other/repo:tag", nil, []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/quay/other/repo")}},
+		{"quay.io/ns/repo", nil, []sysregistriesv2.Endpoint{NewTagMirror("mirror.example.com/quay-ns/repo"), NewDigestMirror("mirror-2.example.com/ns/repo")}},
+		{"docker.io/library/busybox", nil, []sysregistriesv2.Endpoint{NewDigestMirror("hub-mirror.example.com/library/busybox")}},
+		{"registry-1.docker.io/library/busybox", nil, []sysregistriesv2.Endpoint{NewDigestMirror("hub-mirror.example.com/library/busybox")}},
+		{"a.example.com:5000/ns/repo", nil, []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.net:5000/ns/repo")}},
+		{"a.foo.example.com/repo", nil, nil},          // Governing entry without mirrors
+		{"registry.example.net/repo", nil, nil},       // No governing entry
+		{"internal-alias.example.org/repo", nil, nil}, // Not an alias without the caller-provided table
+		{"internal-alias.example.org/repo", map[string]string{"internal-alias.example.org": "internal.example.org"},
+			[]sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org/repo")}},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			res, err := ResolveMirrors(&resolveTestConfig, tt.ref, tt.aliases)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+
+	_, err := ResolveMirrors(&resolveTestConfig, "quay.io/ns/repo@", nil)
+	assert.Error(t, err)
+}
+
+func TestWouldContactSource(t *testing.T) {
+	for _, tt := range []struct {
+		ref      string
+		expected bool
+	}{
+		{"quay.io/ns/repo:tag", true},               // Mirrors, but the source is not blocked
+		{"registry.example.net/repo", true},         // No governing entry
+		{"bar.example.com/ns/repo", false},          // Blocked
+		{"bar.example.com/ns2/repo", true},          // Not governed by the blocked entry
+		{"mirror-only.example.org/repo", false},     // NeverContactSource, represented as a blocked entry with mirrors
+		{"mirror-only.example.org:5000/repo", true}, // Another registry
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			conf := resolveTestConfig
+			conf.Registries = append(append([]sysregistriesv2.Registry{}, resolveTestConfig.Registries...), sysregistriesv2.Registry{
+				Endpoint: sysregistriesv2.Endpoint{Location: "mirror-only.example.org"},
+				Blocked:  true,
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org/mirror-only")},
+			})
+			res, err := WouldContactSource(&conf, tt.ref)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+
+	_, err := WouldContactSource(&resolveTestConfig, "quay.io/ns/repo:")
+	assert.Error(t, err)
+}
+
+func TestIsScopeInsecureAndBlocked(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{Prefix: "*.insecure.example.com", Endpoint: sysregistriesv2.Endpoint{Insecure: true}},
+			{Prefix: "*.blocked.example.com", Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "secure.insecure.example.com"}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/internal-http-ns", Insecure: true}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/internal-http-ns/secure"}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/blocked-ns"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "docker.io", Insecure: true}, Blocked: true},
+		},
+	}
+	for _, tt := range []struct {
+		scope             string
+		insecure, blocked bool
+	}{
+		{"registry.example.net", false, false},                 // No governing entry
+		{"registry.insecure.example.com", true, false},         // Wildcard
+		{"registry.insecure.example.com/ns", true, false},      // Nested inside a wildcard
+		{"*.sub.insecure.example.com", true, false},            // A wildcard nested inside a wildcard
+		{"insecure.example.com", false, false},                 // Wildcards don't match the domain itself
+		{"secure.insecure.example.com/ns", false, false},       // A more specific entry
+		{"registry.blocked.example.com", false, true},          // Wildcard
+		{"quay.io", false, false},                              // Parent of an insecure namespace
+		{"quay.io/internal-http-ns", true, false},              // Exact match
+		{"quay.io/internal-http-ns/repo", true, false},         // Nested path
+		{"quay.io/internal-http-ns-other", false, false},       // Not nested, only a string prefix
+		{"quay.io/internal-http-ns/secure/repo", false, false}, // A more specific entry
+		{"quay.io/blocked-ns/repo", false, true},               // Nested path
+		{"registry-1.docker.io/library/busybox", true, true},   // The built-in docker.io alias
+		{"registry.insecure.example.com:5000/ns", true, false}, // Wildcards match any port
+	} {
+		t.Run(tt.scope, func(t *testing.T) {
+			assert.Equal(t, tt.insecure, IsScopeInsecure(&conf, tt.scope), "insecure")
+			assert.Equal(t, tt.blocked, IsScopeBlocked(&conf, tt.scope), "blocked")
+		})
+	}
+}
+
+func TestSourcesWithFallback(t *testing.T) {
+	conf := resolveTestConfig
+	conf.Registries = append(append([]sysregistriesv2.Registry{}, resolveTestConfig.Registries...),
+		sysregistriesv2.Registry{ // NeverContactSource
+			Endpoint: sysregistriesv2.Endpoint{Location: "mirror-only.example.org"},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org/mirror-only")},
+		},
+		sysregistriesv2.Registry{ // Blocked, but the source is listed as the last mirror
+			Endpoint: sysregistriesv2.Endpoint{Location: "fallback.example.org/ns"},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.org/fallback"), NewDigestMirror("fallback.example.org/ns")},
+		},
+		sysregistriesv2.Registry{ // Duplicate
+			Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror-2.example.com/quay")},
+		},
+	)
+	assert.Equal(t, []string{"*.example.com", "docker.io", "fallback.example.org/ns", "internal.example.org", "quay.io", "quay.io/ns"}, SourcesWithFallback(&conf))
+
+	assert.Equal(t, []string{}, SourcesWithFallback(&sysregistriesv2.V2RegistriesConf{}))
+}
+
+func TestFlattenRules(t *testing.T) {
+	insecureMirror := NewTagMirror("insecure-mirror.example.com/quay")
+	insecureMirror.Insecure = true
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					NewDigestMirror("mirror-2.example.com/quay"),
+					insecureMirror,
+					NewDigestMirror("mirror-1.example.com/quay"),
+					NewDigestMirror("mirror-2.example.com/quay"), // Duplicate
+				},
+			},
+			{
+				Endpoint:           sysregistriesv2.Endpoint{Location: "legacy.example.com"},
+				MirrorByDigestOnly: true,
+				Mirrors:            []sysregistriesv2.Endpoint{{Location: "mirror.example.com/legacy"}},
+			},
+			{ // NeverContactSource
+				Endpoint: sysregistriesv2.Endpoint{Location: "mirror-only.example.org"},
+				Blocked:  true,
+				Mirrors:  []sysregistriesv2.Endpoint{{Location: "mirror.example.org/mirror-only"}},
+			},
+			{Prefix: "*.blocked.example.com", Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "insecure.example.com", Insecure: true}},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "no-op.example.com"}},
+		},
+	}
+	assert.Equal(t, []Rule{
+		{Source: "*.blocked.example.com", Blocked: true},
+		{Source: "insecure.example.com", Insecure: true, ContactSource: true},
+		{Source: "legacy.example.com", Mirror: "mirror.example.com/legacy", Mode: sysregistriesv2.MirrorByDigestOnly, ContactSource: true},
+		{Source: "mirror-only.example.org", Mirror: "mirror.example.org/mirror-only", Mode: sysregistriesv2.MirrorAll, Blocked: true},
+		{Source: "quay.io", Mirror: "mirror-2.example.com/quay", Mode: sysregistriesv2.MirrorByDigestOnly, ContactSource: true},
+		{Source: "quay.io", Mirror: "insecure-mirror.example.com/quay", Mode: sysregistriesv2.MirrorByTagOnly, Insecure: true, ContactSource: true},
+		{Source: "quay.io", Mirror: "mirror-1.example.com/quay", Mode: sysregistriesv2.MirrorByDigestOnly, ContactSource: true},
+	}, FlattenRules(&conf))
+
+	assert.Equal(t, []Rule{}, FlattenRules(&sysregistriesv2.V2RegistriesConf{}))
+}
+
+func TestResolver(t *testing.T) {
+	resolver := NewResolver(&resolveTestConfig)
+	for _, tt := range []struct {
+		ref      string
+		expected []string
+	}{
+		{"quay.io/other/repo:tag", []string{"mirror.example.com/quay/other/repo"}},
+		{"quay.io/ns/repo", []string{"mirror.example.com/quay-ns/repo", "mirror-2.example.com/ns/repo"}},
+		{"quay.io/ns", []string{"mirror.example.com/quay-ns", "mirror-2.example.com/ns"}},
+		{"quay.io/nsx/repo", []string{"mirror.example.com/quay/nsx/repo"}},
+		{"quay.io:443/ns/repo", nil},
+		{"registry-1.docker.io/library/busybox", []string{"hub-mirror.example.com/library/busybox"}},
+		{"a.example.com:5000/ns/repo", []string{"mirror.example.net:5000/ns/repo"}},
+		{"x.a.example.com/repo", []string{"mirror.example.net/repo"}},
+		{"a.foo.example.com/repo", nil},
+		{"bar.example.com/ns/repo", nil},
+		{"bar.example.com/other", []string{"mirror.example.net/other"}},
+		{"example.com/repo", nil},
+		{"internal-alias.example.org/repo", nil},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			res, err := resolver.Mirrors(tt.ref)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+
+			// The index finds the same entry as a full scan.
+			refScope, err := ScopeForReference(tt.ref)
+			require.NoError(t, err)
+			refScope = canonicalScope(refScope, nil)
+			expectedReg, _ := findGoverningRegistry(&resolveTestConfig, refScope, nil, -1)
+			entry, ok := resolver.governingEntry(refScope)
+			if expectedReg == nil {
+				assert.False(t, ok)
+			} else {
+				require.True(t, ok)
+				assert.Same(t, expectedReg, entry.reg)
+			}
+		})
+	}
+
+	_, err := resolver.Mirrors("quay.io/ns/repo@")
+	assert.Error(t, err)
+
+	// Host names are compared case-insensitively, in the configuration, in references and in aliases.
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "Quay.io/ns"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")},
+			},
+			{
+				Prefix:  "*.Example.com",
+				Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.net")},
+			},
+		},
+	}
+	aliases := map[string]string{"Quay-Alias.example.org": "QUAY.io"}
+	resolver = newResolver(&conf, aliases)
+	for _, tt := range []struct {
+		ref, scope string
+		expected   []string
+	}{
+		{"quay.io/ns/img", "Quay.io/ns", []string{"mirror.example.com/ns/img"}},
+		{"QUAY.IO/ns/img", "Quay.io/ns", []string{"mirror.example.com/ns/img"}},
+		{"quay-alias.example.org/ns/img", "Quay.io/ns", []string{"mirror.example.com/ns/img"}},
+		{"quay.io/NS/img", "", nil}, // Paths are case-sensitive
+		{"A.EXAMPLE.com/img", "*.Example.com", []string{"mirror.example.net/img"}},
+	} {
+		t.Run(tt.ref, func(t *testing.T) {
+			scope, err := FindGoverningScope(&conf, tt.ref, aliases)
+			require.NoError(t, err)
+			assert.Equal(t, tt.scope, scope)
+
+			res, err := resolver.Mirrors(tt.ref)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+
+			endpoints, err := ResolveMirrors(&conf, tt.ref, aliases)
+			require.NoError(t, err)
+			assert.Len(t, endpoints, len(tt.expected))
+		})
+	}
+}
+
+// benchmarkResolveConfig returns a configuration with many registry entries, and references governed by them.
+func benchmarkResolveConfig() (*sysregistriesv2.V2RegistriesConf, []string) {
+	conf := &sysregistriesv2.V2RegistriesConf{}
+	refs := []string{}
+	for i := 0; i < 1000; i++ {
+		conf.Registries = append(conf.Registries, sysregistriesv2.Registry{
+			Endpoint: sysregistriesv2.Endpoint{Location: fmt.Sprintf("registry-%d.example.com/ns", i)},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror(fmt.Sprintf("mirror.example.net/registry-%d", i))},
+		})
+		refs = append(refs, fmt.Sprintf("registry-%d.example.com/ns/repo:tag", i))
+	}
+	return conf, refs
+}
+
+func BenchmarkResolveMirrors(b *testing.B) {
+	conf, refs := benchmarkResolveConfig()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ResolveMirrors(conf, refs[i%len(refs)], nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkResolver(b *testing.B) {
+	conf, refs := benchmarkResolveConfig()
+	resolver := NewResolver(conf)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := resolver.Mirrors(refs[i%len(refs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMirrorMode(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					NewDigestMirror("digest.example.com"),
+					NewTagMirror("tag.example.com"),
+					{Location: "all.example.com", PullFromMirror: sysregistriesv2.MirrorAll},
+					{Location: "default.example.com"},
+					NewDigestMirror("both.example.com"),
+					NewTagMirror("both.example.com"),
+				},
+			},
+			{
+				Prefix:             "*.example.org",
+				MirrorByDigestOnly: true,
+				Mirrors:            []sysregistriesv2.Endpoint{{Location: "legacy.example.com"}},
+			},
+		},
+	}
+	for _, tt := range []struct {
+		source, mirror string
+		expected       string
+		expectedOK     bool
+	}{
+		{"registry-a.com", "digest.example.com", sysregistriesv2.MirrorByDigestOnly, true},
+		{"registry-a.com", "tag.example.com", sysregistriesv2.MirrorByTagOnly, true},
+		{"registry-a.com", "all.example.com", sysregistriesv2.MirrorAll, true},
+		{"registry-a.com", "default.example.com", sysregistriesv2.MirrorAll, true},
+		{"registry-a.com", "both.example.com", sysregistriesv2.MirrorAll, true},
+		{"*.example.org", "legacy.example.com", sysregistriesv2.MirrorByDigestOnly, true},
+		{"registry-a.com", "legacy.example.com", "", false},
+		{"registry-a.com/ns", "digest.example.com", "", false}, // Only an exactly matching entry is used
+		{"registry-b.com", "digest.example.com", "", false},
+	} {
+		t.Run(tt.source+" "+tt.mirror, func(t *testing.T) {
+			res, ok := MirrorMode(&conf, tt.source, tt.mirror)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
+
+func TestMirrorsByMode(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors: []sysregistriesv2.Endpoint{
+					NewDigestMirror("digest-1.example.com"),
+					NewTagMirror("tag.example.com"),
+					{Location: "all.example.com", PullFromMirror: sysregistriesv2.MirrorAll},
+					NewDigestMirror("both.example.com"),
+					{Location: "default.example.com"},
+					NewDigestMirror("digest-2.example.com"),
+					NewTagMirror("both.example.com"),
+					NewDigestMirror("digest-1.example.com"),
+				},
+			},
+			{
+				Prefix:             "*.example.org",
+				MirrorByDigestOnly: true,
+				Mirrors:            []sysregistriesv2.Endpoint{{Location: "legacy.example.com"}},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"}, Blocked: true},
+		},
+	}
+	digest, tag, all, found := MirrorsByMode(&conf, "registry-a.com")
+	assert.True(t, found)
+	assert.Equal(t, []string{"digest-1.example.com", "both.example.com", "digest-2.example.com"}, digest)
+	assert.Equal(t, []string{"tag.example.com", "both.example.com"}, tag)
+	assert.Equal(t, []string{"all.example.com", "default.example.com"}, all)
+
+	digest, tag, all, found = MirrorsByMode(&conf, "*.example.org")
+	assert.True(t, found)
+	assert.Equal(t, []string{"legacy.example.com"}, digest)
+	assert.Equal(t, []string{}, tag)
+	assert.Equal(t, []string{}, all)
+
+	digest, tag, all, found = MirrorsByMode(&conf, "registry-b.com") // An entry without mirrors
+	assert.True(t, found)
+	assert.Equal(t, []string{}, digest)
+	assert.Equal(t, []string{}, tag)
+	assert.Equal(t, []string{}, all)
+
+	for _, source := range []string{"registry-a.com/ns", "registry-c.com"} { // Only an exactly matching entry is used
+		digest, tag, all, found = MirrorsByMode(&conf, source)
+		assert.False(t, found)
+		assert.Nil(t, digest)
+		assert.Nil(t, tag)
+		assert.Nil(t, all)
+	}
+}
+
+func TestDetectMirrorCycles(t *testing.T) {
+	mirrored := func(scope string, mirrors ...string) sysregistriesv2.Registry {
+		res := sysregistriesv2.Registry{Endpoint: sysregistriesv2.Endpoint{Location: scope}}
+		if strings.HasPrefix(scope, "*.") {
+			res = sysregistriesv2.Registry{Prefix: scope}
+		}
+		for _, mirror := range mirrors {
+			res.Mirrors = append(res.Mirrors, NewDigestMirror(mirror))
+		}
+		return res
+	}
+	for _, tt := range []struct {
+		name       string
+		registries []sysregistriesv2.Registry
+		expected   [][]string
+	}{
+		{
+			name:       "no cycles",
+			registries: resolveTestConfig.Registries,
+			expected:   [][]string{},
+		},
+		{
+			name: "chain",
+			registries: []sysregistriesv2.Registry{
+				mirrored("registry-a.com", "registry-b.com/a"),
+				mirrored("registry-b.com", "registry-c.com/b"),
+				{Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"}, Blocked: true}, // No mirrors
+			},
+			expected: [][]string{},
+		},
+		{
+			name: "two entries",
+			registries: []sysregistriesv2.Registry{
+				mirrored("registry-a.com", "registry-b.com/a"),
+				mirrored("registry-b.com", "registry-a.com/b"),
+			},
+			expected: [][]string{{"registry-a.com", "registry-b.com"}},
+		},
+		{
+			name: "nested and wildcard scopes",
+			registries: []sysregistriesv2.Registry{
+				mirrored("unrelated.com", "registry-c.com/x"),
+				mirrored("registry-c.com", "mirror.example.com/c"),
+				mirrored("registry-a.com/ns", "mirror.example.org/a"),
+				mirrored("*.example.com", "registry-a.com/ns/example"),
+				mirrored("*.example.org", "registry-z.com"),
+				mirrored("registry-z.com", "registry-a.com/ns/z"),
+			},
+			expected: [][]string{
+				{"registry-a.com/ns", "*.example.org", "registry-z.com"}, // registry-c.com and *.example.com only lead to the cycle
+			},
+		},
+		{
+			name: "self",
+			registries: []sysregistriesv2.Registry{
+				mirrored("registry-a.com", "mirror.example.com"),
+				mirrored("registry-b.com", "registry-b.com/mirror"),
+			},
+			expected: [][]string{{"registry-b.com"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := DetectMirrorCycles(&sysregistriesv2.V2RegistriesConf{Registries: tt.registries})
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+
+	apicfgv1 "github.com/openshift/api/config/v1"
+)
+
+// rewriteSourceHost returns source with its host name (including any :port), if equal to from, replaced by to,
+// and whether it was rewritten. Wildcard sources match only an equal wildcard from value.
+func rewriteSourceHost(source, from, to string) (string, bool) {
+	host, rest := source, ""
+	if i := strings.IndexByte(source, '/'); i != -1 {
+		host, rest = source[:i], source[i:]
+	}
+	if host != from {
+		return source, false
+	}
+	return to + rest, true
+}
+
+// validateRewriteHosts returns an error if from or to are not valid host[:port] values, or wildcards, for rewriting sources.
+func validateRewriteHosts(from, to string) error {
+	for _, host := range []string{from, to} {
+		if !IsValidRegistriesConfScope(host) || strings.ContainsAny(host, "/@") {
+			return fmt.Errorf("invalid host %#v", host)
+		}
+	}
+	return nil
+}
+
+// RewriteIDMSSourceHost returns copies of idms in which every source with the host name (including any :port) from
+// is rewritten to use the host name to instead, keeping the namespace path intact; e.g. with from old-registry.com and
+// to new-registry.com:5000, old-registry.com/ns/repo becomes new-registry.com:5000/ns/repo. A wildcard source like
+// *.old-registry.com is only rewritten if from is the same wildcard. Mirrors are not changed.
+// It returns an error if from or to are not valid, or if any rewritten source is not a valid scope (per IsValidRegistriesConfScope).
+// idms is not modified.
+func RewriteIDMSSourceHost(idms []*apicfgv1.ImageDigestMirrorSet, from, to string) ([]*apicfgv1.ImageDigestMirrorSet, error) {
+	if err := validateRewriteHosts(from, to); err != nil {
+		return nil, err
+	}
+	res := []*apicfgv1.ImageDigestMirrorSet{}
+	for _, obj := range idms {
+		obj = obj.DeepCopy()
+		for i := range obj.Spec.ImageDigestMirrors {
+			set := &obj.Spec.ImageDigestMirrors[i]
+			if source, ok := rewriteSourceHost(set.Source, from, to); ok {
+				if !IsValidRegistriesConfScope(source) {
+					return nil, fmt.Errorf("ImageDigestMirrorSet %q: rewriting source %#v produces invalid scope %#v", obj.Name, set.Source, source)
+				}
+				set.Source = source
+			}
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
+
+// RewriteITMSSourceHost is RewriteIDMSSourceHost for ImageTagMirrorSet objects.
+func RewriteITMSSourceHost(itms []*apicfgv1.ImageTagMirrorSet, from, to string) ([]*apicfgv1.ImageTagMirrorSet, error) {
+	if err := validateRewriteHosts(from, to); err != nil {
+		return nil, err
+	}
+	res := []*apicfgv1.ImageTagMirrorSet{}
+	for _, obj := range itms {
+		obj = obj.DeepCopy()
+		for i := range obj.Spec.ImageTagMirrors {
+			set := &obj.Spec.ImageTagMirrors[i]
+			if source, ok := rewriteSourceHost(set.Source, from, to); ok {
+				if !IsValidRegistriesConfScope(source) {
+					return nil, fmt.Errorf("ImageTagMirrorSet %q: rewriting source %#v produces invalid scope %#v", obj.Name, set.Source, source)
+				}
+				set.Source = source
+			}
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
@@ -0,0 +1,111 @@
+package registries
+
+import (
+	"testing"
+
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRewriteIDMSSourceHost(t *testing.T) {
+	idms := func(sources ...string) []*apicfgv1.ImageDigestMirrorSet {
+		obj := &apicfgv1.ImageDigestMirrorSet{ObjectMeta: metav1.ObjectMeta{Name: "idms"}}
+		for _, source := range sources {
+			obj.Spec.ImageDigestMirrors = append(obj.Spec.ImageDigestMirrors, apicfgv1.ImageDigestMirrors{
+				Source:  source,
+				Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ns"},
+			})
+		}
+		return []*apicfgv1.ImageDigestMirrorSet{obj}
+	}
+	for _, tt := range []struct {
+		name     string
+		from, to string
+		sources  []string
+		expected []string
+	}{
+		{
+			name:     "host",
+			from:     "old-registry.com",
+			to:       "new-registry.com",
+			sources:  []string{"old-registry.com", "old-registry.com/ns/repo", "old-registry.com:5000/ns", "old-registry.com.example.net/ns", "other.com/old-registry.com"},
+			expected: []string{"new-registry.com", "new-registry.com/ns/repo", "old-registry.com:5000/ns", "old-registry.com.example.net/ns", "other.com/old-registry.com"},
+		},
+		{
+			name:     "ports",
+			from:     "old-registry.com:5000",
+			to:       "new-registry.com:6000",
+			sources:  []string{"old-registry.com:5000/ns", "old-registry.com/ns"},
+			expected: []string{"new-registry.com:6000/ns", "old-registry.com/ns"},
+		},
+		{
+			name:     "wildcards",
+			from:     "*.old-registry.com",
+			to:       "*.new-registry.com",
+			sources:  []string{"*.old-registry.com", "a.old-registry.com/ns"},
+			expected: []string{"*.new-registry.com", "a.old-registry.com/ns"},
+		},
+		{
+			name:     "wildcard source and host from",
+			from:     "old-registry.com",
+			to:       "new-registry.com",
+			sources:  []string{"*.old-registry.com"},
+			expected: []string{"*.old-registry.com"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			in := idms(tt.sources...)
+			res, err := RewriteIDMSSourceHost(in, tt.from, tt.to)
+			require.NoError(t, err)
+			assert.Equal(t, idms(tt.expected...), res)
+			assert.Equal(t, idms(tt.sources...), in) // The input is not modified
+		})
+	}
+
+	for _, tt := range []struct{ from, to string }{
+		{"", "new-registry.com"},
+		{"old-registry.com", ""},
+		{"old-registry.com/ns", "new-registry.com"},
+		{"old-registry.com", "new-registry.com/ns"},
+		{"old-registry.com", "*new-registry.com"},
+	} {
+		_, err := RewriteIDMSSourceHost(idms("old-registry.com/ns"), tt.from, tt.to)
+		assert.Error(t, err, tt)
+	}
+
+	_, err := RewriteIDMSSourceHost(idms("old-registry.com/ns"), "old-registry.com", "*.new-registry.com")
+	assert.EqualError(t, err, `ImageDigestMirrorSet "idms": rewriting source "old-registry.com/ns" produces invalid scope "*.new-registry.com/ns"`)
+}
+
+func TestRewriteITMSSourceHost(t *testing.T) {
+	in := []*apicfgv1.ImageTagMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "old-registry.com:5000/ns", Mirrors: []apicfgv1.ImageMirror{"old-registry.com:5000/mirror"}},
+					{Source: "other.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ns"}},
+				},
+			},
+		},
+	}
+	res, err := RewriteITMSSourceHost(in, "old-registry.com:5000", "new-registry.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*apicfgv1.ImageTagMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+			Spec: apicfgv1.ImageTagMirrorSetSpec{
+				ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+					{Source: "new-registry.com/ns", Mirrors: []apicfgv1.ImageMirror{"old-registry.com:5000/mirror"}}, // Mirrors are unchanged
+					{Source: "other.com/ns", Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ns"}},
+				},
+			},
+		},
+	}, res)
+	assert.Equal(t, "old-registry.com:5000/ns", in[0].Spec.ImageTagMirrors[0].Source)
+
+	_, err = RewriteITMSSourceHost(in, "old-registry.com:5000", "*.new-registry.com")
+	assert.EqualError(t, err, `ImageTagMirrorSet "itms": rewriting source "old-registry.com:5000/ns" produces invalid scope "*.new-registry.com/ns"`)
+}
@@ -0,0 +1,160 @@
+package registries
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// MinimalCoveringScopes returns the scopes from scopes which are not nested inside any other scope in scopes
+// (per ScopeIsNestedInsideScope, so e.g. foo.example.com is covered by *.example.com), i.e. the smallest subset
+// that governs the same images. Duplicates (per ScopeEquals) are removed, keeping the first one in sorted order, and the result is sorted.
+func MinimalCoveringScopes(scopes []string) []string {
+	unique := map[string]struct{}{}
+	for _, scope := range scopes {
+		unique[scope] = struct{}{}
+	}
+	res := []string{}
+	for scope := range unique {
+		covered := false
+		for other := range unique {
+			if other != scope && ScopeIsNestedInsideScope(scope, other) && (!ScopeEquals(scope, other) || other < scope) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			res = append(res, scope)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// FilterByScope returns a copy of conf which contains only the registry entries relevant to scope, i.e. entries with
+// a scope that is equal to scope, nested inside it, or that scope is nested in (per ScopeIsNestedInsideScope); e.g. to show
+// the configuration affecting a single namespace. The other settings of conf, like unqualified-search-registries and
+// short-name-mode, are copied unchanged. conf is not modified.
+func FilterByScope(conf *sysregistriesv2.V2RegistriesConf, scope string) *sysregistriesv2.V2RegistriesConf {
+	res := CopyRegistriesConf(conf)
+	registries := res.Registries
+	res.Registries = nil
+	for i := range registries {
+		regScope := registryScope(&registries[i])
+		if !ScopeIsNestedInsideScope(regScope, scope) && !ScopeIsNestedInsideScope(scope, regScope) {
+			continue
+		}
+		res.Registries = append(res.Registries, registries[i])
+	}
+	return res
+}
+
+// CopyRegistriesConf returns a deep copy of conf, which shares no slices or maps with it (including the mirrors of each
+// registry entry), so that either can be modified without affecting the other.
+// Nil and empty lists of unqualified search registries and credential helpers are preserved, because they are written
+// differently by MarshalRegistriesConfTOML.
+func CopyRegistriesConf(conf *sysregistriesv2.V2RegistriesConf) *sysregistriesv2.V2RegistriesConf {
+	res := &sysregistriesv2.V2RegistriesConf{ShortNameMode: conf.ShortNameMode}
+	if conf.UnqualifiedSearchRegistries != nil {
+		res.UnqualifiedSearchRegistries = append([]string{}, conf.UnqualifiedSearchRegistries...)
+	}
+	if conf.CredentialHelpers != nil {
+		res.CredentialHelpers = append([]string{}, conf.CredentialHelpers...)
+	}
+	if conf.Aliases != nil {
+		res.Aliases = make(map[string]string, len(conf.Aliases))
+		for k, v := range conf.Aliases {
+			res.Aliases[k] = v
+		}
+	}
+	for _, reg := range conf.Registries {
+		if reg.Mirrors != nil {
+			reg.Mirrors = append([]sysregistriesv2.Endpoint{}, reg.Mirrors...)
+		}
+		res.Registries = append(res.Registries, reg)
+	}
+	return res
+}
+
+// ClearManagedRegistries removes, IN PLACE, the registry entries of conf for which predicate returns true (e.g. entries
+// generated by EditRegistriesConfig, as opposed to entries written by an administrator). The surviving entries keep their
+// relative order, and all other settings of conf, like unqualified-search-registries, are not changed.
+func ClearManagedRegistries(conf *sysregistriesv2.V2RegistriesConf, predicate func(sysregistriesv2.Registry) bool) {
+	res := conf.Registries[:0]
+	for _, reg := range conf.Registries {
+		if !predicate(reg) {
+			res = append(res, reg)
+		}
+	}
+	for i := len(res); i < len(conf.Registries); i++ {
+		conf.Registries[i] = sysregistriesv2.Registry{} // Don't keep references to mirrors of removed entries.
+	}
+	conf.Registries = res
+}
+
+// ScopesMatchingWildcard returns the entries of candidates that are governed by wildcard (a *.example.com scope, as used for insecure
+// and blocked scopes), per ScopeIsNestedInsideScope, in their original order; e.g. to preview what a wildcard would match.
+// It returns an error if wildcard is not a valid wildcard scope.
+func ScopesMatchingWildcard(wildcard string, candidates []string) ([]string, error) {
+	if !strings.HasPrefix(wildcard, "*.") || !IsValidRegistriesConfScope(wildcard) {
+		return nil, fmt.Errorf("invalid wildcard scope %#v", wildcard)
+	}
+	res := []string{}
+	for _, candidate := range candidates {
+		if ScopeIsNestedInsideScope(candidate, wildcard) {
+			res = append(res, candidate)
+		}
+	}
+	return res, nil
+}
+
+// ScopeAncestors returns scope followed by the progressively broader scopes it is nested inside, down to its host name,
+// by removing path components from the end; e.g. for quay.io/a/b that is [quay.io/a/b, quay.io/a, quay.io].
+// Wildcard scopes have no path, so the result only contains the wildcard itself.
+func ScopeAncestors(scope string) []string {
+	res := []string{scope}
+	if strings.HasPrefix(scope, "*.") {
+		return res
+	}
+	for i := strings.LastIndexByte(scope, '/'); i != -1; i = strings.LastIndexByte(scope, '/') {
+		scope = scope[:i]
+		res = append(res, scope)
+	}
+	return res
+}
+
+// ReferencedHosts returns the sorted, deduplicated host[:port] values (e.g. quay.io, mirror.example.com:5000) of the sources
+// and mirror locations of the registry entries in conf, i.e. the hosts that pulls using conf might contact; e.g. for network
+// reachability checks. Namespace paths are removed.
+// Sources of blocked entries are never contacted, so they are not included, but their mirrors are. Wildcard scopes have
+// no concrete host, so they are not included either: a wildcard source (which is always also its location) is omitted, and
+// callers that need to know about it must inspect conf.Registries. conf.UnqualifiedSearchRegistries are not included.
+func ReferencedHosts(conf *sysregistriesv2.V2RegistriesConf) []string {
+	hosts := map[string]struct{}{}
+	add := func(location string) {
+		if location == "" || strings.HasPrefix(location, "*.") {
+			return
+		}
+		if i := strings.IndexByte(location, '/'); i != -1 {
+			location = location[:i]
+		}
+		hosts[location] = struct{}{}
+	}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if !reg.Blocked {
+			add(reg.Location)
+		}
+		for _, mirror := range reg.Mirrors {
+			add(mirror.Location)
+		}
+	}
+	res := []string{}
+	for host := range hosts {
+		res = append(res, host)
+	}
+	sort.Strings(res)
+	return res
+}
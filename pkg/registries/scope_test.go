@@ -0,0 +1,222 @@
+package registries
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinimalCoveringScopes(t *testing.T) {
+	for _, tt := range []struct {
+		scopes   []string
+		expected []string
+	}{
+		{nil, []string{}},
+		{[]string{"quay.io/a", "quay.io/b", "quay.io"}, []string{"quay.io"}},
+		{[]string{"quay.io/a", "quay.io/b"}, []string{"quay.io/a", "quay.io/b"}},
+		{[]string{"quay.io/a/b", "quay.io/a", "quay.io/a"}, []string{"quay.io/a"}},
+		{[]string{"quay.io:443/a", "quay.io"}, []string{"quay.io", "quay.io:443/a"}}, // Ports are distinct
+		{[]string{"foo.example.com", "*.example.com", "bar.example.com/ns", "*.foo.example.com", "example.com"}, []string{"*.example.com", "example.com"}},
+		{[]string{"*.foo.example.com", "foo.example.com"}, []string{"*.foo.example.com", "foo.example.com"}},
+		{[]string{"quay.io/ns", "Quay.io/ns", "quay.io/ns/a"}, []string{"Quay.io/ns"}}, // Host names are case-insensitive
+	} {
+		t.Run(fmt.Sprintf("%#v", tt.scopes), func(t *testing.T) {
+			res := MinimalCoveringScopes(tt.scopes)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
+
+func TestFilterByScope(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/quay")},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/team-a"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/team-a")},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/team-a/private"},
+				Blocked:  true,
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/team-b"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/team-b")},
+			},
+			{
+				Prefix:   "*.example.com",
+				Endpoint: sysregistriesv2.Endpoint{Insecure: true},
+			},
+		},
+	}
+	conf.Aliases = map[string]string{"busybox": "docker.io/library/busybox"}
+	res := FilterByScope(&conf, "quay.io/team-a")
+	assert.Equal(t, []sysregistriesv2.Registry{conf.Registries[0], conf.Registries[1], conf.Registries[2]}, res.Registries)
+	assert.Equal(t, conf.UnqualifiedSearchRegistries, res.UnqualifiedSearchRegistries)
+	assert.Equal(t, conf.ShortNameMode, res.ShortNameMode)
+	assert.Equal(t, conf.Aliases, res.Aliases)
+
+	// The result is a copy
+	res.Registries[0].Mirrors[0].Location = "modified.example.com"
+	res.UnqualifiedSearchRegistries[0] = "modified.example.com"
+	res.Aliases["busybox"] = "modified.example.com/busybox"
+	assert.Equal(t, "mirror.example.com/quay", conf.Registries[0].Mirrors[0].Location)
+	assert.Equal(t, "registry.access.redhat.com", conf.UnqualifiedSearchRegistries[0])
+	assert.Equal(t, "docker.io/library/busybox", conf.Aliases["busybox"])
+
+	res = FilterByScope(&conf, "foo.example.com/ns")
+	assert.Equal(t, []sysregistriesv2.Registry{conf.Registries[4]}, res.Registries)
+
+	res = FilterByScope(&conf, "registry.example.net")
+	assert.Empty(t, res.Registries)
+}
+
+func TestCopyRegistriesConf(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		CredentialHelpers:           []string{"containers-auth.json"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry.example.com"}, Blocked: true},
+		},
+	}
+	conf.Aliases = map[string]string{"busybox": "docker.io/library/busybox"}
+	res := CopyRegistriesConf(&conf)
+	assert.Equal(t, &conf, res)
+
+	res.UnqualifiedSearchRegistries[0] = "docker.io"
+	res.CredentialHelpers[0] = "secretservice"
+	res.Aliases["busybox"] = "quay.io/busybox"
+	res.Registries[0].Mirrors[0].Location = "mirror.example.net/ns"
+	res.Registries[0].Mirrors = append(res.Registries[0].Mirrors, NewTagMirror("mirror-2.example.com/ns"))
+	res.Registries[1].Blocked = false
+	assert.Equal(t, []string{"registry.access.redhat.com"}, conf.UnqualifiedSearchRegistries)
+	assert.Equal(t, []string{"containers-auth.json"}, conf.CredentialHelpers)
+	assert.Equal(t, map[string]string{"busybox": "docker.io/library/busybox"}, conf.Aliases)
+	assert.Equal(t, []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")}, conf.Registries[0].Mirrors)
+	assert.True(t, conf.Registries[1].Blocked)
+
+	assert.Equal(t, &sysregistriesv2.V2RegistriesConf{}, CopyRegistriesConf(&sysregistriesv2.V2RegistriesConf{}))
+	// Empty lists are not replaced by nil ones
+	empty := sysregistriesv2.V2RegistriesConf{UnqualifiedSearchRegistries: []string{}, CredentialHelpers: []string{}}
+	assert.Equal(t, &empty, CopyRegistriesConf(&empty))
+}
+
+func TestClearManagedRegistries(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "admin-1.example.com"}, Blocked: true},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "managed-1.example.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/managed-1")},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "admin-2.example.com", Insecure: true}},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "managed-2.example.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/managed-2")},
+			},
+			{Prefix: "*.admin.example.com", Blocked: true},
+		},
+	}
+	ClearManagedRegistries(&conf, func(reg sysregistriesv2.Registry) bool {
+		return len(reg.Mirrors) != 0
+	})
+	assert.Equal(t, sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "admin-1.example.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "admin-2.example.com", Insecure: true}},
+			{Prefix: "*.admin.example.com", Blocked: true},
+		},
+	}, conf)
+
+	ClearManagedRegistries(&conf, func(reg sysregistriesv2.Registry) bool { return false })
+	assert.Len(t, conf.Registries, 3)
+	ClearManagedRegistries(&conf, func(reg sysregistriesv2.Registry) bool { return true })
+	assert.Empty(t, conf.Registries)
+	assert.Equal(t, []string{"registry.access.redhat.com", "docker.io"}, conf.UnqualifiedSearchRegistries)
+}
+
+func TestScopesMatchingWildcard(t *testing.T) {
+	candidates := []string{"foo.example.com", "example.com", "bar.example.com/ns", "*.foo.example.com", "foo.example.com:5000", "example.net", "foo.example.com.evil.net"}
+	res, err := ScopesMatchingWildcard("*.example.com", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo.example.com", "bar.example.com/ns", "*.foo.example.com", "foo.example.com:5000"}, res)
+
+	res, err = ScopesMatchingWildcard("*.example.org", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, res)
+
+	for _, invalid := range []string{"", "example.com", "*.example.com/ns", "*example.com", "foo.*.example.com"} {
+		_, err := ScopesMatchingWildcard(invalid, candidates)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestScopeAncestors(t *testing.T) {
+	for _, tt := range []struct {
+		scope    string
+		expected []string
+	}{
+		{"quay.io/a/b/c", []string{"quay.io/a/b/c", "quay.io/a/b", "quay.io/a", "quay.io"}},
+		{"quay.io", []string{"quay.io"}},
+		{"quay.io:5000/ns", []string{"quay.io:5000/ns", "quay.io:5000"}},
+		{"[fd00::1]:5000/ns", []string{"[fd00::1]:5000/ns", "[fd00::1]:5000"}},
+		{"*.example.com", []string{"*.example.com"}},
+	} {
+		t.Run(tt.scope, func(t *testing.T) {
+			res := ScopeAncestors(tt.scope)
+			assert.Equal(t, tt.expected, res)
+			for _, ancestor := range res {
+				assert.True(t, ScopeIsNestedInsideScope(tt.scope, ancestor), ancestor)
+			}
+		})
+	}
+}
+
+func TestReferencedHosts(t *testing.T) {
+	res := ReferencedHosts(&sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io/ns/repo"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com:5000/ns/repo"), NewDigestMirror("mirror.example.com/ns")},
+			},
+			{
+				Prefix:   "registry.example.com/ns",
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-proxy.example.com/example"},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "quay.io"},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "[fd00::1]:5000/ns"},
+			},
+			{
+				Prefix:  "*.example.org",
+				Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/org")},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "blocked.example.com"},
+				Blocked:  true,
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("blocked-mirror.example.com/blocked")},
+			},
+		},
+	})
+	assert.Equal(t, []string{"[fd00::1]:5000", "blocked-mirror.example.com", "mirror.example.com", "mirror.example.com:5000", "quay.io", "registry-proxy.example.com"}, res)
+
+	assert.Equal(t, []string{}, ReferencedHosts(&sysregistriesv2.V2RegistriesConf{}))
+}
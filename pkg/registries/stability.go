@@ -0,0 +1,169 @@
+package registries
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+)
+
+// decodeRegistriesConf parses the TOML representation of a registries.conf file.
+func decodeRegistriesConf(data []byte) (*sysregistriesv2.V2RegistriesConf, error) {
+	res := sysregistriesv2.V2RegistriesConf{}
+	if _, err := toml.Decode(string(data), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// renderRegistriesConf applies EditRegistriesConfigWithOptions with opts to a fresh copy of template, and returns the TOML representation
+// of the result. template is not modified.
+func renderRegistriesConf(template *sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]byte, error) {
+	templateBytes, err := MarshalRegistriesConfTOML(template)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeRegistriesConf(templateBytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := EditRegistriesConfigWithOptions(config, opts); err != nil {
+		return nil, err
+	}
+	return MarshalRegistriesConfTOML(config)
+}
+
+// AssertStableOutput verifies that editing template using opts produces stable output, i.e. that the operator
+// does not cause unnecessary changes in output objects:
+// - Editing template twice produces byte-for-byte identical registries.conf contents.
+// - The produced registries.conf, after being parsed and serialized again, is byte-for-byte identical.
+// It returns an error describing the first difference found, or any error returned by EditRegistriesConfigWithOptions.
+// template is not modified.
+func AssertStableOutput(template *sysregistriesv2.V2RegistriesConf, opts EditOptions) error {
+	first, err := renderRegistriesConf(template, opts)
+	if err != nil {
+		return err
+	}
+	second, err := renderRegistriesConf(template, opts)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(first, second) {
+		return fmt.Errorf("editing the same template twice produced different outputs:\n%s\nvs.\n%s", first, second)
+	}
+
+	parsed, err := decodeRegistriesConf(first)
+	if err != nil {
+		return fmt.Errorf("parsing the edited configuration: %w", err)
+	}
+	reencoded, err := MarshalRegistriesConfTOML(parsed)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(first, reencoded) {
+		return fmt.Errorf("the edited configuration changes after being parsed and serialized again:\n%s\nvs.\n%s", first, reencoded)
+	}
+	return nil
+}
+
+// VerifyRendered verifies that conf contains everything that editing a configuration using opts configures, e.g. to detect that
+// a rendered registries.conf was modified out-of-band: the registry entries that EditRegistriesConfigWithOptions generates from
+// the inputs in opts (for an empty template) must all be present in conf, with the same location, with the insecure and blocked
+// flags set if the generated entries set them, and with the generated mirrors, in the same relative order, with the same insecure
+// flags and (effective) pull-from-mirror modes.
+// Anything else in conf, e.g. additional entries or mirrors, or flags that are set although the inputs don't require it, is
+// accepted, because it may come from the template the configuration was generated from.
+// It returns an error describing the first mismatch found, or any error returned by EditRegistriesConfigWithOptions.
+func VerifyRendered(conf *sysregistriesv2.V2RegistriesConf, opts EditOptions) error {
+	expected, _, err := PlanRegistriesConfig(&sysregistriesv2.V2RegistriesConf{}, opts)
+	if err != nil {
+		return err
+	}
+	actualRegistries := map[string]*sysregistriesv2.Registry{} // Key == registryScope; like sysregistriesv2, the first of duplicate entries is used
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if _, ok := actualRegistries[registryScope(reg)]; !ok {
+			actualRegistries[registryScope(reg)] = reg
+		}
+	}
+	for i := range expected.Registries {
+		expectedReg := &expected.Registries[i]
+		scope := registryScope(expectedReg)
+		reg, ok := actualRegistries[scope]
+		switch {
+		case !ok:
+			return fmt.Errorf("registry %#v is missing", scope)
+		case reg.Location != expectedReg.Location:
+			return fmt.Errorf("registry %#v has location %#v, expected %#v", scope, reg.Location, expectedReg.Location)
+		case expectedReg.Insecure && !reg.Insecure:
+			return fmt.Errorf("registry %#v is not insecure", scope)
+		case expectedReg.Blocked && !reg.Blocked:
+			return fmt.Errorf("registry %#v is not blocked", scope)
+		}
+		next := 0 // Index of reg.Mirrors from which to look for the next expected mirror
+		for _, expectedMirror := range expectedReg.Mirrors {
+			found := false
+			for ; next < len(reg.Mirrors) && !found; next++ {
+				found = reg.Mirrors[next].Location == expectedMirror.Location
+			}
+			if !found {
+				return fmt.Errorf("registry %#v: mirror %#v is missing or out of order", scope, expectedMirror.Location)
+			}
+			mirror := reg.Mirrors[next-1]
+			if expectedMirror.Insecure && !mirror.Insecure {
+				return fmt.Errorf("registry %#v: mirror %#v is not insecure", scope, mirror.Location)
+			}
+			if mode, expectedMode := effectiveMirrorMode(reg, mirror), effectiveMirrorMode(expectedReg, expectedMirror); mode != expectedMode {
+				return fmt.Errorf("registry %#v: mirror %#v has pull-from-mirror mode %#v, expected %#v", scope, mirror.Location, mode, expectedMode)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyMirrorModes implements EditOptions.VerifyMirrorModes: it checks that the registry entry of every source in the mirror sets
+// of edit, which has been applied to config, has each of the mirrors of the set, with the pull-from-mirror mode implied by the kind
+// of the set and its overrides.
+func verifyMirrorModes(config *sysregistriesv2.V2RegistriesConf, edit *preparedEdit) error {
+	check := func(kind string, mirrorSets []mergedMirrorSet, defaultMode string) error {
+		for _, mirrorSet := range mirrorSets {
+			var reg *sysregistriesv2.Registry
+			for i := range config.Registries {
+				// Like apply, which may have reused an existing entry whose scope differs only in the case of the host name.
+				if lowercaseScopeHost(registryScope(&config.Registries[i])) == lowercaseScopeHost(mirrorSet.source) {
+					reg = &config.Registries[i]
+					break
+				}
+			}
+			if reg == nil {
+				return fmt.Errorf("registry %#v of %s mirrors is missing", mirrorSet.source, kind)
+			}
+		nextMirror:
+			for _, mirror := range mirrorSet.mirrors {
+				expected := defaultMode
+				if override, ok := mirrorSet.pullFromMirror[mirror]; ok {
+					expected = override
+				}
+				mirror, err := rewriteMirror(edit.opts.MirrorRewrite, mirrorSet.source, mirror)
+				if err != nil {
+					return err
+				}
+				if edit.opts.DigestOnlyMirrors && !reg.MirrorByDigestOnly {
+					expected = sysregistriesv2.MirrorByDigestOnly
+				}
+				for _, m := range reg.Mirrors {
+					if m.Location == mirror && effectiveMirrorMode(reg, m) == expected {
+						continue nextMirror
+					}
+				}
+				return fmt.Errorf("registry %#v: %s mirror %#v with pull-from-mirror mode %#v is missing", mirrorSet.source, kind, mirror, expected)
+			}
+		}
+		return nil
+	}
+	if err := check("digest", edit.digestMirrorSets, sysregistriesv2.MirrorByDigestOnly); err != nil {
+		return err
+	}
+	return check("tag", edit.tagMirrorSets, sysregistriesv2.MirrorByTagOnly)
+}
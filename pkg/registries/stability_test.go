@@ -0,0 +1,272 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+func TestAssertStableOutput(t *testing.T) {
+	for _, tt := range editRegistriesConfigTestcases() {
+		t.Run(tt.name, func(t *testing.T) {
+			template := editRegistriesConfigTemplate
+			err := AssertStableOutput(&template, EditOptions{
+				InsecureScopes: tt.insecure,
+				BlockedScopes:  tt.blocked,
+				ICSPRules:      tt.icspRules,
+				IDMSRules:      tt.idmsRules,
+				ITMSRules:      tt.itmsRules,
+			})
+			assert.NoError(t, err)
+			assert.Empty(t, template.Registries) // template is not modified
+		})
+	}
+
+	// Errors from the edit are returned
+	err := AssertStableOutput(&sysregistriesv2.V2RegistriesConf{}, EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PullFromMirrorAnnotation: "invalid"}},
+			},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestVerifyRendered(t *testing.T) {
+	for _, tt := range editRegistriesConfigTestcases() {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := EditOptions{
+				InsecureScopes: tt.insecure,
+				BlockedScopes:  tt.blocked,
+				ICSPRules:      tt.icspRules,
+				IDMSRules:      tt.idmsRules,
+				ITMSRules:      tt.itmsRules,
+			}
+			config := CopyRegistriesConf(&editRegistriesConfigTemplate)
+			_, err := EditRegistriesConfigWithOptions(config, opts)
+			require.NoError(t, err)
+			assert.NoError(t, VerifyRendered(config, opts))
+		})
+	}
+
+	opts := EditOptions{
+		InsecureScopes: []string{"insecure.example.com"},
+		BlockedScopes:  []string{"blocked.example.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.example.com", "insecure.example.com/a"}},
+					},
+				},
+			},
+		},
+	}
+	template := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{{Location: "template-mirror.example.com"}},
+			},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "template.example.com"}, Blocked: true},
+		},
+	}
+	rendered := CopyRegistriesConf(&template)
+	_, err := EditRegistriesConfigWithOptions(rendered, opts)
+	require.NoError(t, err)
+	require.NoError(t, VerifyRendered(rendered, opts)) // Entries and mirrors from the template are accepted
+
+	entry := func(conf *sysregistriesv2.V2RegistriesConf, scope string) *sysregistriesv2.Registry {
+		for i := range conf.Registries {
+			if registryScope(&conf.Registries[i]) == scope {
+				return &conf.Registries[i]
+			}
+		}
+		require.FailNow(t, "missing registry", scope)
+		return nil
+	}
+	for _, tt := range []struct {
+		name     string
+		modify   func(conf *sysregistriesv2.V2RegistriesConf)
+		expected string
+	}{
+		{
+			name:     "missing entry",
+			modify:   func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries = conf.Registries[:0] },
+			expected: `registry "registry-a.com" is missing`,
+		},
+		{
+			name: "location",
+			modify: func(conf *sysregistriesv2.V2RegistriesConf) {
+				conf.Registries[0].Prefix, conf.Registries[0].Location = "registry-a.com", "proxy.registry-a.com"
+			},
+			expected: `registry "registry-a.com" has location "proxy.registry-a.com", expected "registry-a.com"`,
+		},
+		{
+			name: "blocked",
+			modify: func(conf *sysregistriesv2.V2RegistriesConf) {
+				entry(conf, "blocked.example.com").Blocked = false
+			},
+			expected: `registry "blocked.example.com" is not blocked`,
+		},
+		{
+			name: "insecure",
+			modify: func(conf *sysregistriesv2.V2RegistriesConf) {
+				entry(conf, "insecure.example.com").Insecure = false
+			},
+			expected: `registry "insecure.example.com" is not insecure`,
+		},
+		{
+			name: "missing mirror",
+			modify: func(conf *sysregistriesv2.V2RegistriesConf) {
+				conf.Registries[0].Mirrors = conf.Registries[0].Mirrors[:2]
+			},
+			expected: `registry "registry-a.com": mirror "insecure.example.com/a" is missing or out of order`,
+		},
+		{
+			name: "mirror order",
+			modify: func(conf *sysregistriesv2.V2RegistriesConf) {
+				mirrors := conf.Registries[0].Mirrors
+				mirrors[1], mirrors[2] = mirrors[2], mirrors[1]
+			},
+			expected: `registry "registry-a.com": mirror "insecure.example.com/a" is missing or out of order`,
+		},
+		{
+			name:     "insecure mirror",
+			modify:   func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries[0].Mirrors[2].Insecure = false },
+			expected: `registry "registry-a.com": mirror "insecure.example.com/a" is not insecure`,
+		},
+		{
+			name:     "pull-from-mirror",
+			modify:   func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries[0].Mirrors[1].PullFromMirror = "" },
+			expected: `registry "registry-a.com": mirror "mirror-1.example.com" has pull-from-mirror mode "all", expected "digest-only"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := CopyRegistriesConf(rendered)
+			tt.modify(conf)
+			assert.EqualError(t, VerifyRendered(conf, opts), tt.expected)
+		})
+	}
+
+	// Errors from the edit are returned
+	err = VerifyRendered(rendered, EditOptions{InsecureScopes: []string{"*.example.com/ns"}})
+	assert.Error(t, err)
+}
+
+func TestVerifyMirrorModes(t *testing.T) {
+	for _, tt := range editRegistriesConfigTestcases() {
+		for _, variant := range []struct {
+			name   string
+			modify func(opts *EditOptions)
+		}{
+			{"default", func(opts *EditOptions) {}},
+			{"TagMirrorsFirst", func(opts *EditOptions) { opts.TagMirrorsFirst = true }},
+			{"InheritNestedScopeMirrors", func(opts *EditOptions) { opts.InheritNestedScopeMirrors = true }},
+			{"KeepSourceOnlyMirrors", func(opts *EditOptions) { opts.KeepSourceOnlyMirrors = true }},
+			{"DigestOnlyMirrors", func(opts *EditOptions) { opts.DigestOnlyMirrors, opts.ITMSRules = true, nil }},
+		} {
+			t.Run(tt.name+"/"+variant.name, func(t *testing.T) {
+				opts := EditOptions{
+					InsecureScopes:    tt.insecure,
+					BlockedScopes:     tt.blocked,
+					ICSPRules:         tt.icspRules,
+					IDMSRules:         tt.idmsRules,
+					ITMSRules:         tt.itmsRules,
+					VerifyMirrorModes: true,
+				}
+				variant.modify(&opts)
+				config := CopyRegistriesConf(&editRegistriesConfigTemplate)
+				_, err := EditRegistriesConfigWithOptions(config, opts)
+				assert.NoError(t, err)
+			})
+		}
+	}
+
+	opts := EditOptions{
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "idms",
+					Annotations: map[string]string{PullFromMirrorAnnotation: "mirror-2.example.com=all"},
+				},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.example.com", "mirror-2.example.com"}},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-1.example.com"}},
+					},
+				},
+			},
+		},
+	}
+	edit, err := prepareEdit(klog.Background(), opts)
+	require.NoError(t, err)
+	config := sysregistriesv2.V2RegistriesConf{}
+	require.NoError(t, edit.apply(klog.Background(), &config))
+	require.NoError(t, verifyMirrorModes(&config, edit))
+
+	for _, tt := range []struct {
+		name     string
+		modify   func(reg *sysregistriesv2.Registry)
+		expected string
+	}{
+		{
+			name:     "wrong default mode",
+			modify:   func(reg *sysregistriesv2.Registry) { reg.Mirrors[0].PullFromMirror = sysregistriesv2.MirrorByTagOnly },
+			expected: `registry "registry-a.com": digest mirror "mirror-1.example.com" with pull-from-mirror mode "digest-only" is missing`,
+		},
+		{
+			name: "override ignored",
+			modify: func(reg *sysregistriesv2.Registry) {
+				reg.Mirrors[1].PullFromMirror = sysregistriesv2.MirrorByDigestOnly
+			},
+			expected: `registry "registry-a.com": digest mirror "mirror-2.example.com" with pull-from-mirror mode "all" is missing`,
+		},
+		{
+			name:     "tag mirror missing",
+			modify:   func(reg *sysregistriesv2.Registry) { reg.Mirrors = reg.Mirrors[:2] },
+			expected: `registry "registry-a.com": tag mirror "mirror-1.example.com" with pull-from-mirror mode "tag-only" is missing`,
+		},
+		{
+			name:     "entry missing",
+			modify:   func(reg *sysregistriesv2.Registry) { reg.Location = "registry-b.com" },
+			expected: `registry "registry-a.com" of digest mirrors is missing`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := CopyRegistriesConf(&config)
+			tt.modify(&modified.Registries[0])
+			assert.EqualError(t, verifyMirrorModes(modified, edit), tt.expected)
+		})
+	}
+
+	// An existing entry which differs from the source only in the case of the host name is used for the source.
+	config = sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{{Endpoint: sysregistriesv2.Endpoint{Location: "Registry-A.com"}}},
+	}
+	_, err = EditRegistriesConfigWithOptions(&config, EditOptions{
+		IDMSRules:         opts.IDMSRules,
+		ITMSRules:         opts.ITMSRules,
+		VerifyMirrorModes: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, config.Registries, 1)
+	assert.Equal(t, "Registry-A.com", config.Registries[0].Location)
+	assert.Len(t, config.Registries[0].Mirrors, 3)
+}
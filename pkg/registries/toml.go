@@ -0,0 +1,371 @@
+package registries
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+)
+
+// The toml* types are the TOML representation of the sysregistriesv2 types, with the registries.conf key names and options
+// of the sysregistriesv2 types, and with fields in the order in which they are written. This way, the output of
+// MarshalRegistriesConfTOML does not depend on the field order of the sysregistriesv2 types.
+// The TOML encoder writes all plain keys of a table before any sub-tables, so the fields are ordered that way as well.
+
+// tomlEndpoint is the TOML representation of sysregistriesv2.Endpoint.
+type tomlEndpoint struct {
+	Location       string `toml:"location,omitempty"`
+	Insecure       bool   `toml:"insecure,omitempty"`
+	PullFromMirror string `toml:"pull-from-mirror,omitempty"`
+}
+
+// tomlRegistry is the TOML representation of sysregistriesv2.Registry.
+type tomlRegistry struct {
+	Prefix             string         `toml:"prefix"`
+	Location           string         `toml:"location,omitempty"`
+	Insecure           bool           `toml:"insecure,omitempty"`
+	PullFromMirror     string         `toml:"pull-from-mirror,omitempty"`
+	Blocked            bool           `toml:"blocked,omitempty"`
+	MirrorByDigestOnly bool           `toml:"mirror-by-digest-only,omitempty"`
+	Mirrors            []tomlEndpoint `toml:"mirror,omitempty"`
+}
+
+// tomlRegistriesConf is the TOML representation of sysregistriesv2.V2RegistriesConf.
+type tomlRegistriesConf struct {
+	UnqualifiedSearchRegistries []string          `toml:"unqualified-search-registries"`
+	CredentialHelpers           []string          `toml:"credential-helpers"`
+	ShortNameMode               string            `toml:"short-name-mode"`
+	Registries                  []tomlRegistry    `toml:"registry"`
+	Aliases                     map[string]string `toml:"aliases"` // The TOML encoder sorts map keys
+}
+
+// MarshalRegistriesConfTOML returns the TOML representation of conf, as written to /etc/containers/registries.conf.
+// The output is deterministic, and the keys are written in a fixed order independent of the definitions of the
+// sysregistriesv2 types, so that it can be compared byte-for-byte; registries and mirrors are in the order of conf.
+// A nil conf.UnqualifiedSearchRegistries is omitted, while an empty one is written as an empty list; all consumers of
+// registries.conf treat the two differently when combining several configuration files (an empty list overrides
+// the search registries of previously read files), so the distinction is preserved.
+func MarshalRegistriesConfTOML(conf *sysregistriesv2.V2RegistriesConf) ([]byte, error) {
+	res := tomlRegistriesConf{
+		UnqualifiedSearchRegistries: conf.UnqualifiedSearchRegistries,
+		CredentialHelpers:           conf.CredentialHelpers,
+		ShortNameMode:               conf.ShortNameMode,
+		Aliases:                     conf.Aliases,
+	}
+	for _, reg := range conf.Registries {
+		tomlReg := tomlRegistry{
+			Prefix:             reg.Prefix,
+			Location:           reg.Location,
+			Insecure:           reg.Insecure,
+			PullFromMirror:     reg.PullFromMirror,
+			Blocked:            reg.Blocked,
+			MirrorByDigestOnly: reg.MirrorByDigestOnly,
+		}
+		for _, mirror := range reg.Mirrors {
+			tomlReg.Mirrors = append(tomlReg.Mirrors, tomlEndpoint{
+				Location:       mirror.Location,
+				Insecure:       mirror.Insecure,
+				PullFromMirror: mirror.PullFromMirror,
+			})
+		}
+		res.Registries = append(res.Registries, tomlReg)
+	}
+	buf := bytes.Buffer{}
+	if err := toml.NewEncoder(&buf).Encode(res); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ConfigChecksum returns a SHA-256 checksum, as a hex string, of the TOML representation of conf, to cheaply detect changes
+// of the rendered configuration. The order of registry entries does not affect how the configuration is used (the most
+// specific entry for a reference applies), so entries are sorted by scope before hashing, and configurations which only
+// differ in the order of entries have the same checksum; all other ordering, e.g. of mirrors, is significant.
+func ConfigChecksum(conf *sysregistriesv2.V2RegistriesConf) (string, error) {
+	data, err := canonicalRegistriesConfTOML(conf)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// IsEffectivelyDefault returns true if conf, typically the result of editing template, is equivalent to template, ignoring the
+// order of registry entries like ConfigChecksum does; e.g. if all mirror configurations only listed their sources, and were
+// dropped. Then the edited configuration doesn't need to be written at all. It returns false if either configuration can't be
+// represented in TOML.
+func IsEffectivelyDefault(conf, template *sysregistriesv2.V2RegistriesConf) bool {
+	confData, err := canonicalRegistriesConfTOML(conf)
+	if err != nil {
+		return false
+	}
+	templateData, err := canonicalRegistriesConfTOML(template)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(confData, templateData)
+}
+
+// canonicalRegistriesConfTOML returns the TOML representation of conf, with registry entries sorted by scope.
+func canonicalRegistriesConfTOML(conf *sysregistriesv2.V2RegistriesConf) ([]byte, error) {
+	canonical := *conf
+	canonical.Registries = append([]sysregistriesv2.Registry{}, conf.Registries...)
+	sort.SliceStable(canonical.Registries, func(i, j int) bool {
+		return registryScope(&canonical.Registries[i]) < registryScope(&canonical.Registries[j])
+	})
+	return MarshalRegistriesConfTOML(&canonical)
+}
+
+// mirrorSetKindAbbreviations are the short names of the mirror setting object kinds, used in provenance comments.
+var mirrorSetKindAbbreviations = map[string]string{
+	"ImageContentPolicy":       "ICP",
+	"ImageContentSourcePolicy": "ICSP",
+	"ImageDigestMirrorSet":     "IDMS",
+	"ImageTagMirrorSet":        "ITMS",
+}
+
+// registryProvenance returns, for each entry of conf.Registries, a description of the inputs in opts that the entry
+// originates from (e.g. "IDMS my-idms", "blocked scope *.example.com"), or an empty slice for entries that don't
+// originate from opts.
+// An entry with mirrors originates from the mirror sets of its scope or, if there are none, from the mirror sets of the
+// most specific source it is nested in (which its mirrors are generated from).
+func registryProvenance(conf *sysregistriesv2.V2RegistriesConf, opts EditOptions) [][]string {
+	if opts.TreatDefaultPortsAsEqual {
+		opts = opts.withDefaultPortsRemoved()
+	}
+	sourceOrigins := map[string][]string{} // Key == Source
+	forEachMirrorSet(opts, func(kind, name, source string, _ apicfgv1.MirrorSourcePolicy, mirrors []apicfgv1.ImageMirror) {
+		if name == "" {
+			name = "(unnamed)"
+		}
+		sourceOrigins[source] = appendUnique(sourceOrigins[source], fmt.Sprintf("%s %s", mirrorSetKindAbbreviations[kind], name))
+	})
+
+	res := [][]string{}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		scope := registryScope(reg)
+		origins := []string{}
+		if len(reg.Mirrors) != 0 {
+			source, ok := scope, false
+			if _, ok = sourceOrigins[scope]; !ok {
+				for candidate := range sourceOrigins {
+					if ScopeIsNestedInsideScope(scope, candidate) && (!ok || ScopeIsNestedInsideScope(candidate, source)) {
+						source, ok = candidate, true
+					}
+				}
+			}
+			if ok {
+				origins = append(origins, sourceOrigins[source]...)
+			}
+		}
+		for _, blocked := range opts.BlockedScopes {
+			if reg.Blocked && ScopeIsNestedInsideScope(scope, blocked) {
+				origins = appendUnique(origins, "blocked scope "+blocked)
+			}
+		}
+		for _, insecure := range opts.InsecureScopes {
+			if reg.Insecure && ScopeIsNestedInsideScope(scope, insecure) {
+				origins = appendUnique(origins, "insecure scope "+insecure)
+			}
+		}
+		res = append(res, origins)
+	}
+	return res
+}
+
+// appendUnique returns list with value appended, unless it is already present.
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// MarshalRegistriesConfTOMLWithProvenance is MarshalRegistriesConfTOML, which also writes a comment above each registry entry
+// naming the inputs in opts it originates from (e.g. "# from IDMS my-idms, ICSP legacy"), to help finding which objects
+// produced an entry; conf should be the result of editing a configuration using opts.
+// The comments are only informative: the output parses to the same configuration as the output of MarshalRegistriesConfTOML.
+func MarshalRegistriesConfTOMLWithProvenance(conf *sysregistriesv2.V2RegistriesConf, opts EditOptions) ([]byte, error) {
+	data, err := MarshalRegistriesConfTOML(conf)
+	if err != nil {
+		return nil, err
+	}
+	provenance := registryProvenance(conf, opts)
+	// MarshalRegistriesConfTOML writes entries in the order of conf.Registries, each starting with an unindented [[registry]]
+	// header line; string values are always written on a single line, so they can't contain such a line.
+	res := bytes.Buffer{}
+	entry := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.TrimSuffix(line, "\n") == "[[registry]]" {
+			if entry < len(provenance) && len(provenance[entry]) != 0 {
+				res.WriteString("# from " + strings.Join(provenance[entry], ", ") + "\n")
+			}
+			entry++
+		}
+		res.WriteString(line)
+	}
+	return res.Bytes(), nil
+}
+
+// isTOMLCommentLine returns true if line (without the line terminator) contains only a comment.
+func isTOMLCommentLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// isTOMLRegistryHeaderLine returns true if line (without the line terminator) is a [[registry]] header.
+func isTOMLRegistryHeaderLine(line string) bool {
+	return strings.TrimSpace(line) == "[[registry]]"
+}
+
+// registriesConfComments returns the comments of original, a registries.conf file, that MarshalRegistriesConfTOMLPreservingComments
+// preserves: the file header comment, as a list of lines, and the leading comments of registry entries, keyed by the scope of
+// the entry (as in sysregistriesv2.Registry.Prefix). If the registry entries of original can't be matched with [[registry]]
+// header lines, e.g. because they use an inline array, there are no registry comments.
+func registriesConfComments(original []byte) ([]string, map[string][]string, error) {
+	decoded := tomlRegistriesConf{}
+	if _, err := toml.Decode(string(original), &decoded); err != nil {
+		return nil, nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(original), "\r\n", "\n"), "\n")
+
+	header := []string{}
+	firstContent := 0 // Index of the first line that is neither a comment nor blank
+	for firstContent < len(lines) && (isTOMLCommentLine(lines[firstContent]) || strings.TrimSpace(lines[firstContent]) == "") {
+		firstContent++
+	}
+	headerEnd := firstContent
+	if firstContent < len(lines) && isTOMLRegistryHeaderLine(lines[firstContent]) {
+		// Comment lines directly above the first entry are its leading comment, not a part of the header.
+		for headerEnd > 0 && isTOMLCommentLine(lines[headerEnd-1]) {
+			headerEnd--
+		}
+	}
+	for _, line := range lines[:headerEnd] {
+		header = append(header, strings.TrimSpace(line))
+	}
+	for len(header) != 0 && header[len(header)-1] == "" {
+		header = header[:len(header)-1]
+	}
+
+	registryComments := map[string][]string{}
+	entry := 0
+	var comment []string // Comment lines directly above the current line
+	for _, line := range lines {
+		switch {
+		case isTOMLCommentLine(line):
+			comment = append(comment, strings.TrimSpace(line))
+			continue
+		case isTOMLRegistryHeaderLine(line):
+			if entry < len(decoded.Registries) && len(comment) != 0 {
+				reg := decoded.Registries[entry]
+				scope := reg.Prefix
+				if scope == "" {
+					scope = reg.Location
+				}
+				registryComments[scope] = comment
+			}
+			entry++
+		}
+		comment = nil
+	}
+	if entry != len(decoded.Registries) {
+		return header, map[string][]string{}, nil
+	}
+	return header, registryComments, nil
+}
+
+// unknownTOMLTopLevelKeys returns the top-level keys of original, a registries.conf file, that are not represented in
+// sysregistriesv2.V2RegistriesConf (e.g. added in a newer version of containers/image), and that have a scalar or array value,
+// for MarshalRegistriesConfTOMLPreservingComments. Unknown tables, and arrays containing tables, are not included.
+func unknownTOMLTopLevelKeys(original []byte) (map[string]interface{}, error) {
+	md, err := toml.Decode(string(original), &tomlRegistriesConf{})
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if _, err := toml.Decode(string(original), &raw); err != nil {
+		return nil, err
+	}
+	res := map[string]interface{}{}
+	for _, key := range md.Undecoded() {
+		if len(key) != 1 || !isTOMLScalarOrArray(raw[key[0]]) {
+			continue
+		}
+		res[key[0]] = raw[key[0]]
+	}
+	return res, nil
+}
+
+// isTOMLScalarOrArray returns true if value, as decoded by the TOML decoder, is neither a table nor an array containing tables.
+func isTOMLScalarOrArray(value interface{}) bool {
+	switch value := value.(type) {
+	case map[string]interface{}, []map[string]interface{}:
+		return false
+	case []interface{}:
+		for _, element := range value {
+			if !isTOMLScalarOrArray(element) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MarshalRegistriesConfTOMLPreservingComments is MarshalRegistriesConfTOML, which also preserves some comments of original,
+// the registries.conf file (e.g. written by an administrator) that conf was parsed from before it was edited; comments are
+// otherwise lost, because they are not represented in sysregistriesv2.V2RegistriesConf. Only these comments are preserved:
+// - The file header comment: comment lines (and blank lines between them) at the start of the file; if they are directly followed by the first [[registry]] entry, the last contiguous comment lines are the leading comment of that entry instead.
+// - Leading comments of registry entries: comment lines directly above a [[registry]] header line; they are written above the entry with the same scope in conf, if any.
+// All other comments, including comments on the same line as a value and comments inside entries, are lost.
+// Top-level keys of original that are not represented in sysregistriesv2.V2RegistriesConf, e.g. because they were added in
+// a newer version of containers/image, are preserved as well, with their values unchanged, if they are scalars or arrays;
+// other unknown top-level keys (tables), and unknown keys inside registry entries, are lost. The output parses to the same
+// configuration as the output of MarshalRegistriesConfTOML.
+func MarshalRegistriesConfTOMLPreservingComments(conf *sysregistriesv2.V2RegistriesConf, original []byte) ([]byte, error) {
+	header, registryComments, err := registriesConfComments(original)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the original configuration: %w", err)
+	}
+	unknownKeys, err := unknownTOMLTopLevelKeys(original)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the original configuration: %w", err)
+	}
+	data, err := MarshalRegistriesConfTOML(conf)
+	if err != nil {
+		return nil, err
+	}
+	res := bytes.Buffer{}
+	if len(header) != 0 {
+		res.WriteString(strings.Join(header, "\n") + "\n\n")
+	}
+	if len(unknownKeys) != 0 {
+		// Plain keys must precede all tables, so they are written before the output of MarshalRegistriesConfTOML.
+		if err := toml.NewEncoder(&res).Encode(unknownKeys); err != nil { // The TOML encoder sorts map keys
+			return nil, err
+		}
+		res.WriteString("\n")
+	}
+	// See MarshalRegistriesConfTOMLWithProvenance about matching [[registry]] header lines with entries.
+	entry := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.TrimSuffix(line, "\n") == "[[registry]]" {
+			if entry < len(conf.Registries) {
+				for _, comment := range registryComments[registryScope(&conf.Registries[entry])] {
+					res.WriteString(comment + "\n")
+				}
+			}
+			entry++
+		}
+		res.WriteString(line)
+	}
+	return res.Bytes(), nil
+}
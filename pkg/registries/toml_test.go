@@ -0,0 +1,466 @@
+package registries
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var updateGoldenFiles = flag.Bool("update-golden", false, "update the golden files in testdata instead of comparing against them")
+
+func TestMarshalRegistriesConfTOMLGolden(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		CredentialHelpers:           []string{"containers-auth.json"},
+		ShortNameMode:               "enforcing",
+		Registries: []sysregistriesv2.Registry{
+			{
+				Prefix:             "registry-c.com",
+				Endpoint:           sysregistriesv2.Endpoint{Location: "registry-c.com"},
+				MirrorByDigestOnly: true,
+				Mirrors:            []sysregistriesv2.Endpoint{{Location: "mirror.registry-c.com"}},
+			},
+		},
+	}
+	conf.Aliases = map[string]string{"ubi": "registry.access.redhat.com/ubi9", "busybox": "docker.io/library/busybox"}
+	_, err := EditRegistriesConfigWithOptions(&conf, EditOptions{
+		InsecureScopes: []string{"*.insecure.com", "registry-a.com/insecure"},
+		BlockedScopes:  []string{"blocked.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-a.com", "mirror.insecure.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+					},
+				},
+			},
+		},
+		ITMSRules: []*apicfgv1.ImageTagMirrorSet{
+			{
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-a.com"}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	checkGoldenTOML(t, "registries.conf.golden", &conf)
+}
+
+func TestMarshalRegistriesConfTOMLLegacyGolden(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.registry-c.com")},
+			},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&conf, EditOptions{
+		InsecureScopes: []string{"*.insecure.com", "registry-a.com/insecure"},
+		BlockedScopes:  []string{"blocked.com"},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-digest.registry-a.com", "mirror.insecure.com"}},
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PullFromMirrorAnnotation: "mirror.registry-d.com=all"}},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-d.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-d.com"}},
+					},
+				},
+			},
+		},
+		LegacyMirrorFormat: true,
+	})
+	require.NoError(t, err)
+	checkGoldenTOML(t, "registries-legacy.conf.golden", &conf)
+}
+
+// checkGoldenTOML checks that the TOML representation of conf matches the golden file testdata/name (or updates it,
+// with -update-golden), and that it parses into the same configuration.
+func checkGoldenTOML(t *testing.T, name string, conf *sysregistriesv2.V2RegistriesConf) {
+	res, err := MarshalRegistriesConfTOML(conf)
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", name)
+	if *updateGoldenFiles {
+		err := os.WriteFile(golden, res, 0o644)
+		require.NoError(t, err)
+	}
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(res))
+
+	// The output is valid, and parses into the same configuration.
+	parsed, err := decodeRegistriesConf(res)
+	require.NoError(t, err)
+	assert.Equal(t, conf, parsed)
+}
+
+func TestMarshalRegistriesConfTOMLEmptySearchRegistries(t *testing.T) {
+	// An unset list omits the key, so that search registries from other configuration files apply;
+	// an empty list is written explicitly, and overrides them.
+	for _, tt := range []struct {
+		name     string
+		search   []string
+		expected string
+	}{
+		{"nil", nil, "short-name-mode = \"\"\n"},
+		{"empty", []string{}, "unqualified-search-registries = []\nshort-name-mode = \"\"\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := sysregistriesv2.V2RegistriesConf{UnqualifiedSearchRegistries: tt.search}
+			res, err := MarshalRegistriesConfTOML(&conf)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(res))
+
+			parsed, err := decodeRegistriesConf(res)
+			require.NoError(t, err)
+			assert.Equal(t, tt.search, parsed.UnqualifiedSearchRegistries)
+		})
+	}
+}
+
+func TestMarshalRegistriesConfTOMLWithProvenance(t *testing.T) {
+	opts := EditOptions{
+		InsecureScopes: []string{"*.insecure.com"},
+		BlockedScopes:  []string{"registry-a.com/blocked"},
+		ICSPRules: []*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "legacy"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []string{"mirror-icsp.registry-a.com"}},
+					},
+				},
+			},
+		},
+		IDMSRules: []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-idms.registry-a.com"}},
+					},
+				},
+			},
+		},
+	}
+	config := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "template.example.com"}, MirrorByDigestOnly: true},
+		},
+	}
+	_, err := EditRegistriesConfigWithOptions(&config, opts)
+	require.NoError(t, err)
+
+	res, err := MarshalRegistriesConfTOMLWithProvenance(&config, opts)
+	require.NoError(t, err)
+	assert.Equal(t, `unqualified-search-registries = ["registry.access.redhat.com"]
+short-name-mode = ""
+
+[[registry]]
+  prefix = ""
+  location = "template.example.com"
+  mirror-by-digest-only = true
+
+# from ICSP legacy, IDMS my-idms
+[[registry]]
+  prefix = ""
+  location = "registry-a.com"
+
+  [[registry.mirror]]
+    location = "mirror-icsp.registry-a.com"
+    pull-from-mirror = "digest-only"
+
+  [[registry.mirror]]
+    location = "mirror-idms.registry-a.com"
+    pull-from-mirror = "digest-only"
+
+# from ICSP legacy, IDMS my-idms, blocked scope registry-a.com/blocked
+[[registry]]
+  prefix = ""
+  location = "registry-a.com/blocked"
+  blocked = true
+
+  [[registry.mirror]]
+    location = "mirror-icsp.registry-a.com/blocked"
+    pull-from-mirror = "digest-only"
+
+  [[registry.mirror]]
+    location = "mirror-idms.registry-a.com/blocked"
+    pull-from-mirror = "digest-only"
+
+# from insecure scope *.insecure.com
+[[registry]]
+  prefix = "*.insecure.com"
+  insecure = true
+`, string(res))
+
+	// The comments don't affect the parsed configuration.
+	parsed, err := decodeRegistriesConf(res)
+	require.NoError(t, err)
+	assert.Equal(t, config, *parsed)
+	withoutComments, err := MarshalRegistriesConfTOML(&config)
+	require.NoError(t, err)
+	reencoded, err := MarshalRegistriesConfTOML(parsed)
+	require.NoError(t, err)
+	assert.Equal(t, string(withoutComments), string(reencoded))
+}
+
+func TestConfigChecksum(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		Registries: []sysregistriesv2.Registry{
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+				Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror-1.registry-a.com"), NewDigestMirror("mirror-2.registry-a.com")},
+			},
+			{
+				Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"},
+				Blocked:  true,
+			},
+			{
+				Prefix:   "*.example.com",
+				Endpoint: sysregistriesv2.Endpoint{Insecure: true},
+			},
+		},
+	}
+	sum, err := ConfigChecksum(&conf)
+	require.NoError(t, err)
+	assert.Len(t, sum, 64)
+	again, err := ConfigChecksum(&conf)
+	require.NoError(t, err)
+	assert.Equal(t, sum, again)
+
+	// The order of registry entries does not matter
+	reordered := conf
+	reordered.Registries = []sysregistriesv2.Registry{conf.Registries[2], conf.Registries[0], conf.Registries[1]}
+	res, err := ConfigChecksum(&reordered)
+	require.NoError(t, err)
+	assert.Equal(t, sum, res)
+	assert.Equal(t, "registry-a.com", conf.Registries[0].Location) // The input is not modified
+
+	// Other changes do
+	for _, modify := range []func(conf *sysregistriesv2.V2RegistriesConf){
+		func(conf *sysregistriesv2.V2RegistriesConf) {
+			conf.Registries[0].Mirrors = []sysregistriesv2.Endpoint{conf.Registries[0].Mirrors[1], conf.Registries[0].Mirrors[0]}
+		},
+		func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries[1].Blocked = false },
+		func(conf *sysregistriesv2.V2RegistriesConf) {
+			conf.UnqualifiedSearchRegistries = []string{"docker.io", "registry.access.redhat.com"}
+		},
+		func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries = conf.Registries[:2] },
+	} {
+		modified := conf
+		modified.Registries = nil
+		for _, reg := range conf.Registries {
+			reg.Mirrors = append([]sysregistriesv2.Endpoint(nil), reg.Mirrors...)
+			modified.Registries = append(modified.Registries, reg)
+		}
+		modify(&modified)
+		res, err := ConfigChecksum(&modified)
+		require.NoError(t, err)
+		assert.NotEqual(t, sum, res)
+	}
+}
+
+func TestIsEffectivelyDefault(t *testing.T) {
+	template := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"}, Blocked: true},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com", Insecure: true}},
+		},
+	}
+	idms := func(mirrors ...apicfgv1.ImageMirror) []*apicfgv1.ImageDigestMirrorSet {
+		return []*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{{Source: "registry-c.com/ns", Mirrors: mirrors}},
+				},
+			},
+		}
+	}
+
+	assert.True(t, IsEffectivelyDefault(&template, CopyRegistriesConf(&template)))
+
+	reordered := CopyRegistriesConf(&template)
+	reordered.Registries[0], reordered.Registries[1] = reordered.Registries[1], reordered.Registries[0]
+	assert.True(t, IsEffectivelyDefault(reordered, &template))
+
+	// A mirror configuration which only lists the source is dropped
+	res, _, err := PlanRegistriesConfig(&template, EditOptions{IDMSRules: idms("registry-c.com/ns")})
+	require.NoError(t, err)
+	assert.True(t, IsEffectivelyDefault(res, &template))
+
+	res, _, err = PlanRegistriesConfig(&template, EditOptions{IDMSRules: idms("mirror.example.com/ns")})
+	require.NoError(t, err)
+	assert.False(t, IsEffectivelyDefault(res, &template))
+
+	for _, modify := range []func(conf *sysregistriesv2.V2RegistriesConf){
+		func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries[0].Blocked = false },
+		func(conf *sysregistriesv2.V2RegistriesConf) { conf.Registries = conf.Registries[:1] },
+		func(conf *sysregistriesv2.V2RegistriesConf) { conf.UnqualifiedSearchRegistries = nil },
+		func(conf *sysregistriesv2.V2RegistriesConf) { conf.ShortNameMode = "enforcing" },
+	} {
+		modified := CopyRegistriesConf(&template)
+		modify(modified)
+		assert.False(t, IsEffectivelyDefault(modified, &template))
+	}
+}
+
+func TestMarshalRegistriesConfTOMLPreservingComments(t *testing.T) {
+	original := []byte(`# Managed by the cluster administrator.
+# Contact: admin@example.com
+
+unqualified-search-registries = ["registry.access.redhat.com"] # Lost
+
+# Internal registry, plain HTTP
+[[registry]]
+  location = "internal.example.com"
+  insecure = true
+
+  # Lost
+  [[registry.mirror]]
+    location = "mirror.example.com/internal"
+
+# Removed below
+
+[[registry]]
+  location = "removed.example.com"
+
+# All of example.org
+# is blocked
+[[registry]]
+  prefix = "*.example.org"
+  blocked = true
+`)
+	conf := sysregistriesv2.V2RegistriesConf{}
+	_, err := toml.Decode(string(original), &conf)
+	require.NoError(t, err)
+	conf.Registries = append([]sysregistriesv2.Registry{{Endpoint: sysregistriesv2.Endpoint{Location: "added.example.com"}, Blocked: true}},
+		conf.Registries[0], conf.Registries[2])
+
+	res, err := MarshalRegistriesConfTOMLPreservingComments(&conf, original)
+	require.NoError(t, err)
+	assert.Equal(t, `# Managed by the cluster administrator.
+# Contact: admin@example.com
+
+unqualified-search-registries = ["registry.access.redhat.com"]
+short-name-mode = ""
+
+[[registry]]
+  prefix = ""
+  location = "added.example.com"
+  blocked = true
+
+# Internal registry, plain HTTP
+[[registry]]
+  prefix = ""
+  location = "internal.example.com"
+  insecure = true
+
+  [[registry.mirror]]
+    location = "mirror.example.com/internal"
+
+# All of example.org
+# is blocked
+[[registry]]
+  prefix = "*.example.org"
+  blocked = true
+`, string(res))
+	reparsed := sysregistriesv2.V2RegistriesConf{}
+	_, err = toml.Decode(string(res), &reparsed)
+	require.NoError(t, err)
+	assert.Equal(t, conf, reparsed)
+
+	// A comment directly above the first entry is its leading comment
+	res, err = MarshalRegistriesConfTOMLPreservingComments(&conf, []byte("# Internal\n[[registry]]\nlocation = \"internal.example.com\"\n"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "unqualified-search-registries"), string(res))
+	assert.Contains(t, string(res), "# Internal\n[[registry]]\n  prefix = \"\"\n  location = \"internal.example.com\"\n")
+
+	// Entries written as an inline array can't be matched, only the header is preserved
+	res, err = MarshalRegistriesConfTOMLPreservingComments(&conf, []byte("# Header\n\nunqualified-search-registries = []\n# Lost\nregistry = [{location = \"internal.example.com\"}]\n"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "# Header\n\nunqualified-search-registries"), string(res))
+	assert.NotContains(t, string(res), "# Lost")
+
+	_, err = MarshalRegistriesConfTOMLPreservingComments(&conf, []byte("[[registry]"))
+	assert.Error(t, err)
+}
+
+func TestMarshalRegistriesConfTOMLPreservingUnknownKeys(t *testing.T) {
+	original := []byte(`# Header
+
+future-key = "future-value"
+unqualified-search-registries = ["registry.access.redhat.com"]
+future-limits = [1, 2]
+future-nested = [["a", "b"], ["c"]]
+
+[future-table] # Lost
+  key = "value"
+
+[[registry]]
+  location = "internal.example.com"
+  future-registry-key = true # Lost
+`)
+	conf := sysregistriesv2.V2RegistriesConf{}
+	_, err := toml.Decode(string(original), &conf)
+	require.NoError(t, err)
+	conf.Registries = append(conf.Registries, sysregistriesv2.Registry{Endpoint: sysregistriesv2.Endpoint{Location: "added.example.com"}, Blocked: true})
+
+	res, err := MarshalRegistriesConfTOMLPreservingComments(&conf, original)
+	require.NoError(t, err)
+	assert.Equal(t, `# Header
+
+future-key = "future-value"
+future-limits = [1, 2]
+future-nested = [["a", "b"], ["c"]]
+
+unqualified-search-registries = ["registry.access.redhat.com"]
+short-name-mode = ""
+
+[[registry]]
+  prefix = ""
+  location = "internal.example.com"
+
+[[registry]]
+  prefix = ""
+  location = "added.example.com"
+  blocked = true
+`, string(res))
+	reparsed := sysregistriesv2.V2RegistriesConf{}
+	_, err = toml.Decode(string(res), &reparsed)
+	require.NoError(t, err)
+	assert.Equal(t, conf, reparsed)
+
+	// The output is stable across round trips
+	again, err := MarshalRegistriesConfTOMLPreservingComments(&reparsed, res)
+	require.NoError(t, err)
+	assert.Equal(t, string(res), string(again))
+}
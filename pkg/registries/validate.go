@@ -0,0 +1,151 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ValidateInputs validates the mirror setting objects in icspRules, idmsRules and itmsRules as a whole, so that an invalid set of
+// objects can be rejected before it is used. It checks that:
+// - every source is a valid scope (per IsValidRegistriesConfScope), and every mirror is a valid mirror location (per IsValidMirrorLocation);
+// - objects of the same kind don't configure the same source with conflicting explicit mirrorSourcePolicy values;
+// - ImageTagMirrorSet sources don't refer to a digest (per ParseReference), because their tag-only mirrors would never be used.
+// Objects of different kinds may use different mirrorSourcePolicy values for the same source: registries.conf can only block
+// a source as a whole, so NeverContactSource in either kind blocks the source for pulls by digest and by tag alike, regardless of
+// the order of the objects; EditRegistriesConfigWithOptions warns about such sources.
+// All problems are reported in a single aggregated error; nil is returned if the objects are valid.
+// EditRegistriesConfig performs this validation as well, and EditRegistriesConfigWithOptions also validates EditOptions.ICPRules
+// the same way.
+func ValidateInputs(icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy, idmsRules []*apicfgv1.ImageDigestMirrorSet,
+	itmsRules []*apicfgv1.ImageTagMirrorSet) error {
+	return validateInputs(icspRules, idmsRules, itmsRules, nil)
+}
+
+// validateInputs implements ValidateInputs, also validating the ImageContentPolicy objects in icpRules.
+func validateInputs(icspRules []*apioperatorsv1alpha1.ImageContentSourcePolicy, idmsRules []*apicfgv1.ImageDigestMirrorSet,
+	itmsRules []*apicfgv1.ImageTagMirrorSet, icpRules []*apicfgv1.ImageContentPolicy) error {
+	errs := []error{}
+	checkScopes := func(kind, name, source string, mirrors []apicfgv1.ImageMirror) {
+		if !IsValidRegistriesConfScope(source) {
+			errs = append(errs, fmt.Errorf("%s %q: invalid source %#v", kind, name, source))
+		}
+		for _, mirror := range mirrors {
+			if !IsValidMirrorLocation(string(mirror)) {
+				errs = append(errs, fmt.Errorf("%s %q: invalid mirror %#v of source %#v", kind, name, mirror, source))
+			}
+		}
+	}
+	type policyOrigin struct {
+		name   string
+		policy apicfgv1.MirrorSourcePolicy
+	}
+	// checkPolicy records the explicit mirrorSourcePolicy of source in name, and reports a conflict with previously recorded values in policies.
+	checkPolicy := func(policies map[string]policyOrigin, kind, name, source string, policy apicfgv1.MirrorSourcePolicy) {
+		if policy == "" {
+			return
+		}
+		if existing, ok := policies[source]; ok {
+			if existing.policy != policy {
+				errs = append(errs, fmt.Errorf("%s %q and %q: conflicting mirrorSourcePolicy values %s and %s for source %#v",
+					kind, existing.name, name, existing.policy, policy, source))
+			}
+			return
+		}
+		policies[source] = policyOrigin{name: name, policy: policy}
+	}
+
+	for _, icsp := range icspRules {
+		for _, set := range icsp.Spec.RepositoryDigestMirrors {
+			imgMirrors := []apicfgv1.ImageMirror{}
+			for _, m := range set.Mirrors {
+				imgMirrors = append(imgMirrors, apicfgv1.ImageMirror(m))
+			}
+			checkScopes("ImageContentSourcePolicy", icsp.Name, set.Source, imgMirrors)
+		}
+	}
+	for _, icp := range icpRules {
+		for _, set := range icp.Spec.RepositoryDigestMirrors {
+			checkScopes("ImageContentPolicy", icp.Name, set.Source, imageContentPolicyMirrors(set))
+		}
+	}
+	digestPolicies := map[string]policyOrigin{}
+	for _, idms := range idmsRules {
+		for _, set := range idms.Spec.ImageDigestMirrors {
+			checkScopes("ImageDigestMirrorSet", idms.Name, set.Source, set.Mirrors)
+			checkPolicy(digestPolicies, "ImageDigestMirrorSet", idms.Name, set.Source, set.MirrorSourcePolicy)
+		}
+	}
+	tagPolicies := map[string]policyOrigin{}
+	for _, itms := range itmsRules {
+		for _, set := range itms.Spec.ImageTagMirrors {
+			checkScopes("ImageTagMirrorSet", itms.Name, set.Source, set.Mirrors)
+			checkPolicy(tagPolicies, "ImageTagMirrorSet", itms.Name, set.Source, set.MirrorSourcePolicy)
+			if IsValidRegistriesConfScope(set.Source) && strings.Contains(set.Source, "@") {
+				if _, _, _, digest, err := ParseReference(set.Source); err != nil {
+					errs = append(errs, fmt.Errorf("ImageTagMirrorSet %q: invalid source %#v: %w", itms.Name, set.Source, err))
+				} else if digest != "" {
+					errs = append(errs, fmt.Errorf("ImageTagMirrorSet %q: source %#v refers to a digest, so its tag mirrors would never be used", itms.Name, set.Source))
+				}
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ValidateNoDuplicateScopes returns an error naming every scope (as in sysregistriesv2.Registry.Prefix) that is used by more than
+// one registry entry of conf, or nil if all scopes are unique. sysregistriesv2 rejects such entries if their insecure or blocked
+// settings differ, and otherwise silently uses only the first one, so they are a mistake either way.
+// EditRegistriesConfig and EditRegistriesConfigWithOptions check their result this way.
+func ValidateNoDuplicateScopes(conf *sysregistriesv2.V2RegistriesConf) error {
+	errs := []error{}
+	counts := map[string]int{}
+	for i := range conf.Registries {
+		scope := registryScope(&conf.Registries[i])
+		counts[scope]++
+		if counts[scope] == 2 {
+			errs = append(errs, fmt.Errorf("registry %#v is defined more than once", scope))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// LoadTemplate parses data, a registries.conf file to be used as the template for EditRegistriesConfig (e.g. provided by an
+// administrator instead of the built-in one), and validates it:
+// - every registry entry has a valid scope (per IsValidRegistriesConfScope), a valid location, if any, and valid mirror locations (per IsValidMirrorLocation);
+// - no scope is used by more than one entry (see ValidateNoDuplicateScopes), e.g. with conflicting insecure or blocked settings, because EditRegistriesConfig would reject the result.
+// All problems are reported in a single aggregated error.
+func LoadTemplate(data []byte) (*sysregistriesv2.V2RegistriesConf, error) {
+	res, err := decodeRegistriesConf(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the template: %w", err)
+	}
+	errs := []error{}
+	for i := range res.Registries {
+		reg := &res.Registries[i]
+		scope := registryScope(reg)
+		if !IsValidRegistriesConfScope(scope) {
+			errs = append(errs, fmt.Errorf("registry at index %d: invalid scope %#v", i, scope))
+			continue
+		}
+		if reg.Location != "" && !IsValidMirrorLocation(reg.Location) {
+			errs = append(errs, fmt.Errorf("registry %#v: invalid location %#v", scope, reg.Location))
+		}
+		for _, mirror := range reg.Mirrors {
+			if !IsValidMirrorLocation(mirror.Location) {
+				errs = append(errs, fmt.Errorf("registry %#v: invalid mirror %#v", scope, mirror.Location))
+			}
+		}
+	}
+	if err := ValidateNoDuplicateScopes(res); err != nil {
+		errs = append(errs, err)
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return res, nil
+}
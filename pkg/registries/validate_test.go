@@ -0,0 +1,272 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateInputs(t *testing.T) {
+	err := ValidateInputs(nil, nil, nil)
+	assert.NoError(t, err)
+
+	// Valid inputs, including a wildcard source and the same source in both object kinds with different policies
+	err = ValidateInputs(
+		[]*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "registry-a.com/ns", Mirrors: []string{"mirror.registry-a.com/ns"}},
+					},
+				},
+			},
+		},
+		[]*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms-1"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						{Source: "*.registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}},
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms-2"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-a.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+						{Source: "*.registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror-2.registry-b.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+					},
+				},
+			},
+		},
+		[]*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-a.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+					},
+				},
+			},
+		})
+	assert.NoError(t, err)
+
+	// With different policies in the two kinds, NeverContactSource blocks the source, regardless of which kind uses it
+	// and of the order in which the mirrors are added.
+	for _, digestPolicy := range []apicfgv1.MirrorSourcePolicy{apicfgv1.AllowContactingSource, apicfgv1.NeverContactSource} {
+		tagPolicy := apicfgv1.NeverContactSource
+		if digestPolicy == apicfgv1.NeverContactSource {
+			tagPolicy = apicfgv1.AllowContactingSource
+		}
+		idms := []*apicfgv1.ImageDigestMirrorSet{
+			{
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-a.com"}, MirrorSourcePolicy: digestPolicy},
+					},
+				},
+			},
+		}
+		itms := []*apicfgv1.ImageTagMirrorSet{
+			{
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"mirror-tag.registry-a.com"}, MirrorSourcePolicy: tagPolicy},
+					},
+				},
+			},
+		}
+		require.NoError(t, ValidateInputs(nil, idms, itms), digestPolicy)
+		for _, tagMirrorsFirst := range []bool{false, true} {
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := EditRegistriesConfigWithOptions(&config, EditOptions{IDMSRules: idms, ITMSRules: itms, TagMirrorsFirst: tagMirrorsFirst})
+			require.NoError(t, err)
+			require.Len(t, config.Registries, 1)
+			assert.True(t, config.Registries[0].Blocked, "%s, TagMirrorsFirst %v", digestPolicy, tagMirrorsFirst)
+		}
+	}
+
+	// All errors are reported
+	err = ValidateInputs(
+		[]*apioperatorsv1alpha1.ImageContentSourcePolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "icsp"},
+				Spec: apioperatorsv1alpha1.ImageContentSourcePolicySpec{
+					RepositoryDigestMirrors: []apioperatorsv1alpha1.RepositoryDigestMirrors{
+						{Source: "*.registry-a.com/ns", Mirrors: []string{"mirror.registry-a.com/ns"}},
+					},
+				},
+			},
+		},
+		[]*apicfgv1.ImageDigestMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms-1"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"*.mirror.registry-b.com"}, MirrorSourcePolicy: apicfgv1.NeverContactSource},
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idms-2"},
+				Spec: apicfgv1.ImageDigestMirrorSetSpec{
+					ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+						{Source: "registry-b.com", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-b.com"}, MirrorSourcePolicy: apicfgv1.AllowContactingSource},
+					},
+				},
+			},
+		},
+		[]*apicfgv1.ImageTagMirrorSet{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "itms"},
+				Spec: apicfgv1.ImageTagMirrorSetSpec{
+					ImageTagMirrors: []apicfgv1.ImageTagMirrors{
+						{Source: "registry-c.com/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-c.com/repo"}},
+						{Source: "registry-d.com/repo@latest", Mirrors: []apicfgv1.ImageMirror{"mirror.registry-d.com/repo"}},
+					},
+				},
+			},
+		})
+	assert.EqualError(t, err, "["+
+		`ImageContentSourcePolicy "icsp": invalid source "*.registry-a.com/ns", `+
+		`ImageDigestMirrorSet "idms-1": invalid mirror "*.mirror.registry-b.com" of source "registry-b.com", `+
+		`ImageDigestMirrorSet "idms-1" and "idms-2": conflicting mirrorSourcePolicy values NeverContactSource and AllowContactingSource for source "registry-b.com", `+
+		`ImageTagMirrorSet "itms": source "registry-c.com/repo@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" refers to a digest, so its tag mirrors would never be used, `+
+		`ImageTagMirrorSet "itms": invalid source "registry-d.com/repo@latest": invalid reference "registry-d.com/repo@latest": invalid digest "latest"`+
+		"]")
+
+	// EditRegistriesConfig uses the same validation, and doesn't modify the configuration
+	config := sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfig(&config, nil, nil, nil, []*apicfgv1.ImageDigestMirrorSet{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "idms"},
+			Spec: apicfgv1.ImageDigestMirrorSetSpec{
+				ImageDigestMirrors: []apicfgv1.ImageDigestMirrors{
+					{Source: "registry-a.com", Mirrors: []apicfgv1.ImageMirror{"*.mirror.registry-a.com"}},
+				},
+			},
+		},
+	}, nil)
+	require.Error(t, err)
+	assert.Empty(t, config.Registries)
+}
+
+func TestValidateNoDuplicateScopes(t *testing.T) {
+	conf := sysregistriesv2.V2RegistriesConf{
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"}},
+			{Prefix: "registry-a.com/ns", Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"}}, // Same location, different scope
+			{Prefix: "*.example.com"},
+			{Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com/ns"}},
+		},
+	}
+	assert.EqualError(t, ValidateNoDuplicateScopes(&conf), `registry "registry-a.com/ns" is defined more than once`)
+
+	conf.Registries = append(conf.Registries,
+		sysregistriesv2.Registry{Prefix: "*.example.com", Blocked: true},
+		sysregistriesv2.Registry{Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com/ns"}},
+	)
+	assert.EqualError(t, ValidateNoDuplicateScopes(&conf), `[registry "registry-a.com/ns" is defined more than once, registry "*.example.com" is defined more than once]`)
+
+	assert.NoError(t, ValidateNoDuplicateScopes(&sysregistriesv2.V2RegistriesConf{Registries: conf.Registries[:3]}))
+	assert.NoError(t, ValidateNoDuplicateScopes(&sysregistriesv2.V2RegistriesConf{}))
+
+	// EditRegistriesConfig checks its result
+	err := EditRegistriesConfig(&conf, nil, []string{"registry-b.com"}, nil, nil, nil)
+	assert.EqualError(t, err, `[registry "registry-a.com/ns" is defined more than once, registry "*.example.com" is defined more than once]`)
+}
+
+func TestLoadTemplate(t *testing.T) {
+	res, err := LoadTemplate([]byte(`unqualified-search-registries = ["registry.access.redhat.com", "docker.io"]
+
+[[registry]]
+  location = "internal.example.com"
+  insecure = true
+
+[[registry]]
+  prefix = "*.example.org"
+  blocked = true
+
+[[registry]]
+  prefix = "quay.io/ns"
+  location = "quay.example.com/ns"
+
+  [[registry.mirror]]
+    location = "mirror.example.com/ns"
+    pull-from-mirror = "digest-only"
+`))
+	require.NoError(t, err)
+	expected := &sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: []string{"registry.access.redhat.com", "docker.io"},
+		Registries: []sysregistriesv2.Registry{
+			{Endpoint: sysregistriesv2.Endpoint{Location: "internal.example.com", Insecure: true}},
+			{Prefix: "*.example.org", Blocked: true},
+			{Prefix: "quay.io/ns", Endpoint: sysregistriesv2.Endpoint{Location: "quay.example.com/ns"}, Mirrors: []sysregistriesv2.Endpoint{NewDigestMirror("mirror.example.com/ns")}},
+		},
+	}
+	assert.Equal(t, expected, res)
+
+	res, err = LoadTemplate([]byte{})
+	require.NoError(t, err)
+	assert.Equal(t, &sysregistriesv2.V2RegistriesConf{}, res)
+
+	for _, tt := range []struct {
+		name, data string
+		errors     []string
+	}{
+		{
+			name:   "invalid TOML",
+			data:   "[[registry]",
+			errors: []string{"parsing the template: "},
+		},
+		{
+			name: "invalid scopes",
+			data: `[[registry]]
+  prefix = "*.example.com/ns"
+[[registry]]
+  insecure = true
+[[registry]]
+  location = "registry.example.com"
+  [[registry.mirror]]
+    location = "*.mirror.example.com"
+[[registry]]
+  prefix = "*.example.org"
+  location = "*.example.org"
+`,
+			errors: []string{
+				`registry at index 0: invalid scope "*.example.com/ns"`,
+				`registry at index 1: invalid scope ""`,
+				`registry "registry.example.com": invalid mirror "*.mirror.example.com"`,
+				`registry "*.example.org": invalid location "*.example.org"`,
+			},
+		},
+		{
+			name: "conflicting flags",
+			data: `[[registry]]
+  location = "registry.example.com"
+  insecure = true
+[[registry]]
+  location = "registry.example.com"
+  blocked = true
+`,
+			errors: []string{`registry "registry.example.com" is defined more than once`},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := LoadTemplate([]byte(tt.data))
+			assert.Nil(t, res)
+			require.Error(t, err)
+			for _, e := range tt.errors {
+				assert.Contains(t, err.Error(), e)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package registries
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	apioperatorsv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// yamlObject is the part of a decoded object used to determine its kind; Items is only used for lists.
+type yamlObject struct {
+	metav1.TypeMeta `json:",inline"`
+	Items           []json.RawMessage `json:"items,omitempty"`
+}
+
+// addYAMLObject decodes the JSON representation of a single object (or of a list of objects) in data, and adds it to the rules
+// in opts if it is a mirror setting object; if it isn't, it returns the kind of the object, or of the first unknown object in a list.
+func addYAMLObject(opts *EditOptions, data []byte) (string, error) {
+	obj := yamlObject{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return "", err
+	}
+	switch obj.Kind {
+	case "ImageContentSourcePolicy":
+		icsp := &apioperatorsv1alpha1.ImageContentSourcePolicy{}
+		if err := json.Unmarshal(data, icsp); err != nil {
+			return "", err
+		}
+		opts.ICSPRules = append(opts.ICSPRules, icsp)
+	case "ImageContentPolicy":
+		icp := &apicfgv1.ImageContentPolicy{}
+		if err := json.Unmarshal(data, icp); err != nil {
+			return "", err
+		}
+		opts.ICPRules = append(opts.ICPRules, icp)
+	case "ImageDigestMirrorSet":
+		idms := &apicfgv1.ImageDigestMirrorSet{}
+		if err := json.Unmarshal(data, idms); err != nil {
+			return "", err
+		}
+		opts.IDMSRules = append(opts.IDMSRules, idms)
+	case "ImageTagMirrorSet":
+		itms := &apicfgv1.ImageTagMirrorSet{}
+		if err := json.Unmarshal(data, itms); err != nil {
+			return "", err
+		}
+		opts.ITMSRules = append(opts.ITMSRules, itms)
+	case "List":
+		unknown := ""
+		for _, item := range obj.Items {
+			kind, err := addYAMLObject(opts, item)
+			if err != nil {
+				return "", err
+			}
+			if unknown == "" {
+				unknown = kind
+			}
+		}
+		return unknown, nil
+	default:
+		return obj.Kind, nil
+	}
+	return "", nil
+}
+
+// EditRegistriesConfigFromYAML edits, IN PLACE, the /etc/containers/registries.conf configuration provided in config, like
+// EditRegistriesConfig, using the ImageContentSourcePolicy, ImageContentPolicy, ImageDigestMirrorSet and ImageTagMirrorSet objects in data,
+// a stream of YAML (or JSON) documents, e.g. manifests from a GitOps repository. Objects in documents of kind List are used as well.
+// Documents with other kinds are ignored.
+func EditRegistriesConfigFromYAML(config *sysregistriesv2.V2RegistriesConf, data []byte) error {
+	_, err := EditRegistriesConfigFromYAMLWithOptions(config, data, EditOptions{})
+	return err
+}
+
+// EditRegistriesConfigFromYAMLWithOptions is EditRegistriesConfigFromYAML, which uses the options in opts like
+// EditRegistriesConfigWithOptions; the objects decoded from data are used in addition to the rules in opts.
+// If opts.Strict is set, documents with other kinds are rejected instead of being ignored.
+func EditRegistriesConfigFromYAMLWithOptions(config *sysregistriesv2.V2RegistriesConf, data []byte, opts EditOptions) ([]string, error) {
+	opts.ICSPRules = append([]*apioperatorsv1alpha1.ImageContentSourcePolicy{}, opts.ICSPRules...)
+	opts.IDMSRules = append([]*apicfgv1.ImageDigestMirrorSet{}, opts.IDMSRules...)
+	opts.ITMSRules = append([]*apicfgv1.ImageTagMirrorSet{}, opts.ITMSRules...)
+	opts.ICPRules = append([]*apicfgv1.ImageContentPolicy{}, opts.ICPRules...)
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for i := 0; ; i++ {
+		doc := json.RawMessage{}
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decoding document %d: %w", i, err)
+		}
+		if len(doc) == 0 || string(doc) == "null" {
+			continue // An empty document
+		}
+		kind, err := addYAMLObject(&opts, doc)
+		if err != nil {
+			return nil, fmt.Errorf("decoding document %d: %w", i, err)
+		}
+		if kind != "" && opts.Strict {
+			return nil, fmt.Errorf("document %d: unsupported kind %#v", i, kind)
+		}
+	}
+	return EditRegistriesConfigWithOptions(config, opts)
+}
@@ -0,0 +1,114 @@
+package registries
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlTestManifests = `# Manifests from a GitOps repository
+apiVersion: config.openshift.io/v1
+kind: ImageDigestMirrorSet
+metadata:
+  name: idms
+spec:
+  imageDigestMirrors:
+  - source: registry-a.com
+    mirrors:
+    - mirror-digest.registry-a.com
+    mirrorSourcePolicy: NeverContactSource
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+data:
+  foo: bar
+---
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: config.openshift.io/v1
+  kind: ImageTagMirrorSet
+  metadata:
+    name: itms
+  spec:
+    imageTagMirrors:
+    - source: registry-a.com
+      mirrors:
+      - mirror-tag.registry-a.com
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: unrelated
+---
+{"apiVersion": "operator.openshift.io/v1alpha1", "kind": "ImageContentSourcePolicy", "metadata": {"name": "icsp"},
+ "spec": {"repositoryDigestMirrors": [{"source": "registry-b.com", "mirrors": ["mirror.registry-b.com"]}]}}
+`
+
+func TestEditRegistriesConfigFromYAML(t *testing.T) {
+	config := sysregistriesv2.V2RegistriesConf{}
+	err := EditRegistriesConfigFromYAML(&config, []byte(yamlTestManifests))
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-a.com"},
+			Blocked:  true,
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror-digest.registry-a.com"), NewTagMirror("mirror-tag.registry-a.com")},
+		},
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-b.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{NewDigestMirror("mirror.registry-b.com")},
+		},
+	}, config.Registries)
+
+	// Options are used as well
+	config = sysregistriesv2.V2RegistriesConf{}
+	_, err = EditRegistriesConfigFromYAMLWithOptions(&config, []byte(yamlTestManifests), EditOptions{BlockedScopes: []string{"registry-c.com"}})
+	require.NoError(t, err)
+	assert.Len(t, config.Registries, 3)
+
+	// ImageContentPolicy objects are used as well
+	config = sysregistriesv2.V2RegistriesConf{}
+	err = EditRegistriesConfigFromYAML(&config, []byte(`apiVersion: config.openshift.io/v1
+kind: ImageContentPolicy
+metadata:
+  name: icp
+spec:
+  repositoryDigestMirrors:
+  - source: registry-c.com
+    mirrors:
+    - mirror.registry-c.com
+    allowMirrorByTags: true
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []sysregistriesv2.Registry{
+		{
+			Endpoint: sysregistriesv2.Endpoint{Location: "registry-c.com"},
+			Mirrors:  []sysregistriesv2.Endpoint{{Location: "mirror.registry-c.com", PullFromMirror: sysregistriesv2.MirrorAll}},
+		},
+	}, config.Registries)
+
+	for _, tt := range []struct {
+		name, data, expected string
+	}{
+		{"strict", yamlTestManifests, `document 1: unsupported kind "ConfigMap"`},
+		{"strict list", "kind: List\nitems:\n- kind: Namespace\n", `document 0: unsupported kind "Namespace"`},
+		{"invalid YAML", "kind: ImageDigestMirrorSet\n  spec: [\n", ""},
+		{"invalid object", "kind: ImageDigestMirrorSet\nspec: []\n", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := sysregistriesv2.V2RegistriesConf{}
+			_, err := EditRegistriesConfigFromYAMLWithOptions(&config, []byte(tt.data), EditOptions{Strict: true})
+			if tt.expected != "" {
+				assert.EqualError(t, err, tt.expected)
+			} else {
+				assert.Error(t, err)
+			}
+			assert.Empty(t, config.Registries)
+		})
+	}
+}